@@ -0,0 +1,112 @@
+// Package admin is the router of the admin listener. It must not be
+// reachable from the internet; the audit log, the catalogue writes and the
+// item grants additionally require the admin token.
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/cats"
+	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/items"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/metrics"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery(), logging.RequestID(), logging.Middleware())
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	auth := r.Group("/", Auth())
+	auth.GET("/audit", GetAudit)
+	auth.PUT("/themes/:theme_id", cats.PutTheme)
+	auth.PUT("/cat_kinds/:cat_kind_id", cats.PutCatKind)
+	auth.POST("/users/:uid/items", items.PostGrant)
+	return r
+}
+
+// Auth accepts "Authorization: Bearer <config.AdminToken>". Everything is
+// rejected if no admin token is configured.
+func Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || config.AdminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			errcode.Abort(c, errcode.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+// GET /audit?uid=&event=&since=&until=&before=&limit=
+//
+// List audit events newest first. since and until are unix times, before is
+// the next_before of the previous page.
+func GetAudit(c *gin.Context) {
+	f := store.AuditFilter{
+		Event: c.Query("event"),
+		Limit: defaultAuditLimit,
+	}
+	for key, dest := range map[string]any{
+		"uid":    &f.UID,
+		"before": &f.Before,
+		"since":  &f.Since,
+		"until":  &f.Until,
+		"limit":  &f.Limit,
+	} {
+		v := c.Query(key)
+		if v == "" {
+			continue
+		}
+		var err error
+		switch d := dest.(type) {
+		case *uint64:
+			*d, err = strconv.ParseUint(v, 10, 64)
+		case *int64:
+			*d, err = strconv.ParseInt(v, 10, 64)
+		case *int:
+			*d, err = strconv.Atoi(v)
+		}
+		if err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if f.Limit <= 0 || f.Limit > maxAuditLimit {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	list, err := audit.Query(f)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	audit.Record(c, audit.AdminQuery, 0, audit.Detail{"filter": c.Request.URL.RawQuery})
+
+	res := struct {
+		Error      string             `json:"error"`
+		List       []store.AuditEvent `json:"list"`
+		NextBefore uint64             `json:"next_before"` // 0 if this is the last page
+	}{List: list}
+	if len(list) == f.Limit {
+		res.NextBefore = list[len(list)-1].AuditID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
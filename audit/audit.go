@@ -0,0 +1,72 @@
+// Package audit records security relevant events, and the points added to
+// a catch by items, into audit_log.
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// events
+const (
+	LoginSuccess   = "login_success"
+	LoginFailure   = "login_failure"
+	LoginRejected  = "login_rejected" // locked or rate limited
+	PasswordChange = "password_change"
+	EmailChange    = "email_change"
+	EmailVerify    = "email_verify"
+	FriendRemove   = "friend_remove"
+	AccountDelete  = "account_delete"
+	AdminQuery     = "admin_audit_query"
+	AdminCatalogue = "admin_catalogue_update" // theme or cat kind
+	AdminItemGrant = "admin_item_grant"
+	ItemBonus      = "item_bonus" // recorded by store.Cats.Catch
+)
+
+var audits store.Audits
+
+// Init sets the repository used by Record and Query.
+func Init(s *store.Store) {
+	audits = s.Audits
+}
+
+// Detail is the extra information of an event, stored as a JSON object.
+type Detail map[string]any
+
+// Record writes an event of uid with the IP and the request ID of c. A
+// failure is logged and does not fail the request.
+func Record(c *gin.Context, event string, uid uint64, detail Detail) {
+	e := Event(c, event, uid, detail)
+	if err := audits.Record(&e); err != nil {
+		logging.From(c).Error("audit record failed", "event", event, "uid", uid, "err", err)
+	}
+}
+
+// Event returns an event of uid with the IP and the request ID of c, for a
+// repository that records it in a transaction of its own.
+func Event(c *gin.Context, event string, uid uint64, detail Detail) store.AuditEvent {
+	if detail == nil {
+		detail = Detail{}
+	}
+	b, err := json.Marshal(detail)
+	if err != nil {
+		b = []byte("{}")
+	}
+	return store.AuditEvent{
+		Timing:    time.Now().Unix(),
+		Event:     event,
+		UID:       uid,
+		IP:        c.ClientIP(),
+		RequestID: logging.ID(c),
+		Detail:    b,
+	}
+}
+
+func Query(f store.AuditFilter) ([]store.AuditEvent, error) {
+	return audits.Query(f)
+}
@@ -0,0 +1,115 @@
+package cats
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlers of the admin listener, they change the catalogue and invalidate
+// its cache
+
+type themeRequest struct {
+	Name        string `json:"name"`
+	Thumbnail   string `json:"thumbnail"`
+	Description string `json:"description"`
+	Starts      int64  `json:"starts"`
+	Ends        int64  `json:"ends"`
+	Recurrence  string `json:"recurrence"`
+	ComingSoon  bool   `json:"coming_soon"`
+}
+
+// PUT /themes/:theme_id
+//
+// A new theme is announced in the inbox of everyone.
+func PutTheme(c *gin.Context) {
+	themeID, ok := util.ParamID(c, "theme_id")
+	if !ok {
+		return
+	}
+	req := themeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	if _, err := parseRecurrence(req.Recurrence); err != nil ||
+		req.Starts < 0 || req.Ends < 0 || (req.Ends != 0 && req.Ends <= req.Starts) {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	_, err := themes.Get(themeID)
+	created := errors.Is(err, store.ErrNotFound)
+	if err != nil && !created {
+		errcode.Abort(c, err)
+		return
+	}
+
+	theme := Theme{
+		ThemeID:     int(themeID),
+		Name:        req.Name,
+		Thumbnail:   req.Thumbnail,
+		Description: req.Description,
+		Starts:      req.Starts,
+		Ends:        req.Ends,
+		Recurrence:  req.Recurrence,
+		ComingSoon:  req.ComingSoon,
+	}
+	if err := themes.Save(theme); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	themeListCache.invalidate()
+	audit.Record(c, audit.AdminCatalogue, 0, audit.Detail{"theme_id": themeID})
+	if created {
+		inbox.Broadcast(c, inbox.NewTheme, inbox.Detail{"theme_id": themeID, "name": req.Name, "starts": req.Starts})
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type catKindRequest struct {
+	Name        string `json:"name"`
+	Thumbnail   string `json:"thumbnail"`
+	Description string `json:"description"`
+	Weight      uint   `json:"weight"`
+}
+
+// PUT /cat_kinds/:cat_kind_id
+//
+// A change of the weight changes the score of everyone who caught a cat of
+// the kind.
+func PutCatKind(c *gin.Context) {
+	kindID, ok := util.ParamID(c, "cat_kind_id")
+	if !ok {
+		return
+	}
+	req := catKindRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	kind := CatKind{
+		CatKindID:   kindID,
+		Weight:      int(req.Weight),
+		Thumbnail:   req.Thumbnail,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := themes.SaveKind(kind); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	catKindsCache.invalidate()
+	audit.Record(c, audit.AdminCatalogue, 0, audit.Detail{"cat_kind_id": kindID, "weight": req.Weight})
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,115 @@
+package cats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Clients may store the catalogue but must revalidate it with If-None-Match
+// before each use, so that an admin write is seen at once and an unchanged
+// catalogue costs a 304 only.
+const cacheControl = "public, no-cache"
+
+// cached is a JSON response that is built once and served with a strong ETag
+// until it is invalidated or expires. It is kept in the process, so an admin
+// write through another instance or straight into the database is seen after
+// a restart.
+type cached struct {
+	name string
+	// load returns the response at now and when it expires, the zero time
+	// if it does not
+	load func(now time.Time) (any, time.Time, error)
+
+	mu      sync.Mutex
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+var (
+	themeListCache = &cached{name: "themes", load: func(now time.Time) (any, time.Time, error) {
+		list, expires, err := themeList(now)
+		return themeListResponse{"", list}, expires, err
+	}}
+	catKindsCache = &cached{name: "cat_kinds", load: func(time.Time) (any, time.Time, error) {
+		list, err := themes.Kinds()
+		return catKindListResponse{"", list}, time.Time{}, err
+	}}
+)
+
+// get returns the body and its ETag, loading them if needed. The lock is held
+// while loading, so an invalidation waits for a load in progress and then
+// drops what it read.
+func (ca *cached) get() ([]byte, string, bool, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	now := time.Now()
+	if ca.body != nil && (ca.expires.IsZero() || now.Before(ca.expires)) {
+		return ca.body, ca.etag, true, nil
+	}
+
+	v, expires, err := ca.load(now)
+	if err != nil {
+		return nil, "", false, err
+	}
+	// the same encoding as c.IndentedJSON
+	body, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return nil, "", false, err
+	}
+	sum := sha256.Sum256(body)
+	ca.body = body
+	ca.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	ca.expires = expires
+	return ca.body, ca.etag, false, nil
+}
+
+func (ca *cached) invalidate() {
+	ca.mu.Lock()
+	ca.body = nil
+	ca.etag = ""
+	ca.mu.Unlock()
+}
+
+func (ca *cached) serve(c *gin.Context) {
+	body, etag, hit, err := ca.get()
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	if matchETag(c.GetHeader("If-None-Match"), etag) {
+		catalogueTotal.Inc(ca.name, "not_modified")
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if hit {
+		catalogueTotal.Inc(ca.name, "hit")
+	} else {
+		catalogueTotal.Inc(ca.name, "miss")
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// matchETag reports whether the If-None-Match header matches etag, comparing
+// weakly as RFC 9110 requires for If-None-Match.
+func matchETag(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
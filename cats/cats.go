@@ -1,180 +1,247 @@
 package cats
 
 import (
-	"database/sql"
-	"fmt"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/feed"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/items"
+	"github.com/ksw2000/catch_cat_server/quests"
+	"github.com/ksw2000/catch_cat_server/referrals"
 	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
 	"github.com/ksw2000/catch_cat_server/util"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-type CatKind struct {
-	CatKindID   uint64 `json:"cat_kind_id"`
-	Weight      int    `json:"weight"`
-	Thumbnail   string `json:"thumbnail"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+type (
+	CatKind       = store.CatKind
+	Cat           = store.Cat
+	CatKindCaught = store.CatKindCaught
+	Theme         = store.Theme
+)
+
+var (
+	cats    store.Cats
+	themes  store.Themes
+	users   store.Users
+	friends store.Friends
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	cats = s.Cats
+	themes = s.Themes
+	users = s.Users
+	friends = s.Friends
 }
 
-func PostTheme(c *gin.Context) {
-	req := struct {
-		Session string `json:"session"`
-		ThemeID uint64 `json:"theme_id"`
-	}{}
-	type Cat struct {
-		CatID    uint64  `json:"cat_id"`
-		Lng      float64 `json:"lng"`
-		Lat      float64 `json:"lat"`
-		IsCaught bool    `json:"is_caught"`
-		CatKind
-	}
-	res := struct {
-		Error   string `json:"error"`
-		CatList []Cat  `json:"cat_list"`
-	}{
-		CatList: []Cat{},
-	}
+// GetThemeList is served from an in-process cache with an ETag, see cache.go.
+func GetThemeList(c *gin.Context) {
+	themeListCache.serve(c)
+}
 
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
+type themeListResponse struct {
+	Error string  `json:"error"`
+	List  []Theme `json:"list"`
+}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		return
-	}
+// GET /v1/cat_kinds
+//
+// The catalogue of the cat kinds, cached like GetThemeList.
+func GetCatKindList(c *gin.Context) {
+	catKindsCache.serve(c)
+}
 
-	db := util.OpenDB()
+type catKindListResponse struct {
+	Error string    `json:"error"`
+	List  []CatKind `json:"list"`
+}
 
-	rows, err := db.Query(`
-		SELECT cat.cat_id, cat.cat_kind_id, cat.lng, cat.lat, 
-		       cat_kind.thumbnail, cat_kind.weight,
-			   cat_kind.description, cat_kind.name
-		FROM cat, cat_kind 
-		WHERE theme_id = ? and cat.cat_kind_id = cat_kind.cat_kind_id`, req.ThemeID)
+// themeList returns the themes listed at now, and when the list may change.
+func themeList(now time.Time) ([]Theme, time.Time, error) {
+	all, err := themes.List()
 	if err != nil {
-		res.Error = fmt.Sprintf("db.Query() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return nil, time.Time{}, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		cat := Cat{}
-		rows.Scan(&cat.CatID, &cat.CatKindID, &cat.Lng, &cat.Lat, &cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name)
-		cat.IsCaught = isCaught(db, uid, cat.CatID)
-		res.CatList = append(res.CatList, cat)
+	list := []Theme{}
+	for _, theme := range all {
+		if listed(theme, now) {
+			theme.Available = live(theme, now)
+			list = append(list, theme)
+		}
 	}
+	return list, nextChange(all, now), nil
+}
 
-	c.IndentedJSON(http.StatusOK, res)
+// LiveThemes returns the themes whose cats can be caught at now.
+func LiveThemes(now time.Time) ([]Theme, error) {
+	all, err := themes.List()
+	if err != nil {
+		return nil, err
+	}
+	list := []Theme{}
+	for _, theme := range all {
+		if live(theme, now) {
+			list = append(list, theme)
+		}
+	}
+	return list, nil
 }
 
-func isCaught(db *sql.DB, uid uint64, catID uint64) bool {
-	var num = 0
-	row := db.QueryRow("SELECT COUNT(*) FROM user_cat WHERE user_id = ? and cat_id = ?", uid, catID)
-	row.Scan(&num)
-	return num > 0
+// liveTheme returns the theme, or ErrThemeNotFound if it does not exist or
+// is not listed, and ErrThemeInactive if it is listed but not live.
+func liveTheme(themeID uint64, now time.Time) (Theme, error) {
+	theme, err := themes.Get(themeID)
+	if errors.Is(err, store.ErrNotFound) {
+		return theme, errcode.ErrThemeNotFound
+	} else if err != nil {
+		return theme, err
+	}
+	if live(theme, now) {
+		return theme, nil
+	}
+	if listed(theme, now) {
+		return theme, errcode.ErrThemeInactive
+	}
+	return theme, errcode.ErrThemeNotFound
 }
 
-func PostCatching(c *gin.Context) {
+func PostTheme(c *gin.Context) {
 	req := struct {
-		Session string `json:"session"`
-		CatID   uint64 `json:"cat_id"`
-	}{}
-	res := struct {
-		Error string `json:"error"`
+		ThemeID uint64 `json:"theme_id"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	respondTheme(c, req.ThemeID)
+}
+
+func respondTheme(c *gin.Context, themeID uint64) {
+	uid := session.UID(c)
+	list, err := themeCats(uid, themeID)
+	if err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	quests.Visited(c, uid, themeID)
 
-	db := util.OpenDB()
+	c.IndentedJSON(http.StatusOK, themeResponse{"", list})
+}
 
-	// insert
-	stmt, err := db.Prepare("INSERT INTO user_cat(user_id, cat_id, timing) values(?, ?, ?)")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+type themeResponse struct {
+	Error   string `json:"error"`
+	CatList []Cat  `json:"cat_list"`
+}
+
+func themeCats(uid uint64, themeID uint64) ([]Cat, error) {
+	if _, err := liveTheme(themeID, time.Now()); err != nil {
+		return nil, err
+	}
+	return cats.ThemeCats(uid, themeID)
+}
+
+type catchRequest struct {
+	CatID uint64 `json:"cat_id"`
+}
+
+func PostCatching(c *gin.Context) {
+	req := catchRequest{}
+	res := util.Response{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
-	defer stmt.Close()
-	now := time.Now().Unix()
-	if _, err = stmt.Exec(uid, req.CatID, now); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+
+	if err := catching(c, session.UID(c), req.CatID); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 	// ok
 	c.IndentedJSON(http.StatusCreated, res)
 }
 
-func PostCaughtKind(c *gin.Context) {
-	req := struct {
-		Session string `json:"session"`
-	}{}
-	type CatKindCaught struct {
-		IsCaught bool `json:"is_caught"`
-		CatKind
-	}
-	res := struct {
-		Error string          `json:"error"`
-		List  []CatKindCaught `json:"list"`
-	}{
-		List: []CatKindCaught{},
+func catching(c *gin.Context, uid uint64, catID uint64) error {
+	cat, err := cats.Get(catID)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrCatNotFound
+	} else if err != nil {
+		return err
 	}
+	// a cat can be caught only while its theme is live
+	theme, err := liveTheme(cat.ThemeID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// a cat can be caught only once by a user
+	caught, completed, bonus, err := cats.Catch(uid, catID, time.Now().Unix(), CompletionReward, items.Boost(c, uid, *cat))
+	if errors.Is(err, store.ErrConflict) {
+		return errcode.ErrTradedAway
+	} else if err != nil {
+		return err
+	} else if !caught {
+		return errcode.ErrAlreadyCaught
+	}
+	catchTotal.Inc(strconv.FormatUint(cat.ThemeID, 10))
+
+	if cat.Weight >= feed.RareWeight {
+		feed.Publish(c, uid, feed.RareCatch, feed.Detail{
+			"cat_id": cat.CatID, "cat_kind_id": cat.CatKindID, "name": cat.Name,
+			"weight": cat.Weight, "theme_id": cat.ThemeID,
+		})
+	}
+	if completed {
+		Completed(c, uid, theme)
+	}
+	referrals.Caught(c, uid)
+	bonus += quests.Caught(c, uid, *cat)
+	if stats, err := users.Stats(uid); err != nil {
+		return err
+	} else if before := (store.Stats{Score: stats.Score - cat.Weight - bonus}); stats.Level() > before.Level() {
+		feed.Publish(c, uid, feed.LevelUp, feed.Detail{"level": stats.Level()})
+		inbox.Add(c, uid, inbox.LevelUp, inbox.Detail{"level": stats.Level()})
+	}
+	return nil
+}
 
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
+// CompletionReward is granted with the first completion of a theme, by a
+// catch or a trade.
+var CompletionReward = store.Stack{ItemID: store.ItemLure, Quantity: 1}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		return
-	}
+// Completed tells uid and the friends of uid that uid completed the theme
+// for the first time. The reward was granted with the completion.
+func Completed(c *gin.Context, uid uint64, theme Theme) {
+	feed.Publish(c, uid, feed.ThemeComplete, feed.Detail{"theme_id": theme.ThemeID, "name": theme.Name})
+	inbox.Add(c, uid, inbox.ThemeComplete, inbox.Detail{"theme_id": theme.ThemeID, "name": theme.Name})
+	items.Granted(CompletionReward.ItemID, CompletionReward.Quantity)
+}
 
-	db := util.OpenDB()
-
-	rows, err := db.Query(`SELECT 
-		cat_kind.cat_kind_id,
-		cat_kind.name,
-		cat_kind.description,
-		cat_kind.weight,
-		cat_kind.thumbnail,
-		user_cat.user_id
-	FROM
-		cat_kind
-	JOIN
-		cat
-	on cat.cat_kind_id = cat_kind.cat_kind_id
-	LEFT JOIN
-		user_cat
-	on cat.cat_id = user_cat.cat_id and user_cat.user_id = ?
-	GROUP BY cat_kind.cat_kind_id
-	ORDER BY cat_kind.cat_kind_id ASC`, uid)
+func PostCaughtKind(c *gin.Context) {
+	list, err := caughtKind(session.UID(c))
 	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+		errcode.Abort(c, err)
 		return
 	}
-	defer rows.Close()
-	for rows.Next() {
-		scanner := CatKindCaught{}
-		var id uint64
-		rows.Scan(&scanner.CatKindID, &scanner.Name, &scanner.Description, &scanner.Weight, &scanner.Thumbnail, &id)
-		scanner.IsCaught = id != 0
-		res.List = append(res.List, scanner)
-	}
 
 	// ok
-	c.IndentedJSON(http.StatusOK, res)
+	c.IndentedJSON(http.StatusOK, caughtKindResponse{"", list})
+}
+
+type caughtKindResponse struct {
+	Error string          `json:"error"`
+	List  []CatKindCaught `json:"list"`
+}
+
+func caughtKind(uid uint64) ([]CatKindCaught, error) {
+	return cats.CaughtKinds(uid)
 }
@@ -0,0 +1,136 @@
+package cats
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// historyRequest is the body of the legacy route and the query of the v1
+// routes, which take the uid from the path instead.
+type historyRequest struct {
+	UID       uint64 `json:"uid" form:"-"` // 0 for myself
+	ThemeID   uint64 `json:"theme_id" form:"theme_id"`
+	CatKindID uint64 `json:"cat_kind_id" form:"cat_kind_id"`
+	Since     int64  `json:"since" form:"since"`
+	Until     int64  `json:"until" form:"until"`
+	Before    string `json:"before" form:"before"` // next_before of the previous page
+	Limit     int    `json:"limit" form:"limit"`
+}
+
+type historyEntry struct {
+	CatID     uint64   `json:"cat_id"`
+	ThemeID   uint64   `json:"theme_id"`
+	ThemeName string   `json:"theme_name"`
+	Lng       *float64 `json:"lng"` // null if a friend does not share the GPS
+	Lat       *float64 `json:"lat"`
+	Timing    int64    `json:"timing"`
+	CatKind
+}
+
+type historyResponse struct {
+	Error      string         `json:"error"`
+	List       []historyEntry `json:"list"`
+	NextBefore string         `json:"next_before"` // "" if this is the last page
+}
+
+func PostCatHistory(c *gin.Context) {
+	req := historyRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	respondHistory(c, req)
+}
+
+func respondHistory(c *gin.Context, req historyRequest) {
+	uid := session.UID(c)
+	if req.UID == 0 {
+		req.UID = uid
+	}
+	res, err := history(uid, req)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+// history returns the catches of req.UID seen by uid. Only accepted friends
+// who do not ban each other can see the history of one another, and the
+// places only if the owner shares the GPS.
+func history(uid uint64, req historyRequest) (*historyResponse, error) {
+	f := store.TimelineFilter{
+		ThemeID:   req.ThemeID,
+		CatKindID: req.CatKindID,
+		Since:     req.Since,
+		Until:     req.Until,
+		Limit:     req.Limit,
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultHistoryLimit
+	}
+	if f.Limit < 0 || f.Limit > maxHistoryLimit {
+		return nil, errcode.ErrBadRequest
+	}
+	if req.Before != "" {
+		if _, err := fmt.Sscanf(req.Before, "%d.%d", &f.BeforeTiming, &f.BeforeCat); err != nil {
+			return nil, errcode.ErrBadRequest
+		}
+	}
+
+	showPlace := true
+	if req.UID != uid {
+		if ok, err := friends.AreFriends(uid, req.UID); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errcode.ErrNotFriend
+		}
+		owner, err := users.Get(req.UID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errcode.ErrNotFriend
+		} else if err != nil {
+			return nil, err
+		}
+		showPlace = owner.ShareGPS
+	}
+
+	list, err := cats.Timeline(req.UID, f)
+	if err != nil {
+		return nil, err
+	}
+	res := &historyResponse{List: []historyEntry{}}
+	for _, e := range list {
+		entry := historyEntry{
+			CatID:     e.CatID,
+			ThemeID:   e.ThemeID,
+			ThemeName: e.ThemeName,
+			Timing:    e.Timing,
+			CatKind:   e.Kind,
+		}
+		if showPlace {
+			lng, lat := e.Lng, e.Lat
+			entry.Lng, entry.Lat = &lng, &lat
+		}
+		res.List = append(res.List, entry)
+	}
+	if len(list) == f.Limit {
+		last := list[len(list)-1]
+		res.NextBefore = fmt.Sprintf("%d.%d", last.Timing, last.CatID)
+	}
+	return res, nil
+}
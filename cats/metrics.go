@@ -0,0 +1,9 @@
+package cats
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var catchTotal = metrics.NewCounter("catch_cat_catches_total",
+	"Cats caught by theme.", "theme_id")
+
+var catalogueTotal = metrics.NewCounter("catch_cat_catalogue_responses_total",
+	"Responses of the cached catalogues by result: hit, miss or not_modified.", "catalogue", "result")
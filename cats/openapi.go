@@ -0,0 +1,64 @@
+package cats
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+	"github.com/ksw2000/catch_cat_server/util"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/themes", Tag: "cat",
+		Summary: "List the themes, 304 if If-None-Match matches the ETag",
+		Status:  http.StatusOK, Response: themeListResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/cat_kinds", Tag: "cat",
+		Summary: "List the cat kinds, 304 if If-None-Match matches the ETag",
+		Status:  http.StatusOK, Response: catKindListResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/themes/:theme_id", Tag: "cat", Auth: true,
+		Summary: "List the cats of a theme and whether I caught them",
+		Status:  http.StatusOK, Response: themeResponse{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/users/me/cats", Tag: "cat", Auth: true,
+		Summary: "Catch a cat",
+		Request: catchRequest{},
+		Status:  http.StatusCreated, Response: util.Response{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/cat_kinds", Tag: "cat", Auth: true,
+		Summary: "List the cat kinds and whether I caught one of each",
+		Status:  http.StatusOK, Response: caughtKindResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/cats", Tag: "cat", Auth: true,
+		Summary: "List my catches, newest first",
+		Query:   historyQuery,
+		Status:  http.StatusOK, Response: historyResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/friends/:uid/cats", Tag: "cat", Auth: true,
+		Summary: "List the catches of a friend, newest first",
+		Query:   historyQuery,
+		Status:  http.StatusOK, Response: historyResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/progress", Tag: "cat", Auth: true,
+		Summary: "My progress in the live themes and the themes I played",
+		Status:  http.StatusOK, Response: progressResponse{},
+	},
+}
+
+var historyQuery = []openapi.Param{
+	{Name: "theme_id", Type: "integer"},
+	{Name: "cat_kind_id", Type: "integer"},
+	{Name: "since", Type: "integer"},
+	{Name: "until", Type: "integer"},
+	{Name: "before", Type: "string"},
+	{Name: "limit", Type: "integer"},
+}
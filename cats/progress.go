@@ -0,0 +1,82 @@
+package cats
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+type themeProgress struct {
+	ThemeID     uint64    `json:"theme_id"`
+	Name        string    `json:"name"`
+	Available   bool      `json:"available"`
+	Cats        int       `json:"cats"`
+	Caught      int       `json:"caught"`
+	Score       int       `json:"score"`
+	TotalScore  int       `json:"total_score"`
+	Percent     int       `json:"percent"`      // rounded down, 100 only if every cat is caught
+	CompletedAt int64     `json:"completed_at"` // 0 if never completed
+	Missing     []CatKind `json:"missing"`
+}
+
+type progressResponse struct {
+	Error string          `json:"error"`
+	List  []themeProgress `json:"list"`
+}
+
+func PostThemeProgress(c *gin.Context) {
+	list, err := progress(session.UID(c), time.Now())
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, progressResponse{"", list})
+}
+
+// progress returns the progress of the live themes and of the other themes in
+// which uid caught something, so that a seasonal theme is not forgotten when
+// it ends.
+func progress(uid uint64, now time.Time) ([]themeProgress, error) {
+	all, err := themes.List()
+	if err != nil {
+		return nil, err
+	}
+	byID := map[uint64]Theme{}
+	for _, theme := range all {
+		byID[uint64(theme.ThemeID)] = theme
+	}
+
+	stats, err := cats.Progress(uid)
+	if err != nil {
+		return nil, err
+	}
+	list := []themeProgress{}
+	for _, p := range stats {
+		theme, ok := byID[p.ThemeID]
+		if !ok {
+			continue
+		}
+		available := live(theme, now)
+		if !available && p.Caught == 0 {
+			continue
+		}
+		list = append(list, themeProgress{
+			ThemeID:     p.ThemeID,
+			Name:        theme.Name,
+			Available:   available,
+			Cats:        p.Cats,
+			Caught:      p.Caught,
+			Score:       p.Score,
+			TotalScore:  p.TotalScore,
+			Percent:     p.Caught * 100 / p.Cats,
+			CompletedAt: p.Completed,
+			Missing:     p.Missing,
+		})
+	}
+	return list, nil
+}
@@ -0,0 +1,137 @@
+package cats
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/config"
+)
+
+// A theme is live between Starts and Ends, on the days of its recurrence in
+// config.Location:
+//
+//	""                    every day
+//	"weekly:sat,sun"      on the given days of the week
+//	"yearly:02-10..02-16" on the given dates every year, both inclusive; the
+//	                      range may wrap around the new year, e.g. "12-24..01-01"
+type recurrence struct {
+	weekdays [7]bool
+	yearly   bool
+	from, to int // month*100 + day
+}
+
+var errRecurrence = errors.New("invalid recurrence")
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseRecurrence(s string) (recurrence, error) {
+	r := recurrence{}
+	if s == "" {
+		r.weekdays = [7]bool{true, true, true, true, true, true, true}
+		return r, nil
+	}
+
+	kind, spec, _ := strings.Cut(s, ":")
+	switch kind {
+	case "weekly":
+		for _, name := range strings.Split(spec, ",") {
+			d, ok := weekdayNames[name]
+			if !ok {
+				return r, errRecurrence
+			}
+			r.weekdays[d] = true
+		}
+	case "yearly":
+		from, to, ok := strings.Cut(spec, "..")
+		if !ok {
+			return r, errRecurrence
+		}
+		var err error
+		if r.from, err = monthDay(from); err != nil {
+			return r, err
+		}
+		if r.to, err = monthDay(to); err != nil {
+			return r, err
+		}
+		r.yearly = true
+	default:
+		return r, errRecurrence
+	}
+	return r, nil
+}
+
+// monthDay parses "MM-DD" into month*100 + day.
+func monthDay(s string) (int, error) {
+	var m, d int
+	if len(s) != 5 {
+		return 0, errRecurrence
+	}
+	if _, err := fmt.Sscanf(s, "%02d-%02d", &m, &d); err != nil || m < 1 || m > 12 || d < 1 || d > 31 {
+		return 0, errRecurrence
+	}
+	return m*100 + d, nil
+}
+
+// on reports whether the day of t is one of the recurrence.
+func (r recurrence) on(t time.Time) bool {
+	if !r.yearly {
+		return r.weekdays[t.Weekday()]
+	}
+	md := int(t.Month())*100 + t.Day()
+	if r.from <= r.to {
+		return r.from <= md && md <= r.to
+	}
+	return md >= r.from || md <= r.to
+}
+
+// live reports whether the cats of the theme can be seen and caught at now.
+// A recurrence which does not parse, which the admin API prevents, is never
+// live.
+func live(theme Theme, now time.Time) bool {
+	if theme.Starts != 0 && now.Unix() < theme.Starts {
+		return false
+	}
+	if theme.Ends != 0 && now.Unix() >= theme.Ends {
+		return false
+	}
+	r, err := parseRecurrence(theme.Recurrence)
+	return err == nil && r.on(now.In(config.Location))
+}
+
+// listed reports whether the theme is in the theme list at now: when it is
+// live, or when it is coming soon and not over yet.
+func listed(theme Theme, now time.Time) bool {
+	if live(theme, now) {
+		return true
+	}
+	return theme.ComingSoon && (theme.Ends == 0 || now.Unix() < theme.Ends)
+}
+
+// nextChange returns when the liveness of one of the themes may change after
+// now, or the zero time if it never does.
+func nextChange(list []Theme, now time.Time) time.Time {
+	next := time.Time{}
+	earlier := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	for _, theme := range list {
+		if theme.Starts != 0 {
+			earlier(time.Unix(theme.Starts, 0))
+		}
+		if theme.Ends != 0 {
+			earlier(time.Unix(theme.Ends, 0))
+		}
+		if theme.Recurrence != "" {
+			y, m, d := now.In(config.Location).Date()
+			earlier(time.Date(y, m, d+1, 0, 0, 0, 0, config.Location))
+		}
+	}
+	return next
+}
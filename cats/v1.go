@@ -0,0 +1,59 @@
+package cats
+
+import (
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlers of the /v1 API, they share the logic with the legacy POST routes
+
+// GET /v1/themes/:theme_id
+func GetTheme(c *gin.Context) {
+	themeID, ok := util.ParamID(c, "theme_id")
+	if !ok {
+		return
+	}
+	respondTheme(c, themeID)
+}
+
+// POST /v1/users/me/cats
+func CreateCatch(c *gin.Context) {
+	PostCatching(c)
+}
+
+// GET /v1/users/me/cat_kinds
+func GetCaughtKind(c *gin.Context) {
+	PostCaughtKind(c)
+}
+
+// GET /v1/users/me/progress
+func GetThemeProgress(c *gin.Context) {
+	PostThemeProgress(c)
+}
+
+// GET /v1/users/me/cats?theme_id=&cat_kind_id=&since=&until=&before=&limit=
+func GetHistory(c *gin.Context) {
+	req := historyRequest{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	respondHistory(c, req)
+}
+
+// GET /v1/friends/:uid/cats?theme_id=&cat_kind_id=&since=&until=&before=&limit=
+func GetFriendHistory(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	req := historyRequest{}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	req.UID = friendUID
+	respondHistory(c, req)
+}
@@ -0,0 +1,339 @@
+// Package challenges lets friends challenge each other, e.g. who catches the
+// most cats of a theme within 48 hours. The creator invites friends, who
+// accept or decline like a friend invitation; the window starts when the
+// challenge is created and the standings are computed from user_cat.timing
+// until it ends, when Run records the result.
+package challenges
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Challenge = store.Challenge
+
+var (
+	challenges store.Challenges
+	friends    store.Friends
+	themes     store.Themes
+	users      store.Users
+)
+
+// Init sets the repositories used by the handlers and Run.
+func Init(s *store.Store) {
+	challenges = s.Challenges
+	friends = s.Friends
+	themes = s.Themes
+	users = s.Users
+}
+
+const (
+	maxInvitees            = 9
+	defaultHours           = 48
+	maxHours               = 7 * 24
+	defaultChallengesLimit = 20
+	maxChallengesLimit     = 100
+)
+
+type createRequest struct {
+	ThemeID uint64   `json:"theme_id"`
+	Goal    string   `json:"goal"`  // count (default) or weight
+	Hours   int      `json:"hours"` // 48 by default
+	UIDs    []uint64 `json:"uids"`  // the friends to invite
+}
+
+type challengeResponse struct {
+	Error     string    `json:"error"`
+	Challenge Challenge `json:"challenge"`
+}
+
+// POST /v1/challenges
+func CreateChallenge(c *gin.Context) {
+	req := createRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	ch, err := create(c, session.UID(c), req)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, challengeResponse{"", *ch})
+}
+
+func create(c *gin.Context, uid uint64, req createRequest) (*Challenge, error) {
+	if req.Goal == "" {
+		req.Goal = store.GoalCount
+	}
+	if req.Hours == 0 {
+		req.Hours = defaultHours
+	}
+	if (req.Goal != store.GoalCount && req.Goal != store.GoalWeight) ||
+		req.Hours < 0 || req.Hours > maxHours ||
+		len(req.UIDs) == 0 || len(req.UIDs) > maxInvitees {
+		return nil, errcode.ErrBadRequest
+	}
+	seen := map[uint64]bool{uid: true}
+	for _, friendUID := range req.UIDs {
+		if seen[friendUID] {
+			return nil, errcode.ErrBadRequest
+		}
+		seen[friendUID] = true
+		if ok, err := friends.AreFriends(uid, friendUID); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errcode.ErrNotFriend
+		}
+	}
+
+	now := time.Now()
+	theme, err := themes.Get(req.ThemeID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errcode.ErrThemeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if theme.Ends != 0 && theme.Ends <= now.Unix() {
+		return nil, errcode.ErrThemeInactive
+	}
+
+	ch := &Challenge{
+		Creator:      uid,
+		ThemeID:      req.ThemeID,
+		Goal:         req.Goal,
+		Starts:       now.Unix(),
+		Ends:         now.Add(time.Duration(req.Hours) * time.Hour).Unix(),
+		Participants: []store.Participant{{UID: uid}},
+	}
+	for _, friendUID := range req.UIDs {
+		ch.Participants = append(ch.Participants, store.Participant{UID: friendUID})
+	}
+	if err := challenges.Create(ch); err != nil {
+		return nil, err
+	}
+	challengeTotal.Inc("created")
+
+	notifyInvitees(c, uid, ch, theme, req.UIDs)
+	return challenges.Get(ch.ChallengeID, now.Unix())
+}
+
+// notifyInvitees tells the friends uid invited to the challenge. The
+// challenge is created already, so a failure is logged and does not fail the
+// request.
+func notifyInvitees(c *gin.Context, uid uint64, ch *Challenge, theme store.Theme, invitees []uint64) {
+	me, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("challenge notice failed", "challenge_id", ch.ChallengeID, "uid", uid, "err", err)
+		return
+	}
+	for _, friendUID := range invitees {
+		inbox.Add(c, friendUID, inbox.ChallengeInvite, inbox.Detail{
+			"challenge_id": ch.ChallengeID,
+			"theme_id":     ch.ThemeID,
+			"uid":          uid,
+			"name":         me.Name,
+		})
+		notify.Enqueue(c, friendUID, notify.ChallengeInvite, notify.Message{
+			Title: "新的挑戰",
+			Body:  me.Name + " 向你發起了 " + theme.Name + " 的挑戰",
+			Data: map[string]string{
+				"challenge_id": strconv.FormatUint(ch.ChallengeID, 10),
+				"uid":          strconv.FormatUint(uid, 10),
+			},
+		})
+	}
+}
+
+type challengesResponse struct {
+	Error      string      `json:"error"`
+	List       []Challenge `json:"list"`
+	NextBefore uint64      `json:"next_before"` // 0 if this is the last page
+}
+
+// GET /v1/challenges?status=&before=&limit=
+//
+// List the challenges I take part in or am invited to, newest first. The
+// scores are filled in once a challenge is finished, GET
+// /v1/challenges/:challenge_id has the live standings.
+func GetChallenges(c *gin.Context) {
+	f := store.ChallengeFilter{
+		UID:    session.UID(c),
+		Status: c.Query("status"),
+		Limit:  defaultChallengesLimit,
+	}
+	switch f.Status {
+	case "", store.ChallengeOpen, store.ChallengeFinished, store.ChallengeExpired:
+	default:
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	var err error
+	if v := c.Query("before"); v != "" {
+		if f.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > maxChallengesLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+
+	list, err := challenges.List(f)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := challengesResponse{List: list}
+	if len(list) == f.Limit {
+		res.NextBefore = list[len(list)-1].ChallengeID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+// GET /v1/challenges/:challenge_id
+//
+// Get a challenge I take part in or am invited to, with the live standings
+// while it is open.
+func GetChallenge(c *gin.Context) {
+	challengeID, ok := util.ParamID(c, "challenge_id")
+	if !ok {
+		return
+	}
+	uid := session.UID(c)
+	now := time.Now().Unix()
+	ch, err := challenges.Get(challengeID, now)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !participates(ch, uid)) {
+		errcode.Abort(c, errcode.ErrChallengeNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	// record the result now rather than waiting for Run
+	if err := finish(challengeID, now); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if ch, err = challenges.Get(challengeID, now); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, challengeResponse{"", *ch})
+}
+
+// participates reports whether uid takes part in or is invited to ch.
+func participates(ch *Challenge, uid uint64) bool {
+	for _, p := range ch.Participants {
+		if p.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /v1/challenges/invitations
+//
+// List the open challenges inviting me.
+func GetInvitations(c *gin.Context) {
+	list, err := challenges.Invitations(session.UID(c), time.Now().Unix())
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, challengesResponse{List: list})
+}
+
+// PUT /v1/challenges/invitations/:challenge_id
+//
+// Accept the invitation of the challenge.
+func AcceptInvitation(c *gin.Context) {
+	challengeID, ok := util.ParamID(c, "challenge_id")
+	if !ok {
+		return
+	}
+	if err := respond(c, session.UID(c), challengeID, true); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /v1/challenges/invitations/:challenge_id
+//
+// Decline the invitation of the challenge.
+func DeclineInvitation(c *gin.Context) {
+	challengeID, ok := util.ParamID(c, "challenge_id")
+	if !ok {
+		return
+	}
+	if err := respond(c, session.UID(c), challengeID, false); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func respond(c *gin.Context, uid uint64, challengeID uint64, accept bool) error {
+	now := time.Now().Unix()
+	err := challenges.Respond(challengeID, uid, accept, now)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrChallengeInvitationNotFound
+	} else if err != nil {
+		return err
+	}
+	if !accept {
+		challengeTotal.Inc("declined")
+		return nil
+	}
+	challengeTotal.Inc("accepted")
+
+	notifyCreator(c, uid, challengeID, now)
+	return nil
+}
+
+// notifyCreator tells the creator of the challenge that uid accepted it. The
+// answer is recorded already, so a failure is logged and does not fail the
+// request.
+func notifyCreator(c *gin.Context, uid uint64, challengeID uint64, now int64) {
+	ch, err := challenges.Get(challengeID, now)
+	if err != nil {
+		logging.From(c).Error("challenge notice failed", "challenge_id", challengeID, "uid", uid, "err", err)
+		return
+	}
+	me, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("challenge notice failed", "challenge_id", challengeID, "uid", uid, "err", err)
+		return
+	}
+	inbox.Add(c, ch.Creator, inbox.ChallengeAccept, inbox.Detail{
+		"challenge_id": challengeID,
+		"uid":          uid,
+		"name":         me.Name,
+	})
+	notify.Enqueue(c, ch.Creator, notify.ChallengeAccept, notify.Message{
+		Title: "挑戰已接受",
+		Body:  me.Name + " 接受了你的挑戰",
+		Data: map[string]string{
+			"challenge_id": strconv.FormatUint(challengeID, 10),
+			"uid":          strconv.FormatUint(uid, 10),
+		},
+	})
+}
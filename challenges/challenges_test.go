@@ -0,0 +1,177 @@
+package challenges
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens a store with the theme 1 for the handlers and creates alice,
+// bob and carol; alice is friends with bob and carol.
+func setup(t *testing.T) (*store.Store, []uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	inbox.Init(s)
+	notify.Init(s)
+
+	if err := s.Themes.Save(store.Theme{ThemeID: 1, Name: "theme"}); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	if err := s.Themes.Save(store.Theme{ThemeID: 2, Name: "over", Ends: time.Now().Unix() - 1}); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	uids := []uint64{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+	for _, friend := range uids[1:] {
+		if err := s.Friends.Insert(store.Relation{Src: uids[0], Dest: friend}); err != nil {
+			t.Fatalf("Insert friend: %v", err)
+		}
+		if err := s.Friends.Accept(friend, uids[0]); err != nil {
+			t.Fatalf("Accept friend: %v", err)
+		}
+	}
+	return s, uids
+}
+
+func testContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+// brokenUsers fails to read any user.
+type brokenUsers struct {
+	store.Users
+}
+
+func (brokenUsers) Get(uid uint64) (*store.User, error) {
+	return nil, errors.New("broken")
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     func(uids []uint64) createRequest
+		wantErr error
+	}{
+		{name: "defaults",
+			req: func(uids []uint64) createRequest { return createRequest{ThemeID: 1, UIDs: uids[1:]} }},
+		{name: "weight",
+			req: func(uids []uint64) createRequest {
+				return createRequest{ThemeID: 1, Goal: store.GoalWeight, Hours: maxHours, UIDs: uids[1:2]}
+			}},
+		{name: "unknown goal",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 1, Goal: "luck", UIDs: uids[1:]} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "too long",
+			req: func(uids []uint64) createRequest {
+				return createRequest{ThemeID: 1, Hours: maxHours + 1, UIDs: uids[1:]}
+			},
+			wantErr: errcode.ErrBadRequest},
+		{name: "nobody invited",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 1} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "self",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 1, UIDs: uids[:1]} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "twice",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 1, UIDs: []uint64{uids[1], uids[1]}} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "not friends",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 1, UIDs: []uint64{uids[2] + 100}} },
+			wantErr: errcode.ErrNotFriend},
+		{name: "unknown theme",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 3, UIDs: uids[1:]} },
+			wantErr: errcode.ErrThemeNotFound},
+		{name: "theme over",
+			req:     func(uids []uint64) createRequest { return createRequest{ThemeID: 2, UIDs: uids[1:]} },
+			wantErr: errcode.ErrThemeInactive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, uids := setup(t)
+			req := tt.req(uids)
+			ch, err := create(testContext(), uids[0], req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("create: err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(ch.Participants) != len(req.UIDs)+1 {
+				t.Errorf("%d participants, want %d", len(ch.Participants), len(req.UIDs)+1)
+			}
+			for _, p := range ch.Participants {
+				want := store.ParticipantInvited
+				if p.UID == uids[0] {
+					want = store.ParticipantAccepted
+				}
+				if p.Status != want {
+					t.Errorf("status of %d = %s, want %s", p.UID, p.Status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	s, uids := setup(t)
+	alice, bob, carol := uids[0], uids[1], uids[2]
+	// the challenge is created and answered even if the notices fail
+	users = brokenUsers{s.Users}
+	defer func() { users = s.Users }()
+	ch, err := create(testContext(), alice, createRequest{ThemeID: 1, UIDs: []uint64{bob, carol}})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		uid     uint64
+		accept  bool
+		wantErr error
+	}{
+		{"creator", alice, true, errcode.ErrChallengeInvitationNotFound},
+		{"accept", bob, true, nil},
+		{"accept again", bob, true, errcode.ErrChallengeInvitationNotFound},
+		{"decline", carol, false, nil},
+		{"accept after declining", carol, true, errcode.ErrChallengeInvitationNotFound},
+	}
+	for _, tt := range tests {
+		if err := respond(testContext(), tt.uid, ch.ChallengeID, tt.accept); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	got, err := s.Challenges.Get(ch.ChallengeID, time.Now().Unix())
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	want := map[uint64]string{alice: store.ParticipantAccepted, bob: store.ParticipantAccepted, carol: store.ParticipantDeclined}
+	for _, p := range got.Participants {
+		if p.Status != want[p.UID] {
+			t.Errorf("status of %d = %s, want %s", p.UID, p.Status, want[p.UID])
+		}
+	}
+}
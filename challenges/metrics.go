@@ -0,0 +1,6 @@
+package challenges
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var challengeTotal = metrics.NewCounter("catch_cat_challenges_total",
+	"Challenges by result: created, accepted, declined, finished or expired.", "result")
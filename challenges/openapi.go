@@ -0,0 +1,47 @@
+package challenges
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPost, Path: "/v1/challenges", Tag: "challenge", Auth: true,
+		Summary: "Challenge friends to catch the most cats of a theme",
+		Request: createRequest{},
+		Status:  http.StatusCreated, Response: challengeResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/challenges", Tag: "challenge", Auth: true,
+		Summary: "List the challenges I take part in or am invited to, newest first",
+		Query: []openapi.Param{
+			{Name: "status", Type: "string"},
+			{Name: "before", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: challengesResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/challenges/:challenge_id", Tag: "challenge", Auth: true,
+		Summary: "Get a challenge with its standings or result",
+		Status:  http.StatusOK, Response: challengeResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/challenges/invitations", Tag: "challenge", Auth: true,
+		Summary: "List the open challenges inviting me",
+		Status:  http.StatusOK, Response: challengesResponse{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/challenges/invitations/:challenge_id", Tag: "challenge", Auth: true,
+		Summary: "Accept the invitation of a challenge",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/challenges/invitations/:challenge_id", Tag: "challenge", Auth: true,
+		Summary: "Decline the invitation of a challenge",
+		Status:  http.StatusNoContent,
+	},
+}
@@ -0,0 +1,75 @@
+package challenges
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/store"
+)
+
+const (
+	pollInterval = time.Minute
+	batchSize    = 50
+)
+
+// Run records the results of the ended challenges until ctx is done. A
+// challenge is also finished when it is read after it ends, whichever comes
+// first.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now().Unix()
+		list, err := challenges.Due(now, batchSize)
+		if err != nil {
+			slog.Error("challenge due failed", "err", err)
+		}
+		for _, challengeID := range list {
+			if err := finish(challengeID, now); err != nil {
+				slog.Error("challenge finish failed", "challenge_id", challengeID, "err", err)
+			}
+		}
+		// a full batch means more may be due
+		if len(list) == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finish records the result of the challenge if it has ended, and tells the
+// participants who accepted.
+func finish(challengeID uint64, now int64) error {
+	ok, err := challenges.Finish(challengeID, now)
+	if err != nil || !ok {
+		return err
+	}
+	ch, err := challenges.Get(challengeID, now)
+	if err != nil {
+		return err
+	}
+	challengeTotal.Inc(ch.Status)
+
+	for _, p := range ch.Participants {
+		if p.Status != store.ParticipantAccepted {
+			continue
+		}
+		if err := inbox.Put(p.UID, inbox.ChallengeResult, inbox.Detail{
+			"challenge_id": challengeID,
+			"status":       ch.Status,
+			"place":        p.Place,
+			"score":        p.Score,
+		}); err != nil {
+			slog.Error("inbox add failed", "kind", inbox.ChallengeResult, "uid", p.UID, "err", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package errcode
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksw2000/catch_cat_server/logging"
+)
+
+const (
+	LangZhTW = "zh-TW"
+	LangEn   = "en"
+)
+
+// Error is an entry of the error catalogue. Code is stable and is what
+// clients should match on; the message is localized by Accept-Language.
+type Error struct {
+	Code   string
+	Status int
+	ZhTW   string
+	En     string
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+// Retry attaches the time after which the client may try again, Abort sends it
+// in the Retry-After header.
+func (e *Error) Retry(after time.Duration) error {
+	return &retryError{e, after}
+}
+
+type retryError struct {
+	err   *Error
+	after time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Code
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// FieldError tells why a field of the request body is invalid. Field is the
+// JSON path, e.g. "list[0].uid"; Reason is "required", "invalid_json",
+// "minimum" or "expected_<type>".
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Fields attaches the invalid fields, Abort sends them in the "fields" key.
+func (e *Error) Fields(fields []FieldError) error {
+	return &fieldsError{e, fields}
+}
+
+type fieldsError struct {
+	err    *Error
+	fields []FieldError
+}
+
+func (e *fieldsError) Error() string {
+	return e.err.Code
+}
+
+func (e *fieldsError) Unwrap() error {
+	return e.err
+}
+
+func (e *Error) Message(lang string) string {
+	if lang == LangEn {
+		return e.En
+	}
+	return e.ZhTW
+}
+
+var (
+	// general
+	ErrBadRequest = &Error{"bad_request", http.StatusBadRequest, "請求格式錯誤", "Malformed request"}
+	ErrNotLogin   = &Error{"not_login", http.StatusUnauthorized, "未登入", "Not logged in"}
+	ErrInternal   = &Error{"internal", http.StatusInternalServerError, "伺服器錯誤，請稍後再試", "Internal server error, please try again later"}
+
+	ErrTooManyRequests = &Error{"too_many_requests", http.StatusTooManyRequests, "請求過於頻繁，請稍後再試", "Too many requests, please try again later"}
+	ErrForbidden       = &Error{"forbidden", http.StatusForbidden, "沒有權限", "Forbidden"}
+
+	// user
+	ErrNameLength         = &Error{"name_length", http.StatusBadRequest, "名稱需介於 1~12 字元", "Name must be 1 to 12 characters"}
+	ErrPasswordMismatch   = &Error{"password_mismatch", http.StatusBadRequest, "密碼與確認密碼不符", "Password and confirmation do not match"}
+	ErrPasswordFormat     = &Error{"password_format", http.StatusBadRequest, "密碼僅接受「英文字母、數字、-、_、@」且介於 8 到 40 字元", "Password may only contain letters, digits, -, _ and @, and must be 8 to 40 characters"}
+	ErrPasswordNeedDigit  = &Error{"password_need_digit", http.StatusBadRequest, "密碼必需含有數字", "Password must contain a digit"}
+	ErrPasswordNeedLetter = &Error{"password_need_letter", http.StatusBadRequest, "密碼必需含有英文字母", "Password must contain a letter"}
+	ErrEmailFormat        = &Error{"email_format", http.StatusBadRequest, "Email 格式錯誤", "Invalid email address"}
+	ErrEmailRegistered    = &Error{"email_registered", http.StatusConflict, "Email 已經註冊", "Email is already registered"}
+	ErrInvalidCredentials = &Error{"invalid_credentials", http.StatusUnauthorized, "帳號或密碼錯誤", "Wrong email or password"}
+	ErrAccountLocked      = &Error{"account_locked", http.StatusTooManyRequests, "登入失敗次數過多，請稍後再試", "Too many failed logins, please try again later"}
+	ErrOriginalPassword   = &Error{"wrong_original_password", http.StatusForbidden, "原密碼錯誤", "Current password is wrong"}
+	ErrWrongPassword      = &Error{"wrong_password", http.StatusForbidden, "密碼錯誤", "Wrong password"}
+	ErrVerifyToken        = &Error{"invalid_verify_token", http.StatusNotFound, "驗證連結無效或已過期", "The verification link is invalid or has expired"}
+	ErrAlreadyVerified    = &Error{"already_verified", http.StatusConflict, "Email 已經驗證過了", "Your email is already verified"}
+	ErrReferralCode       = &Error{"invalid_referral_code", http.StatusBadRequest, "推薦碼錯誤", "Invalid referral code"}
+	ErrReferralLimit      = &Error{"referral_limit", http.StatusConflict, "這個推薦碼今天的使用次數已達上限", "The referral code has been used too many times today"}
+	ErrTimezone           = &Error{"invalid_timezone", http.StatusBadRequest, "無效的時區", "Invalid time zone, use an IANA name such as Asia/Taipei"}
+	ErrTimezoneLimit      = &Error{"timezone_limit", http.StatusTooManyRequests, "時區每 7 天只能修改一次", "The time zone can be changed once in 7 days"}
+
+	// friend
+	ErrInviteSelf         = &Error{"invite_self", http.StatusBadRequest, "不可以邀請自己", "You cannot invite yourself"}
+	ErrUserNotFound       = &Error{"user_not_found", http.StatusNotFound, "找不到 ID", "User ID not found"}
+	ErrInviteUnavailable  = &Error{"invite_unavailable", http.StatusConflict, "找不到 ID 或對方已邀請你", "User ID not found or the user has already invited you"}
+	ErrAlreadyFriend      = &Error{"already_friend", http.StatusConflict, "已經是好友了", "You are already friends"}
+	ErrInvitationNotFound = &Error{"invitation_not_found", http.StatusNotFound, "無此邀請", "Invitation not found"}
+	ErrNotFriend          = &Error{"not_friend", http.StatusForbidden, "你們不是好友", "You are not friends"}
+	ErrMessageLength      = &Error{"message_length", http.StatusBadRequest, "訊息需介於 1~1000 字元", "Message must be 1 to 1000 characters"}
+
+	// cat
+	ErrCatNotFound   = &Error{"cat_not_found", http.StatusNotFound, "找不到這隻貓", "Cat not found"}
+	ErrAlreadyCaught = &Error{"already_caught", http.StatusConflict, "已經抓過這隻貓了", "You have already caught this cat"}
+	ErrThemeNotFound = &Error{"theme_not_found", http.StatusNotFound, "找不到這個主題", "Theme not found"}
+	ErrThemeInactive = &Error{"theme_inactive", http.StatusForbidden, "主題目前未開放", "The theme is not open now"}
+
+	// trade
+	ErrTradeNotFound    = &Error{"trade_not_found", http.StatusNotFound, "找不到交易或交易已結束", "Trade not found or already closed"}
+	ErrTradeUnavailable = &Error{"trade_unavailable", http.StatusConflict, "交易的貓不屬於原本的主人，或對方已經有這隻貓", "A cat of the trade is not owned by its giver or is already owned by its receiver"}
+	ErrTradedAway       = &Error{"traded_away", http.StatusConflict, "這隻貓已經交易給別人了，不能再抓", "You traded this cat away and cannot catch it again"}
+
+	// notification
+	ErrDeviceNotFound       = &Error{"device_not_found", http.StatusNotFound, "找不到這個裝置", "Device not found"}
+	ErrNotificationNotFound = &Error{"notification_not_found", http.StatusNotFound, "找不到這則通知", "Notification not found"}
+
+	// team
+	ErrTeamNotFound           = &Error{"team_not_found", http.StatusNotFound, "找不到這個隊伍", "Team not found"}
+	ErrAlreadyInTeam          = &Error{"already_in_team", http.StatusConflict, "已經加入隊伍了", "You or the user are already in a team"}
+	ErrTeamForbidden          = &Error{"team_forbidden", http.StatusForbidden, "你在隊伍中的權限不足", "Your role in the team does not allow this"}
+	ErrTeamInvitationNotFound = &Error{"team_invitation_not_found", http.StatusNotFound, "無此隊伍邀請", "Team invitation not found"}
+	ErrTeamNameLength         = &Error{"team_name_length", http.StatusBadRequest, "隊伍名稱需介於 1~30 字元", "Team name must be 1 to 30 characters"}
+
+	// challenge
+	ErrChallengeNotFound           = &Error{"challenge_not_found", http.StatusNotFound, "找不到這個挑戰", "Challenge not found"}
+	ErrChallengeInvitationNotFound = &Error{"challenge_invitation_not_found", http.StatusNotFound, "無此挑戰邀請或挑戰已結束", "Challenge invitation not found or the challenge has ended"}
+
+	// item
+	ErrItemNotFound = &Error{"item_not_found", http.StatusNotFound, "沒有這種道具", "Item not found"}
+	ErrOutOfItem    = &Error{"out_of_item", http.StatusConflict, "道具不足", "You have none of this item left"}
+	ErrNoCatNearby  = &Error{"no_cat_nearby", http.StatusNotFound, "附近沒有還沒抓到的貓", "No cat left to catch in the open themes"}
+	ErrOutOfReach   = &Error{"out_of_reach", http.StatusForbidden, "位置離你最後的位置太遠", "The position is too far from your last location"}
+
+	// upload
+	ErrUploadMissing = &Error{"upload_missing", http.StatusBadRequest, "未附加檔案", "No file attached"}
+	ErrProfile       = &Error{"invalid_profile", http.StatusBadRequest, "頭貼必須是自己上傳的檔案", "The profile must be a file you uploaded"}
+)
+
+// Abort writes err to the client and stops the handler chain. Any error that
+// is not from the catalogue is logged and reported as ErrInternal so that
+// database internals never reach the client.
+func Abort(c *gin.Context, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		logging.From(c).Error("internal error", "method", c.Request.Method, "path", c.Request.URL.Path, "err", err)
+		e = ErrInternal
+	}
+	var r *retryError
+	if errors.As(err, &r) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(r.after.Seconds()))))
+	}
+	body := Body{Code: e.Code, Error: e.Message(Lang(c))}
+	var f *fieldsError
+	if errors.As(err, &f) {
+		body.Fields = f.fields
+	}
+	c.Abort()
+	c.IndentedJSON(e.Status, body)
+}
+
+// Body is the response of an error.
+type Body struct {
+	Code   string       `json:"code"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"` // only for invalid request bodies
+}
+
+// Lang picks the response language from the Accept-Language header.
+// Traditional Chinese is the default.
+func Lang(c *gin.Context) string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	tags := []tag{}
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		t := tag{lang: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(f), "q="); ok {
+				if q, err := strconv.ParseFloat(v, 64); err == nil {
+					t.q = q
+				}
+			}
+		}
+		tags = append(tags, t)
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	for _, t := range tags {
+		switch {
+		case t.q <= 0:
+		case t.lang == "en" || strings.HasPrefix(t.lang, "en-"):
+			return LangEn
+		case t.lang == "zh" || strings.HasPrefix(t.lang, "zh-"):
+			return LangZhTW
+		}
+	}
+	return LangZhTW
+}
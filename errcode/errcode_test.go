@@ -0,0 +1,83 @@
+package errcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLang(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", LangZhTW},
+		{"en", LangEn},
+		{"en-US,en;q=0.9", LangEn},
+		{"zh-TW,zh;q=0.9,en;q=0.8", LangZhTW},
+		{"fr, en;q=0.5", LangEn},
+		{"zh;q=0.5, en;q=0.8", LangEn},
+		{"en;q=0, zh", LangZhTW},
+		{"fr", LangZhTW},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("Accept-Language", tt.header)
+		if got := Lang(c); got != tt.want {
+			t.Errorf("Lang(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		lang       string
+		wantStatus int
+		wantBody   Body
+		wantRetry  string
+	}{
+		{"catalogue", ErrCatNotFound, "", http.StatusNotFound,
+			Body{Code: "cat_not_found", Error: ErrCatNotFound.ZhTW}, ""},
+		{"english", ErrCatNotFound, "en", http.StatusNotFound,
+			Body{Code: "cat_not_found", Error: ErrCatNotFound.En}, ""},
+		{"wrapped", fmt.Errorf("catch: %w", ErrAlreadyCaught), "en", http.StatusConflict,
+			Body{Code: "already_caught", Error: ErrAlreadyCaught.En}, ""},
+		{"retry rounded up", ErrTooManyRequests.Retry(1500 * time.Millisecond), "en", http.StatusTooManyRequests,
+			Body{Code: "too_many_requests", Error: ErrTooManyRequests.En}, "2"},
+		{"fields", ErrBadRequest.Fields([]FieldError{{"uid", "required"}}), "en", http.StatusBadRequest,
+			Body{Code: "bad_request", Error: ErrBadRequest.En, Fields: []FieldError{{"uid", "required"}}}, ""},
+		{"internal", errors.New("database is locked"), "en", http.StatusInternalServerError,
+			Body{Code: "internal", Error: ErrInternal.En}, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("Accept-Language", tt.lang)
+		Abort(c, tt.err)
+
+		got := Body{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if w.Code != tt.wantStatus || !reflect.DeepEqual(got, tt.wantBody) {
+			t.Errorf("%s: %d %+v, want %d %+v", tt.name, w.Code, got, tt.wantStatus, tt.wantBody)
+		}
+		if retry := w.Header().Get("Retry-After"); retry != tt.wantRetry {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.name, retry, tt.wantRetry)
+		}
+		if !c.IsAborted() {
+			t.Errorf("%s: chain not aborted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package feed publishes the activities of users to the feeds of their
+// friends and serves the feed.
+package feed
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// kinds of activities
+const (
+	RareCatch     = "rare_catch"     // cat_id, cat_kind_id, name, weight, theme_id
+	ThemeComplete = "theme_complete" // theme_id, name
+	LevelUp       = "level_up"       // level
+	NewFriend     = "new_friend"     // friend_uid, name
+)
+
+// RareWeight is the lowest weight of a rare kind.
+const RareWeight = 50
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+var activities store.Activities
+
+// Init sets the repository used by Publish and the handlers.
+func Init(s *store.Store) {
+	activities = s.Activities
+}
+
+type Activity = store.Activity
+
+// Detail is the extra information of an activity, stored as a JSON object.
+type Detail map[string]any
+
+// Publish copies an activity of uid into the feeds of the friends of uid. A
+// failure is logged and does not fail the request.
+func Publish(c *gin.Context, uid uint64, kind string, detail Detail) {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		b = []byte("{}")
+	}
+	if err := activities.Publish(uid, kind, b, time.Now().Unix()); err != nil {
+		logging.From(c).Error("activity publish failed", "kind", kind, "uid", uid, "err", err)
+	}
+}
+
+type feedResponse struct {
+	Error      string     `json:"error"`
+	List       []Activity `json:"list"`
+	NextBefore uint64     `json:"next_before"` // 0 if this is the last page
+}
+
+// GET /v1/feed?before=&limit=
+//
+// List the activities of my friends, newest first.
+func GetFeed(c *gin.Context) {
+	before := uint64(0)
+	limit := defaultFeedLimit
+	var err error
+	if v := c.Query("before"); v != "" {
+		if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxFeedLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+
+	list, err := activities.Feed(session.UID(c), before, limit)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := feedResponse{List: list}
+	if len(list) == limit {
+		res.NextBefore = list[len(list)-1].ActivityID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
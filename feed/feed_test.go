@@ -0,0 +1,108 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers with alice and bob, friends of
+// each other, and alice shares her activities.
+func setup(t *testing.T) (alice uint64, bob uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+
+	uids := []uint64{}
+	for _, name := range []string{"alice", "bob"} {
+		u := &store.User{Name: name, Email: name + "@example.com", ShareActivity: true}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+	if err := s.Friends.Insert(store.Relation{Src: uids[0], Dest: uids[1]}); err != nil {
+		t.Fatalf("Insert friend: %v", err)
+	}
+	if err := s.Friends.Accept(uids[1], uids[0]); err != nil {
+		t.Fatalf("Accept friend: %v", err)
+	}
+	return uids[0], uids[1]
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+func TestGetFeed(t *testing.T) {
+	alice, bob := setup(t)
+	Publish(context(), alice, LevelUp, Detail{"level": 2})
+	Publish(context(), alice, LevelUp, Detail{"level": 3})
+	Publish(context(), alice, NewFriend, Detail{"friend_uid": bob, "name": "bob"})
+
+	r := gin.New()
+	r.GET("/v1/feed", session.Auth(), GetFeed)
+	token := session.NewSession(bob)
+	get := func(query string) (int, feedResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v1/feed"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		r.ServeHTTP(w, req)
+		res := feedResponse{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return w.Code, res
+	}
+	details := func(list []Activity) []string {
+		got := []string{}
+		for _, a := range list {
+			// the response is indented, detail included
+			b := bytes.Buffer{}
+			if err := json.Compact(&b, a.Detail); err != nil {
+				t.Fatalf("detail: %v", err)
+			}
+			got = append(got, b.String())
+		}
+		return got
+	}
+
+	// the pages are followed by next_before until it is 0
+	status, res := get("?limit=2")
+	if status != http.StatusOK {
+		t.Fatalf("first page: status = %d, want %d", status, http.StatusOK)
+	}
+	want := []string{`{"friend_uid":` + strconv.FormatUint(bob, 10) + `,"name":"bob"}`, `{"level":3}`}
+	if got := details(res.List); !reflect.DeepEqual(got, want) || res.NextBefore != res.List[1].ActivityID {
+		t.Fatalf("first page = %v next %d, want %v next the last one", got, res.NextBefore, want)
+	}
+	status, res = get("?limit=2&before=" + strconv.FormatUint(res.NextBefore, 10))
+	if got, want := details(res.List), []string{`{"level":2}`}; status != http.StatusOK || !reflect.DeepEqual(got, want) || res.NextBefore != 0 {
+		t.Errorf("second page = %d %v next %d, want %v next 0", status, got, res.NextBefore, want)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?limit=x", "?before=-1"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package feed
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/feed", Tag: "friend", Auth: true,
+		Summary: "List the activities of my friends, newest first",
+		Query: []openapi.Param{
+			{Name: "before", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: feedResponse{},
+	},
+}
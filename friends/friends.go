@@ -1,110 +1,99 @@
 package friends
 
 import (
-	"database/sql"
-	"fmt"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/feed"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/notify"
 	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
 	"github.com/ksw2000/catch_cat_server/util"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-type Friend struct {
-	Name       string  `json:"name"`
-	Uid        uint64  `json:"uid"`
-	Profile    string  `json:"profile"`
-	Level      int     `json:"level"`
-	Score      int     `json:"score"`
-	Cats       int     `json:"cats"`
-	LastLogin  int     `json:"last_login"`
-	ThemeScore int     `json:"theme_score"` // optional
-	ThemeCats  int     `json:"theme_cats"`  // optional
-	Lat        float64 `json:"lat"`         // optional
-	Lng        float64 `json:"lng"`         // optional
+type Friend = store.Friend
+
+var (
+	users   store.Users
+	friends store.Friends
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	users = s.Users
+	friends = s.Friends
+}
+
+type inviteRequest struct {
+	FindingUID uint64 `json:"finding_uid"`
 }
 
 func PostFriendInvite(c *gin.Context) {
-	req := struct {
-		Session    string `json:"session"`
-		FindingUID uint64 `json:"finding_uid"`
-	}{}
-	res := struct {
-		Error string `json:"error"`
-	}{}
-	if err := c.BindJSON(&req); err != nil {
+	req := inviteRequest{}
+	res := util.Response{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	db := util.OpenDB()
-
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := invite(c, session.UID(c), req.FindingUID); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	if uid == req.FindingUID {
-		res.Error = "不可以邀請自己"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	// ok
+	c.IndentedJSON(http.StatusCreated, res)
+}
+
+func invite(c *gin.Context, uid uint64, findingUID uint64) error {
+	if uid == findingUID {
+		return errcode.ErrInviteSelf
+	}
+
+	// check if already friend or inviting, before the other direction
+	// which exists for friends as well
+	if _, err := friends.Get(uid, findingUID); err == nil {
+		return errcode.ErrAlreadyFriend
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
 	}
 
 	// uid cannot invite finding_id who has already invited uid or banned uid
-	row := db.QueryRow("SELECT COUNT(*) FROM friend WHERE `user_id_dest` = ? and `user_id_src` = ?", uid, req.FindingUID)
-	var num int
-	if err := row.Scan(&num); err != nil {
-		res.Error = "row.Scan() error" + err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	} else if num > 0 {
+	if _, err := friends.Get(findingUID, uid); err == nil {
 		// user is banned by finding_id
 		// or finding_id invited uid
-		res.Error = "找不到 ID 或對方已邀請你"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return errcode.ErrInviteUnavailable
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
 	}
 
 	// check if friend_id existed
-	row = db.QueryRow("SELECT COUNT(*) FROM user WHERE `user_id` = ?", req.FindingUID)
-	if err := row.Scan(&num); err != nil {
-		res.Error = "database error row.Scan() error"
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	} else if num == 0 {
-		res.Error = "找不到 ID"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if exist, err := users.Exists(findingUID); err != nil {
+		return err
+	} else if !exist {
+		return errcode.ErrUserNotFound
 	}
 
-	// check if already friend
-	row = db.QueryRow("SELECT COUNT(*) FROM friend WHERE `user_id_src` = ? and `user_id_dest` = ?", uid, req.FindingUID)
-	if err := row.Scan(&num); err != nil {
-		res.Error = "database error row.Scan() error"
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	} else if num > 0 {
-		res.Error = "已經是好友了"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if err := friends.Insert(store.Relation{Src: uid, Dest: findingUID}); err != nil {
+		return err
 	}
 
-	// insert
-	stmt, err := db.Prepare("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)")
+	me, err := users.Get(uid)
 	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return err
 	}
-	defer stmt.Close()
-	if _, err = stmt.Exec(uid, req.FindingUID, false, false); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-
-	// ok
-	c.IndentedJSON(http.StatusCreated, res)
+	inbox.Add(c, findingUID, inbox.FriendInvite, inbox.Detail{"uid": uid, "name": me.Name})
+	notify.Enqueue(c, findingUID, notify.FriendInvite, notify.Message{
+		Title: "新的好友邀請",
+		Body:  me.Name + " 想成為你的好友",
+		Data:  map[string]string{"uid": strconv.FormatUint(uid, 10)},
+	})
+	return nil
 }
 
 const (
@@ -116,175 +105,43 @@ const (
 
 func postFriends(c *gin.Context, status int) {
 	req := struct {
-		Session string `json:"session"`
-		ThemeID int    `json:"theme_id"`
+		ThemeID int `json:"theme_id"`
 	}{}
 
-	res := struct {
-		Error string   `json:"error"`
-		List  []Friend `json:"list"`
-	}{
-		List: []Friend{},
-	}
-
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
-
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	db := util.OpenDB()
-
-	var err error
-	var rows *sql.Rows
-
-	if status == friendList {
-		rows, err = db.Query(`
-			SELECT 
-				friend.user_id_dest as fid,
-				user.name,
-				user.profile,
-				user.last_login
-			FROM friend, user
-			WHERE 
-				user.user_id = friend.user_id_dest and
-				friend.accepted = 1 and
-				friend.ban = 0     and
-				friend.user_id_src = ?
-		`, uid)
-	} else if status == invitingMeList {
-		rows, err = db.Query(`
-			SELECT 
-				friend.user_id_src as fid,
-				user.name,
-				user.profile,
-				user.last_login
-			FROM friend, user
-			WHERE 
-				user.user_id = friend.user_id_src and
-				friend.accepted = 0 and
-				friend.ban = 0   and
-				friend.user_id_dest = ?
-		`, uid)
-	} else if status == friendPosition {
-		// rows, err = db.Query(`
-		// 	SELECT
-		// 		friend.user_id_dest as fid,
-		// 		user.name,
-		// 		user.profile,
-		// 		user.last_login,
-		// 		user.last_lat,
-		// 		user.last_lng
-		// 	FROM friend, user
-		// 	WHERE
-		// 		user.user_id = friend.user_id_dest and
-		// 		friend.accepted = 1 and
-		// 		friend.ban = 0      and
-		// 		user.share_gps = 1  and
-		// 		friend.user_id_src = ?
-		// `, uid)
-		rows, err = db.Query(`
-			SELECT
-				ta.*,
-				SUM(tb.weight) as score,
-				COUNT(tb.cat_id) as cats
-			FROM
-				(
-					SELECT
-						user.user_id,
-						user.name,
-						user.profile,
-						user.last_login,
-						user.last_lat,
-						user.last_lng
-						FROM user, friend
-					WHERE 
-						friend.user_id_src = ? and
-						friend.user_id_dest = user.user_id and 
-						friend.accepted = 1 AND
-						friend.ban = 0
-				) as ta
-			LEFT JOIN
-				(
-					SELECT *
-					FROM user_cat, cat, cat_kind
-					WHERE
-						user_cat.cat_id = cat.cat_id and
-						cat.cat_kind_id = cat_kind.cat_kind_id and
-						cat.theme_id = ?
-				) as tb
-				ON
-					tb.user_id = ta.user_id
-			GROUP BY ta.user_id
-			ORDER BY score DESC`, uid, req.ThemeID)
-	} else if status == themeRank {
-		rows, err = db.Query(`
-			SELECT
-				ta.*,
-				SUM(tb.weight) as score,
-				COUNT(tb.cat_id) as cats
-			FROM
-				(
-					SELECT
-						user.user_id,
-						user.name,
-						user.profile,
-						user.last_login
-						FROM user, friend
-					WHERE 
-						(
-							friend.user_id_src = ? and
-							friend.user_id_dest = user.user_id and 
-							friend.accepted = 1 AND
-							friend.ban = 0
-						) UNION
-					SELECT
-						user.user_id,
-						user.name,
-						user.profile,
-						user.last_login
-					FROM user
-					WHERE user.user_id = ?
-				) as ta
-			LEFT JOIN
-				(
-					SELECT *
-					FROM user_cat, cat, cat_kind
-					WHERE
-						user_cat.cat_id = cat.cat_id and
-						cat.cat_kind_id = cat_kind.cat_kind_id and
-						cat.theme_id = ?
-				) as tb
-				ON
-					tb.user_id = ta.user_id
-			GROUP BY ta.user_id
-			ORDER BY score DESC`, uid, uid, req.ThemeID)
-	}
+	respondFriends(c, status, req.ThemeID)
+}
 
+func respondFriends(c *gin.Context, status int, themeID int) {
+	list, err := queryFriends(session.UID(c), status, themeID)
 	if err != nil {
-		res.Error = fmt.Sprintf("db.Query() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+		errcode.Abort(c, err)
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		friend := Friend{}
-		if status == friendPosition {
-			rows.Scan(&friend.Uid, &friend.Name, &friend.Profile, &friend.LastLogin, &friend.Lat, &friend.Lng, &friend.ThemeScore, &friend.ThemeCats)
-		} else if status == themeRank {
-			rows.Scan(&friend.Uid, &friend.Name, &friend.Profile, &friend.LastLogin, &friend.ThemeScore, &friend.ThemeCats)
-		} else {
-			rows.Scan(&friend.Uid, &friend.Name, &friend.Profile, &friend.LastLogin)
-		}
-		friend.Cats, friend.Score, friend.Level = util.GetScoreAndLevel(db, friend.Uid)
-		res.List = append(res.List, friend)
-	}
 
-	c.IndentedJSON(http.StatusOK, res)
+	c.IndentedJSON(http.StatusOK, friendsResponse{"", list})
+}
+
+type friendsResponse struct {
+	Error string   `json:"error"`
+	List  []Friend `json:"list"`
+}
+
+func queryFriends(uid uint64, status int, themeID int) ([]Friend, error) {
+	switch status {
+	case invitingMeList:
+		return friends.InvitingMe(uid)
+	case friendPosition:
+		return friends.Positions(uid, themeID)
+	case themeRank:
+		return friends.ThemeRank(uid, themeID)
+	default:
+		return friends.List(uid)
+	}
 }
 
 func PostFriendsList(c *gin.Context) {
@@ -305,153 +162,111 @@ func PostFriendRankAtTheme(c *gin.Context) {
 
 func PostFriendDecline(c *gin.Context) {
 	req := struct {
-		Session   string `json:"session"`
 		FriendUID uint64 `json:"friend_uid"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := decline(session.UID(c), req.FriendUID); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	db := util.OpenDB()
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
+func decline(uid uint64, friendUID uint64) error {
 	// if friend_id invites uid, delete the record
-	stmt, err := db.Prepare("DELETE from friend WHERE user_id_src = ? and user_id_dest = ? and accepted = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.FriendUID, uid, false); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-
-	c.IndentedJSON(http.StatusCreated, res)
+	return friends.Decline(uid, friendUID)
 }
 
 func PostFriendAgree(c *gin.Context) {
 	req := struct {
-		Session   string `json:"session"`
 		FriendUID uint64 `json:"friend_uid"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := agree(c, session.UID(c), req.FriendUID); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	db := util.OpenDB()
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
+func agree(c *gin.Context, uid uint64, friendUID uint64) error {
 	// ensure that friend_uid invite uid
-	row := db.QueryRow("SELECT COUNT(*) FROM friend WHERE user_id_src = ? and user_id_dest = ? and accepted = 0", req.FriendUID, uid)
-	var num int
-	if err := row.Scan(&num); err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	} else if num == 0 {
-		res.Error = "無此邀請"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	rel, err := friends.Get(friendUID, uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrInvitationNotFound
+	} else if err != nil {
+		return err
+	} else if rel.Accepted || rel.Ban {
+		return errcode.ErrInvitationNotFound
 	}
 
-	// update
-	stmt, err := db.Prepare("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(uid, req.FriendUID, true, false); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if err := friends.Accept(uid, friendUID); err != nil {
+		return err
 	}
 
-	// insert dest->src
-	stmt, err = db.Prepare("UPDATE friend SET accepted = 1 WHERE user_id_src=? and user_id_dest=?")
+	// each of them tells the other friends about the new friend
+	me, err := users.Get(uid)
 	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return err
 	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.FriendUID, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	friend, err := users.Get(friendUID)
+	if err != nil {
+		return err
 	}
-
-	c.IndentedJSON(http.StatusCreated, res)
+	feed.Publish(c, uid, feed.NewFriend, feed.Detail{"friend_uid": friendUID, "name": friend.Name})
+	feed.Publish(c, friendUID, feed.NewFriend, feed.Detail{"friend_uid": uid, "name": me.Name})
+	inbox.Add(c, friendUID, inbox.FriendAccept, inbox.Detail{"uid": uid, "name": me.Name})
+	notify.Enqueue(c, friendUID, notify.FriendAccept, notify.Message{
+		Title: "好友邀請已接受",
+		Body:  me.Name + " 接受了你的好友邀請",
+		Data:  map[string]string{"uid": strconv.FormatUint(uid, 10)},
+	})
+	return nil
 }
 
 func PostFriendDelete(c *gin.Context) {
 	req := struct {
-		Session   string `json:"session"`
 		FriendUID uint64 `json:"friend_uid"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := remove(c, session.UID(c), req.FriendUID); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	db := util.OpenDB()
-
-	// delete uid -> friend_uid
-	stmt, err := db.Prepare("DELETE from friend WHERE user_id_src = ? and user_id_dest = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(uid, req.FriendUID); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
-	// delete friend_uid -> uid
-	// if ban = 0
-	stmt, err = db.Prepare("DELETE from friend WHERE user_id_src = ? and user_id_dest = ? and ban = 0")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.FriendUID, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+func remove(c *gin.Context, uid uint64, friendUID uint64) error {
+	if err := friends.Delete(uid, friendUID); err != nil {
+		return err
 	}
-
-	c.IndentedJSON(http.StatusCreated, res)
+	audit.Record(c, audit.FriendRemove, uid, audit.Detail{"friend_uid": friendUID})
+	return nil
 }
@@ -0,0 +1,141 @@
+package friends
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/feed"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers and creates the users.
+func setup(t *testing.T, names ...string) []uint64 {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	audit.Init(s)
+	feed.Init(s)
+	inbox.Init(s)
+	notify.Init(s)
+
+	uids := []uint64{}
+	for _, name := range names {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+	return uids
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+func TestInvite(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup runs before alice invites bob
+		setup   func(alice uint64, bob uint64) error
+		invitee func(alice uint64, bob uint64) uint64
+		wantErr error
+	}{
+		{name: "invite", wantErr: nil},
+		{name: "self", invitee: func(alice uint64, bob uint64) uint64 { return alice },
+			wantErr: errcode.ErrInviteSelf},
+		{name: "unknown user", invitee: func(alice uint64, bob uint64) uint64 { return bob + 100 },
+			wantErr: errcode.ErrUserNotFound},
+		{name: "invited already",
+			setup:   func(alice uint64, bob uint64) error { return invite(context(), alice, bob) },
+			wantErr: errcode.ErrAlreadyFriend},
+		{name: "friends already",
+			setup: func(alice uint64, bob uint64) error {
+				if err := invite(context(), alice, bob); err != nil {
+					return err
+				}
+				return agree(context(), bob, alice)
+			},
+			wantErr: errcode.ErrAlreadyFriend},
+		{name: "invited by the invitee",
+			setup:   func(alice uint64, bob uint64) error { return invite(context(), bob, alice) },
+			wantErr: errcode.ErrInviteUnavailable},
+		{name: "banned by the invitee",
+			setup: func(alice uint64, bob uint64) error {
+				return friends.Insert(store.Relation{Src: bob, Dest: alice, Ban: true})
+			},
+			wantErr: errcode.ErrInviteUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uids := setup(t, "alice", "bob")
+			alice, bob := uids[0], uids[1]
+			if tt.setup != nil {
+				if err := tt.setup(alice, bob); err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+			invitee := bob
+			if tt.invitee != nil {
+				invitee = tt.invitee(alice, bob)
+			}
+			if err := invite(context(), alice, invitee); !errors.Is(err, tt.wantErr) {
+				t.Errorf("invite: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAgree(t *testing.T) {
+	uids := setup(t, "alice", "bob", "carol")
+	alice, bob, carol := uids[0], uids[1], uids[2]
+
+	if err := agree(context(), bob, alice); !errors.Is(err, errcode.ErrInvitationNotFound) {
+		t.Errorf("agree without an invitation: err = %v, want ErrInvitationNotFound", err)
+	}
+	if err := invite(context(), alice, bob); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	// only the invitee can agree
+	if err := agree(context(), alice, bob); !errors.Is(err, errcode.ErrInvitationNotFound) {
+		t.Errorf("agree by the inviter: err = %v, want ErrInvitationNotFound", err)
+	}
+	if err := agree(context(), bob, alice); err != nil {
+		t.Fatalf("agree: %v", err)
+	}
+	if ok, err := friends.AreFriends(alice, bob); err != nil || !ok {
+		t.Errorf("AreFriends = (%v, %v), want (true, nil)", ok, err)
+	}
+	if err := agree(context(), bob, alice); !errors.Is(err, errcode.ErrInvitationNotFound) {
+		t.Errorf("agree twice: err = %v, want ErrInvitationNotFound", err)
+	}
+
+	// a declined invitation can not be agreed to, and can be sent again
+	if err := invite(context(), alice, carol); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if err := decline(carol, alice); err != nil {
+		t.Fatalf("decline: %v", err)
+	}
+	if err := agree(context(), carol, alice); !errors.Is(err, errcode.ErrInvitationNotFound) {
+		t.Errorf("agree after declining: err = %v, want ErrInvitationNotFound", err)
+	}
+	if err := invite(context(), alice, carol); err != nil {
+		t.Errorf("invite after a decline: %v", err)
+	}
+}
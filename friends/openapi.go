@@ -0,0 +1,54 @@
+package friends
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+	"github.com/ksw2000/catch_cat_server/util"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/friends", Tag: "friend", Auth: true,
+		Summary: "List my friends",
+		Status:  http.StatusOK, Response: friendsResponse{},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/friends/:uid", Tag: "friend", Auth: true,
+		Summary: "Remove a friend",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/friends/positions", Tag: "friend", Auth: true,
+		Summary: "List my friends with their position and score in a theme",
+		Query:   []openapi.Param{{Name: "theme_id", Type: "integer", Required: true}},
+		Status:  http.StatusOK, Response: friendsResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/themes/:theme_id/rank", Tag: "friend", Auth: true,
+		Summary: "Rank me and my friends by the score in a theme",
+		Status:  http.StatusOK, Response: friendsResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/friends/invitations", Tag: "friend", Auth: true,
+		Summary: "List the users inviting me",
+		Status:  http.StatusOK, Response: friendsResponse{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/friends/invitations", Tag: "friend", Auth: true,
+		Summary: "Invite a user",
+		Request: inviteRequest{},
+		Status:  http.StatusCreated, Response: util.Response{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/friends/invitations/:uid", Tag: "friend", Auth: true,
+		Summary: "Accept the invitation sent by uid",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/friends/invitations/:uid", Tag: "friend", Auth: true,
+		Summary: "Decline the invitation sent by uid",
+		Status:  http.StatusNoContent,
+	},
+}
@@ -0,0 +1,87 @@
+package friends
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlers of the /v1 API, they share the logic with the legacy POST routes
+
+// GET /v1/friends
+func GetFriends(c *gin.Context) {
+	respondFriends(c, friendList, 0)
+}
+
+// GET /v1/friends/positions?theme_id=
+func GetPositions(c *gin.Context) {
+	themeID, _ := strconv.Atoi(c.Query("theme_id"))
+	respondFriends(c, friendPosition, themeID)
+}
+
+// GET /v1/themes/:theme_id/rank
+func GetThemeRank(c *gin.Context) {
+	themeID, ok := util.ParamID(c, "theme_id")
+	if !ok {
+		return
+	}
+	respondFriends(c, themeRank, int(themeID))
+}
+
+// GET /v1/friends/invitations
+func GetInvitations(c *gin.Context) {
+	respondFriends(c, invitingMeList, 0)
+}
+
+// POST /v1/friends/invitations
+func CreateInvitation(c *gin.Context) {
+	PostFriendInvite(c)
+}
+
+// PUT /v1/friends/invitations/:uid
+//
+// Accept the invitation sent by uid.
+func AcceptInvitation(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	if err := agree(c, session.UID(c), friendUID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /v1/friends/invitations/:uid
+//
+// Decline the invitation sent by uid.
+func DeclineInvitation(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	if err := decline(session.UID(c), friendUID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /v1/friends/:uid
+func DeleteFriend(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	if err := remove(c, session.UID(c), friendUID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
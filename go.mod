@@ -1,9 +1,10 @@
 module github.com/ksw2000/catch_cat_server
 
-go 1.20
+go 1.21
 
 require (
 	github.com/gin-gonic/gin v1.9.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
 )
 
@@ -0,0 +1,187 @@
+// Package inbox keeps the in-app notifications of users: friend requests,
+// new themes and achievements. Unlike the push notifications of package
+// notify they stay until deleted and remember whether they were read.
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/realtime"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// kinds of notifications
+const (
+	FriendInvite  = "friend_invite"  // uid, name
+	FriendAccept  = "friend_accept"  // uid, name
+	NewTheme      = "new_theme"      // theme_id, name, starts
+	ThemeComplete = "theme_complete" // theme_id, name
+	LevelUp       = "level_up"       // level
+	TeamInvite    = "team_invite"    // team_id, name, uid
+
+	ChallengeInvite = "challenge_invite" // challenge_id, theme_id, uid, name
+	ChallengeAccept = "challenge_accept" // challenge_id, uid, name
+	ChallengeResult = "challenge_result" // challenge_id, status, place, score
+	QuestComplete   = "quest_complete"   // quest_id, period, kind, bonus, reward_item, reward_count
+	ReferralJoin    = "referral_join"    // uid, name
+	ReferralReward  = "referral_reward"  // uid, name, bonus, reward_item, reward_count
+)
+
+const (
+	defaultInboxLimit = 20
+	maxInboxLimit     = 100
+)
+
+var notifications store.Notifications
+
+// Init sets the repository used by Add, Broadcast and the handlers.
+func Init(s *store.Store) {
+	notifications = s.Notifications
+}
+
+type Notification = store.Notification
+
+// Detail is the extra information of a notification, stored as a JSON
+// object.
+type Detail map[string]any
+
+// Add puts a notification into the inbox of uid and sends it to the connected
+// clients of uid. A failure is logged and does not fail the request.
+func Add(c *gin.Context, uid uint64, kind string, detail Detail) {
+	if err := Put(uid, kind, detail); err != nil {
+		logging.From(c).Error("inbox add failed", "kind", kind, "uid", uid, "err", err)
+	}
+}
+
+// Put is Add for the background workers, which have no request to log with.
+func Put(uid uint64, kind string, detail Detail) error {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		b = []byte("{}")
+	}
+	n := &Notification{Kind: kind, Detail: b, Timing: time.Now().Unix()}
+	if err := notifications.Add(uid, n); err != nil {
+		return err
+	}
+	realtime.Publish(uid, realtime.Event{Type: realtime.Notification, Data: n})
+	return nil
+}
+
+// Broadcast puts a notification into the inbox of everyone, as Add.
+func Broadcast(c *gin.Context, kind string, detail Detail) {
+	b, err := json.Marshal(detail)
+	if err != nil {
+		b = []byte("{}")
+	}
+	if err := notifications.Broadcast(kind, b, time.Now().Unix()); err != nil {
+		logging.From(c).Error("inbox broadcast failed", "kind", kind, "err", err)
+	}
+}
+
+type inboxResponse struct {
+	Error      string         `json:"error"`
+	List       []Notification `json:"list"`
+	Unread     int            `json:"unread"`      // of the whole inbox
+	NextBefore uint64         `json:"next_before"` // 0 if this is the last page
+}
+
+// GET /v1/notifications?unread=&before=&limit=
+//
+// List my notifications, newest first. unread=1 lists the unread ones only.
+func GetNotifications(c *gin.Context) {
+	uid := session.UID(c)
+	before := uint64(0)
+	limit := defaultInboxLimit
+	var err error
+	if v := c.Query("before"); v != "" {
+		if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxInboxLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	unreadOnly := false
+	switch c.Query("unread") {
+	case "", "0":
+	case "1":
+		unreadOnly = true
+	default:
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	list, err := notifications.List(uid, unreadOnly, before, limit)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := inboxResponse{List: list}
+	if res.Unread, err = notifications.Unread(uid); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if len(list) == limit {
+		res.NextBefore = list[len(list)-1].NotificationID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+// PUT /v1/notifications/:notification_id/read
+func ReadNotification(c *gin.Context) {
+	notificationID, ok := util.ParamID(c, "notification_id")
+	if !ok {
+		return
+	}
+	err := notifications.MarkRead(session.UID(c), notificationID)
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrNotificationNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /v1/notifications/read
+//
+// Mark all my notifications as read.
+func ReadAllNotifications(c *gin.Context) {
+	if err := notifications.MarkAllRead(session.UID(c)); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /v1/notifications/:notification_id
+func DeleteNotification(c *gin.Context) {
+	notificationID, ok := util.ParamID(c, "notification_id")
+	if !ok {
+		return
+	}
+	err := notifications.Delete(session.UID(c), notificationID)
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrNotificationNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package inbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers, creates the users and returns
+// a router of the notification routes.
+func setup(t *testing.T, names ...string) (*gin.Engine, []uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+
+	uids := []uint64{}
+	for _, name := range names {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+
+	r := gin.New()
+	auth := r.Group("/v1", session.Auth())
+	auth.GET("/notifications", GetNotifications)
+	auth.PUT("/notifications/read", ReadAllNotifications)
+	auth.PUT("/notifications/:notification_id/read", ReadNotification)
+	auth.DELETE("/notifications/:notification_id", DeleteNotification)
+	return r, uids
+}
+
+// serve sends a request of uid and returns the status and the body.
+func serve(r *gin.Engine, uid uint64, method string, path string) (int, []byte) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+session.NewSession(uid))
+	r.ServeHTTP(w, req)
+	return w.Code, w.Body.Bytes()
+}
+
+func list(t *testing.T, r *gin.Engine, uid uint64, query string) inboxResponse {
+	t.Helper()
+	status, body := serve(r, uid, http.MethodGet, "/v1/notifications"+query)
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want %d", query, status, http.StatusOK)
+	}
+	res := inboxResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return res
+}
+
+func kinds(res inboxResponse) []string {
+	got := []string{}
+	for _, n := range res.List {
+		got = append(got, n.Kind)
+	}
+	return got
+}
+
+func TestGetNotifications(t *testing.T) {
+	r, uids := setup(t, "alice")
+	alice := uids[0]
+	for _, kind := range []string{FriendInvite, LevelUp, NewTheme} {
+		if err := Put(alice, kind, Detail{}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// the pages are followed by next_before until it is 0
+	res := list(t, r, alice, "?limit=2")
+	if got, want := kinds(res), []string{NewTheme, LevelUp}; !reflect.DeepEqual(got, want) || res.Unread != 3 || res.NextBefore == 0 {
+		t.Fatalf("first page = %v unread %d next %d, want %v unread 3", got, res.Unread, res.NextBefore, want)
+	}
+	res = list(t, r, alice, "?limit=2&before="+strconv.FormatUint(res.NextBefore, 10))
+	if got, want := kinds(res), []string{FriendInvite}; !reflect.DeepEqual(got, want) || res.NextBefore != 0 {
+		t.Errorf("second page = %v next %d, want %v next 0", got, res.NextBefore, want)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=101", "?before=x", "?unread=2"} {
+		if status, _ := serve(r, alice, http.MethodGet, "/v1/notifications"+query); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReadAndDelete(t *testing.T) {
+	r, uids := setup(t, "alice", "bob")
+	alice, bob := uids[0], uids[1]
+	for _, kind := range []string{FriendInvite, LevelUp} {
+		if err := Put(alice, kind, Detail{}); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	res := list(t, r, alice, "")
+	invite := strconv.FormatUint(res.List[1].NotificationID, 10)
+	levelUp := strconv.FormatUint(res.List[0].NotificationID, 10)
+
+	// the steps run in order
+	steps := []struct {
+		name       string
+		uid        uint64
+		method     string
+		path       string
+		wantStatus int
+		wantUnread []string // of alice, after the step
+	}{
+		{"read by bob", bob, http.MethodPut, "/v1/notifications/" + invite + "/read",
+			errcode.ErrNotificationNotFound.Status, []string{LevelUp, FriendInvite}},
+		{"read", alice, http.MethodPut, "/v1/notifications/" + invite + "/read",
+			http.StatusNoContent, []string{LevelUp}},
+		{"bad id", alice, http.MethodPut, "/v1/notifications/x/read",
+			http.StatusBadRequest, []string{LevelUp}},
+		{"delete by bob", bob, http.MethodDelete, "/v1/notifications/" + levelUp,
+			errcode.ErrNotificationNotFound.Status, []string{LevelUp}},
+		{"read all", alice, http.MethodPut, "/v1/notifications/read",
+			http.StatusNoContent, []string{}},
+		{"delete", alice, http.MethodDelete, "/v1/notifications/" + levelUp,
+			http.StatusNoContent, []string{}},
+		{"delete twice", alice, http.MethodDelete, "/v1/notifications/" + levelUp,
+			errcode.ErrNotificationNotFound.Status, []string{}},
+	}
+	for _, step := range steps {
+		if status, _ := serve(r, step.uid, step.method, step.path); status != step.wantStatus {
+			t.Errorf("%s: status = %d, want %d", step.name, status, step.wantStatus)
+		}
+		if got := kinds(list(t, r, alice, "?unread=1")); !reflect.DeepEqual(got, step.wantUnread) {
+			t.Errorf("%s: unread = %v, want %v", step.name, got, step.wantUnread)
+		}
+	}
+	if got, want := kinds(list(t, r, alice, "")), []string{FriendInvite}; !reflect.DeepEqual(got, want) {
+		t.Errorf("left = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,36 @@
+package inbox
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/notifications", Tag: "user", Auth: true,
+		Summary: "List my notifications newest first, with the unread count",
+		Query: []openapi.Param{
+			{Name: "unread", Type: "integer"},
+			{Name: "before", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: inboxResponse{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/notifications/read", Tag: "user", Auth: true,
+		Summary: "Mark all my notifications as read",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/notifications/:notification_id/read", Tag: "user", Auth: true,
+		Summary: "Mark a notification as read",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/notifications/:notification_id", Tag: "user", Auth: true,
+		Summary: "Delete a notification",
+		Status:  http.StatusNoContent,
+	},
+}
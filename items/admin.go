@@ -0,0 +1,55 @@
+package items
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxGrant = 100
+
+type grantRequest struct {
+	ItemID   string `json:"item_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// POST /users/:uid/items
+//
+// Grant items to a user from the admin listener, e.g. as a compensation.
+func PostGrant(c *gin.Context) {
+	uid, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	req := grantRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quantity <= 0 || req.Quantity > maxGrant {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	if _, ok := find(req.ItemID); !ok {
+		errcode.Abort(c, errcode.ErrItemNotFound)
+		return
+	}
+	if _, err := users.Get(uid); errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrUserNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	if err := items.Grant(uid, req.ItemID, req.Quantity, store.ItemFromAdmin, 0, time.Now().Unix()); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	Granted(req.ItemID, req.Quantity)
+	audit.Record(c, audit.AdminItemGrant, uid, audit.Detail{"item_id": req.ItemID, "quantity": req.Quantity})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,302 @@
+// Package items keeps the inventories of the users. Items are granted by
+// quests, by completing a theme or by an admin, and used through the API:
+// a bait or a lure raises the points of the cats caught around it for a
+// while, a radar finds the nearest cat not caught yet. Every change of an
+// inventory is written to item_log in the same transaction.
+package items
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Stack  = store.Stack
+	Effect = store.Effect
+)
+
+var (
+	items store.Items
+	users store.Users
+	cats  store.Cats
+	// liveThemes is cats.LiveThemes, which imports this package
+	liveThemes func(now time.Time) ([]store.Theme, error)
+)
+
+// Init sets the repositories used by the handlers, and live which returns
+// the themes whose cats can be caught.
+func Init(s *store.Store, live func(now time.Time) ([]store.Theme, error)) {
+	items = s.Items
+	users = s.Users
+	cats = s.Cats
+	liveThemes = live
+}
+
+// Item is an entry of the catalogue.
+type Item struct {
+	ItemID      string `json:"item_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Minutes     int    `json:"minutes"` // how long the effect lasts, 0 if it has none
+}
+
+var catalogue = []Item{
+	{store.ItemBait, "貓餌", "放在目前的位置 30 分鐘，期間抓到 500 公尺內的貓多得一半的分數", 30},
+	{store.ItemLure, "逗貓棒", "放在一隻還沒抓到的貓上 30 分鐘，期間抓到牠得到兩倍的分數", 30},
+	{store.ItemRadar, "雷達", "找出開放中的主題裡離你最近、還沒抓到的貓", 0},
+}
+
+const (
+	baitRadius = 500 // meters
+	// reach is how far from the last location of a user a bait is put or a
+	// radar looks from, in meters
+	reach = 1000
+	// radarScan is how many of the nearest cats of a theme a radar looks at
+	radarScan = 500
+)
+
+func find(itemID string) (Item, bool) {
+	for _, item := range catalogue {
+		if item.ItemID == itemID {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+type catalogueResponse struct {
+	Error string `json:"error"`
+	List  []Item `json:"list"`
+}
+
+// GET /v1/items
+func GetItems(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, catalogueResponse{"", catalogue})
+}
+
+type inventoryResponse struct {
+	Error   string   `json:"error"`
+	Items   []Stack  `json:"items"`
+	Effects []Effect `json:"effects"` // in use
+}
+
+// GET /v1/users/me/items
+//
+// My items and the effects of those I used that have not ended.
+func GetInventory(c *gin.Context) {
+	uid := session.UID(c)
+	res := inventoryResponse{}
+	var err error
+	if res.Items, err = items.Inventory(uid); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if res.Effects, err = items.Effects(uid, time.Now().Unix()); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+type useRequest struct {
+	Lat   *float64 `json:"lat"` // my last location by default
+	Lng   *float64 `json:"lng"`
+	CatID uint64   `json:"cat_id"` // the cat of a lure
+}
+
+type useResponse struct {
+	Error  string     `json:"error"`
+	Effect *Effect    `json:"effect"` // null for a radar
+	Cat    *store.Cat `json:"cat"`    // found by a radar, null for the others
+}
+
+// POST /v1/users/me/items/:item_id/use
+//
+// Use one of my items.
+func UseItem(c *gin.Context) {
+	req := useRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Lat == nil) != (req.Lng == nil) {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	res, err := use(session.UID(c), c.Param("item_id"), req)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+func use(uid uint64, itemID string, req useRequest) (*useResponse, error) {
+	item, ok := find(itemID)
+	if !ok {
+		return nil, errcode.ErrItemNotFound
+	}
+	now := time.Now()
+	res := &useResponse{}
+	refID := uint64(0)
+
+	switch item.ItemID {
+	case store.ItemBait, store.ItemRadar:
+		lat, lng, err := position(uid, req)
+		if err != nil {
+			return nil, err
+		}
+		if item.ItemID == store.ItemBait {
+			res.Effect = &Effect{Lat: lat, Lng: lng}
+			break
+		}
+		if res.Cat, err = nearest(uid, lat, lng, now); err != nil {
+			return nil, err
+		}
+		refID = res.Cat.CatID
+	case store.ItemLure:
+		cat, err := lured(uid, req.CatID, now)
+		if err != nil {
+			return nil, err
+		}
+		res.Effect = &Effect{CatID: cat.CatID, Lat: cat.Lat, Lng: cat.Lng}
+	}
+	if res.Effect != nil {
+		res.Effect.Starts = now.Unix()
+		res.Effect.Ends = now.Add(time.Duration(item.Minutes) * time.Minute).Unix()
+	}
+
+	err := items.Use(uid, item.ItemID, res.Effect, refID, now.Unix())
+	if errors.Is(err, store.ErrConflict) {
+		return nil, errcode.ErrOutOfItem
+	} else if err != nil {
+		return nil, err
+	}
+	itemTotal.Inc(item.ItemID, "used")
+	return res, nil
+}
+
+// position is the location in the request or else the last location of uid.
+// The location in the request must be within reach of the last location.
+func position(uid uint64, req useRequest) (float64, float64, error) {
+	if req.Lat != nil && (math.Abs(*req.Lat) > 90 || math.Abs(*req.Lng) > 180) {
+		return 0, 0, errcode.ErrBadRequest
+	}
+	u, err := users.Get(uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	if u.LastLat == 0 && u.LastLng == 0 {
+		return 0, 0, errcode.ErrBadRequest
+	}
+	if req.Lat == nil {
+		return u.LastLat, u.LastLng, nil
+	}
+	if distance(u.LastLat, u.LastLng, *req.Lat, *req.Lng) > reach {
+		return 0, 0, errcode.ErrOutOfReach
+	}
+	return *req.Lat, *req.Lng, nil
+}
+
+// lured returns the cat a lure is put on, which must be catchable by uid.
+func lured(uid uint64, catID uint64, now time.Time) (*store.Cat, error) {
+	cat, err := cats.Get(catID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errcode.ErrCatNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	live, err := isLive(cat.ThemeID, now)
+	if err != nil {
+		return nil, err
+	} else if !live {
+		return nil, errcode.ErrThemeInactive
+	}
+	if caught, err := cats.IsCaught(uid, catID); err != nil {
+		return nil, err
+	} else if caught {
+		return nil, errcode.ErrAlreadyCaught
+	}
+	return cat, nil
+}
+
+func isLive(themeID uint64, now time.Time) (bool, error) {
+	themes, err := liveThemes(now)
+	if err != nil {
+		return false, err
+	}
+	for _, theme := range themes {
+		if uint64(theme.ThemeID) == themeID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nearest returns the nearest cat of a live theme that uid has not caught.
+func nearest(uid uint64, lat float64, lng float64, now time.Time) (*store.Cat, error) {
+	themes, err := liveThemes(now)
+	if err != nil {
+		return nil, err
+	}
+	var found *store.Cat
+	for _, theme := range themes {
+		list, err := cats.Nearby(uid, uint64(theme.ThemeID), lat, lng, radarScan)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list {
+			if list[i].IsCaught {
+				continue
+			}
+			if found == nil || distance(lat, lng, list[i].Lat, list[i].Lng) < distance(lat, lng, found.Lat, found.Lng) {
+				found = &list[i]
+			}
+			break
+		}
+	}
+	if found == nil {
+		return nil, errcode.ErrNoCatNearby
+	}
+	return found, nil
+}
+
+// distance is the distance in meters, by the same approximation as
+// store.Cats.Nearby.
+func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	const earthRadius = 6371000
+	k := math.Cos((lat1 + lat2) / 2 * math.Pi / 180)
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180 * k
+	return earthRadius * math.Sqrt(dLat*dLat+dLng*dLng)
+}
+
+// Boost is how the effects of uid raise the points of a catch of cat: the
+// weight of the cat for a lure on it, half of it for a bait nearby. The
+// store adds the points in the transaction of the catch and records them as
+// an item_bonus event of c.
+func Boost(c *gin.Context, uid uint64, cat store.Cat) store.Boost {
+	return store.Boost{
+		Points: func(e Effect) int {
+			switch {
+			case e.ItemID == store.ItemLure && e.CatID == cat.CatID:
+				return cat.Weight
+			case e.ItemID == store.ItemBait && distance(e.Lat, e.Lng, cat.Lat, cat.Lng) <= baitRadius:
+				return cat.Weight / 2
+			}
+			return 0
+		},
+		Audit: audit.Event(c, audit.ItemBonus, uid, nil),
+	}
+}
+
+// Granted counts items granted elsewhere, e.g. by a quest or a theme in the
+// same transaction as its completion.
+func Granted(itemID string, n int) {
+	itemTotal.Add(float64(n), itemID, "granted")
+}
@@ -0,0 +1,163 @@
+package items
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers with a live theme of two cats
+// and alice, last seen at (25, 121).
+func setup(t *testing.T) (*store.Store, uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	theme := store.Theme{ThemeID: 1, Name: "theme"}
+	Init(s, func(now time.Time) ([]store.Theme, error) { return []store.Theme{theme}, nil })
+
+	if err := s.Themes.Save(theme); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	if err := s.Themes.SaveKind(store.CatKind{CatKindID: 1, Name: "kind", Weight: 40}); err != nil {
+		t.Fatalf("SaveKind: %v", err)
+	}
+	for _, cat := range []store.Cat{
+		{CatID: 1, ThemeID: 1, Lat: 25.001, Lng: 121}, // 111 m north
+		{CatID: 2, ThemeID: 1, Lat: 25.01, Lng: 121},  // 1.1 km north
+	} {
+		cat.CatKindID = 1
+		if err := s.Themes.AddCat(cat); err != nil {
+			t.Fatalf("AddCat: %v", err)
+		}
+	}
+	u := &store.User{Name: "alice", Email: "alice@example.com"}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := s.Users.UpdateGPS(u.UID, 25, 121); err != nil {
+		t.Fatalf("UpdateGPS: %v", err)
+	}
+	return s, u.UID
+}
+
+func TestPosition(t *testing.T) {
+	_, alice := setup(t)
+	at := func(lat float64, lng float64) useRequest { return useRequest{Lat: &lat, Lng: &lng} }
+	tests := []struct {
+		name    string
+		req     useRequest
+		wantLat float64
+		wantLng float64
+		wantErr error
+	}{
+		{"last location", useRequest{}, 25, 121, nil},
+		{"nearby", at(25.005, 121), 25.005, 121, nil},
+		{"out of reach", at(25.01, 121), 0, 0, errcode.ErrOutOfReach},
+		{"latitude out of range", at(91, 121), 0, 0, errcode.ErrBadRequest},
+		{"longitude out of range", at(25, -181), 0, 0, errcode.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		lat, lng, err := position(alice, tt.req)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		} else if lat != tt.wantLat || lng != tt.wantLng {
+			t.Errorf("%s: position = (%v, %v), want (%v, %v)", tt.name, lat, lng, tt.wantLat, tt.wantLng)
+		}
+	}
+}
+
+func TestPositionUnknown(t *testing.T) {
+	s, _ := setup(t)
+	bob := &store.User{Name: "bob", Email: "bob@example.com"}
+	if err := s.Users.Create(bob); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// without a last location a position in the request cannot be checked
+	lat, lng := 25.0, 121.0
+	for _, req := range []useRequest{{}, {Lat: &lat, Lng: &lng}} {
+		if _, _, err := position(bob.UID, req); !errors.Is(err, errcode.ErrBadRequest) {
+			t.Errorf("position(%+v): err = %v, want ErrBadRequest", req, err)
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	tests := []struct {
+		name    string
+		itemID  string
+		req     useRequest
+		wantErr error
+		wantCat uint64 // found by a radar
+	}{
+		{name: "bait", itemID: store.ItemBait},
+		{name: "lure", itemID: store.ItemLure, req: useRequest{CatID: 2}},
+		{name: "radar", itemID: store.ItemRadar, wantCat: 1},
+		{name: "lure on nothing", itemID: store.ItemLure, req: useRequest{CatID: 3}, wantErr: errcode.ErrCatNotFound},
+		{name: "unknown item", itemID: "catnip", wantErr: errcode.ErrItemNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, alice := setup(t)
+			if _, ok := find(tt.itemID); ok {
+				if err := s.Items.Grant(alice, tt.itemID, 1, store.ItemFromAdmin, 0, 1); err != nil {
+					t.Fatalf("Grant: %v", err)
+				}
+			}
+			res, err := use(alice, tt.itemID, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("use: err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.wantCat != 0 && (res.Cat == nil || res.Cat.CatID != tt.wantCat) {
+				t.Errorf("radar found %+v, want cat %d", res.Cat, tt.wantCat)
+			}
+			if (res.Effect != nil) == (tt.itemID == store.ItemRadar) {
+				t.Errorf("effect = %+v", res.Effect)
+			}
+
+			// the item is used up
+			if _, err := use(alice, tt.itemID, tt.req); !errors.Is(err, errcode.ErrOutOfItem) {
+				t.Errorf("use again: err = %v, want ErrOutOfItem", err)
+			}
+		})
+	}
+}
+
+func TestBoost(t *testing.T) {
+	cat := store.Cat{CatID: 1, Lat: 25.001, Lng: 121, CatKind: store.CatKind{Weight: 40}}
+	tests := []struct {
+		name string
+		e    Effect
+		want int
+	}{
+		{"lure on the cat", Effect{ItemID: store.ItemLure, CatID: 1}, 40},
+		{"lure on another cat", Effect{ItemID: store.ItemLure, CatID: 2}, 0},
+		{"bait nearby", Effect{ItemID: store.ItemBait, Lat: 25, Lng: 121}, 20},
+		{"bait too far", Effect{ItemID: store.ItemBait, Lat: 25.01, Lng: 121}, 0},
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	boost := Boost(c, 7, cat)
+	for _, tt := range tests {
+		if got := boost.Points(tt.e); got != tt.want {
+			t.Errorf("%s: Points = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if boost.Audit.UID != 7 || boost.Audit.Event != audit.ItemBonus {
+		t.Errorf("Audit = %+v, want an item_bonus of 7", boost.Audit)
+	}
+}
@@ -0,0 +1,6 @@
+package items
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var itemTotal = metrics.NewCounter("catch_cat_items_total",
+	"Items by item_id and action: granted or used.", "item_id", "action")
@@ -0,0 +1,27 @@
+package items
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/items", Tag: "item", Auth: true,
+		Summary: "List the items of the game",
+		Status:  http.StatusOK, Response: catalogueResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/items", Tag: "item", Auth: true,
+		Summary: "Get my items and the effects of those in use",
+		Status:  http.StatusOK, Response: inventoryResponse{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/users/me/items/:item_id/use", Tag: "item", Auth: true,
+		Summary: "Use one of my items",
+		Request: useRequest{},
+		Status:  http.StatusOK, Response: useResponse{},
+	},
+}
@@ -0,0 +1,99 @@
+// Package logging sets up the JSON logger and tags every request with an ID
+// so that its access log line and the errors it caused can be correlated.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	requestIDKey = "logging.request_id"
+	loggerKey    = "logging.logger"
+)
+
+// Setup makes a JSON logger writing to stdout the default one, for slog and
+// for the log package.
+func Setup() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+// RequestID reads X-Request-ID from the request, or generates one if it is
+// missing or malformed, and echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestID)
+		if !validID(id) {
+			id = newID()
+		}
+		c.Set(requestIDKey, id)
+		c.Set(loggerKey, slog.Default().With("request_id", id))
+		c.Header(HeaderRequestID, id)
+		c.Next()
+	}
+}
+
+// Middleware writes an access log line for every request, replacing the
+// logger of gin.Default.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		From(c).Info("request", attrs...)
+	}
+}
+
+// From returns the logger of the request, carrying its request ID.
+func From(c *gin.Context) *slog.Logger {
+	if l, ok := c.Get(loggerKey); ok {
+		return l.(*slog.Logger)
+	}
+	return slog.Default()
+}
+
+// ID returns the request ID set by RequestID.
+func ID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// validID accepts up to 64 letters, digits, '-', '_' and '.' so that a
+// client can not inject anything into the logs.
+func validID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,99 @@
+// Package mail sends the emails of the server, such as the link verifying
+// the email of a user.
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends a plain text email.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Log writes the emails to the log instead of sending them, for development
+// and for servers without a mail server.
+type Log struct{}
+
+func (Log) Send(to string, subject string, body string) error {
+	slog.Info("mail", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// SMTP sends through a mail server, with STARTTLS if the server offers it.
+type SMTP struct {
+	Addr string // host:port
+	From string
+	// Username and Password authenticate with PLAIN, not at all if Username
+	// is empty.
+	Username string
+	Password string
+	Timeout  time.Duration // of the whole session, 15 seconds if 0
+}
+
+func (s *SMTP) Send(to string, subject string, body string) error {
+	// a line break in a header would start another header
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("mail: line break in a header")
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("UTF-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -1,56 +1,215 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
-	"path"
+	"time"
 
+	"github.com/ksw2000/catch_cat_server/admin"
+	"github.com/ksw2000/catch_cat_server/audit"
 	"github.com/ksw2000/catch_cat_server/cats"
+	"github.com/ksw2000/catch_cat_server/challenges"
 	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/feed"
 	"github.com/ksw2000/catch_cat_server/friends"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/items"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/mail"
+	"github.com/ksw2000/catch_cat_server/messages"
+	"github.com/ksw2000/catch_cat_server/metrics"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/openapi"
+	"github.com/ksw2000/catch_cat_server/quests"
+	"github.com/ksw2000/catch_cat_server/ratelimit"
+	"github.com/ksw2000/catch_cat_server/realtime"
+	"github.com/ksw2000/catch_cat_server/referrals"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/teams"
+	"github.com/ksw2000/catch_cat_server/trades"
 	"github.com/ksw2000/catch_cat_server/user"
-	"github.com/ksw2000/catch_cat_server/util"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
+	logging.Setup()
+
 	// open database
-	util.OpenDB()
-	defer util.CloseDB()
+	s, err := store.Open(config.DBDriver, config.DBSource)
+	if err != nil {
+		slog.Error("can not open database", "driver", config.DBDriver, "err", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+	user.Init(s, mailer())
+	friends.Init(s)
+	cats.Init(s)
+	trades.Init(s)
+	feed.Init(s)
+	notify.Init(s)
+	inbox.Init(s)
+	messages.Init(s)
+	teams.Init(s)
+	challenges.Init(s)
+	quests.Init(s, cats.LiveThemes)
+	items.Init(s, cats.LiveThemes)
+	referrals.Init(s)
+	audit.Init(s)
 
 	// prepare gin router
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	r.SetTrustedProxies(config.TrustedProxies)
+	r.Use(gin.Recovery(), logging.RequestID(), logging.Middleware())
+	r.Use(metrics.Middleware())
 	r.Use(CORSMiddleware())
 
-	r.POST("/register", user.PostRegister)
-	r.POST("/login", user.PostLogin)
-	r.POST("/logout", user.PostLogout)
-	r.POST("/friend/invite", friends.PostFriendInvite)
-	r.POST("/friends/inviting_me", friends.PostInvitingMeList)
-	r.POST("/friends/list", friends.PostFriendsList)
-	r.POST("/friends/position", friends.PostFriendsPosition)
-	r.POST("/friends/theme_rank", friends.PostFriendRankAtTheme)
-	r.POST("/friend/agree", friends.PostFriendAgree)
-	r.POST("/friend/decline", friends.PostFriendDecline)
-	r.POST("/friend/delete", friends.PostFriendDelete)
-	r.POST("/theme", cats.PostTheme)
-	r.POST("/user/update/name", user.PostUpdateName)
-	r.POST("/user/update/password", user.PostUpdatePassword)
-	r.POST("/user/update/email", user.PostUpdateEmail)
-	r.POST("/user/update/gps", user.PostUpdateGPS)
-	r.POST("/user/update/share_gps", user.PostUpdateShareGPS)
-	r.POST("/user/update/profile", user.PostUpdateProfile)
-	r.POST("/user/update/last_login", user.PostUpdateLastLogin)
-	r.POST("/user/me", user.PostMe)
-	r.POST("/cat/catching", cats.PostCatching)
-	r.POST("/cat/my_caught_kind", cats.PostCaughtKind)
-	r.POST("/upload/profile", uploadProfile)
-	r.GET("/theme_list", getThemeList)
+	// rate limits, shared by the legacy and the v1 routes
+	loginLimit := ratelimit.ByIP(ratelimit.New(20, time.Minute))
+	registerLimit := ratelimit.ByIP(ratelimit.New(5, time.Hour))
+	passwordIPLimit := ratelimit.ByIP(ratelimit.New(10, time.Minute))
+	passwordUserLimit := ratelimit.ByUser(ratelimit.New(5, time.Hour))
+	inviteIPLimit := ratelimit.ByIP(ratelimit.New(30, time.Minute))
+	inviteUserLimit := ratelimit.ByUser(ratelimit.New(20, time.Hour))
+	exportLimit := ratelimit.ByUser(ratelimit.New(3, time.Hour))
+	messageUserLimit := ratelimit.ByUser(ratelimit.New(60, time.Minute))
+	verifyLimit := ratelimit.ByUser(ratelimit.New(3, time.Hour))
+
+	// legacy routes, kept for old clients
+	r.POST("/register", deprecated("/v1/users"), registerLimit, user.PostRegister)
+	r.POST("/login", deprecated("/v1/sessions"), loginLimit, user.PostLogin)
+	r.POST("/logout", deprecated("/v1/sessions/current"), user.PostLogout)
+	r.GET("/theme_list", deprecated("/v1/themes"), cats.GetThemeList)
+
+	r.GET("/verify/email", user.GetVerifyEmail)
+
+	auth := r.Group("/", session.Auth())
+	auth.POST("/friend/invite", deprecated("/v1/friends/invitations"), inviteIPLimit, inviteUserLimit, friends.PostFriendInvite)
+	auth.POST("/friends/inviting_me", deprecated("/v1/friends/invitations"), friends.PostInvitingMeList)
+	auth.POST("/friends/list", deprecated("/v1/friends"), friends.PostFriendsList)
+	auth.POST("/friends/position", deprecated("/v1/friends/positions"), friends.PostFriendsPosition)
+	auth.POST("/friends/theme_rank", deprecated("/v1/themes/{theme_id}/rank"), friends.PostFriendRankAtTheme)
+	auth.POST("/friend/agree", deprecated("/v1/friends/invitations/{uid}"), friends.PostFriendAgree)
+	auth.POST("/friend/decline", deprecated("/v1/friends/invitations/{uid}"), friends.PostFriendDecline)
+	auth.POST("/friend/delete", deprecated("/v1/friends/{uid}"), friends.PostFriendDelete)
+	auth.POST("/theme", deprecated("/v1/themes/{theme_id}"), cats.PostTheme)
+	auth.POST("/theme/progress", cats.PostThemeProgress)
+	auth.POST("/cat/history", cats.PostCatHistory)
+	auth.POST("/user/update/name", deprecated("/v1/users/me"), user.PostUpdateName)
+	auth.POST("/user/update/password", deprecated("/v1/users/me/password"), passwordIPLimit, passwordUserLimit, user.PostUpdatePassword)
+	auth.POST("/user/update/email", deprecated("/v1/users/me"), user.PostUpdateEmail)
+	auth.POST("/user/update/gps", deprecated("/v1/users/me/location"), user.PostUpdateGPS)
+	auth.POST("/user/update/share_gps", deprecated("/v1/users/me"), user.PostUpdateShareGPS)
+	auth.POST("/user/update/profile", deprecated("/v1/users/me"), user.PostUpdateProfile)
+	auth.POST("/upload/profile", deprecated("/v1/uploads/profile"), user.UploadProfile)
+	auth.POST("/user/update/last_login", deprecated("/v1/users/me/last_login"), user.PostUpdateLastLogin)
+	auth.POST("/user/me", deprecated("/v1/users/me"), user.PostMe)
+	auth.POST("/cat/catching", deprecated("/v1/users/me/cats"), cats.PostCatching)
+	auth.POST("/cat/my_caught_kind", deprecated("/v1/users/me/cat_kinds"), cats.PostCaughtKind)
+	auth.POST("/user/export", exportLimit, user.PostExport)
+	auth.POST("/user/delete", passwordIPLimit, passwordUserLimit, user.PostDelete)
+
+	// RESTful API
+	// the OpenAPI document of /v1, checked against the routes below
+	spec := openapi.New("catch_cat_server", "1.0.0")
+	spec.Add(user.Operations...)
+	spec.Add(friends.Operations...)
+	spec.Add(cats.Operations...)
+	spec.Add(trades.Operations...)
+	spec.Add(feed.Operations...)
+	spec.Add(notify.Operations...)
+	spec.Add(inbox.Operations...)
+	spec.Add(messages.Operations...)
+	spec.Add(teams.Operations...)
+	spec.Add(challenges.Operations...)
+	spec.Add(quests.Operations...)
+	spec.Add(items.Operations...)
+	spec.Add(referrals.Operations...)
+	spec.Add(realtime.Operations...)
+	r.GET("/openapi.json", spec.Handler())
+
+	v1 := r.Group("/v1")
+	if config.ValidateRequests {
+		v1.Use(spec.Validate())
+	}
+	v1.POST("/users", registerLimit, user.CreateUser)
+	v1.POST("/sessions", loginLimit, user.CreateSession)
+	v1.DELETE("/sessions/current", user.DeleteSession)
+	v1.GET("/themes", cats.GetThemeList)
+	v1.GET("/cat_kinds", cats.GetCatKindList)
+
+	v1auth := v1.Group("/", session.Auth())
+	v1auth.GET("/users/me", user.GetMe)
+	v1auth.PATCH("/users/me", user.PatchMe)
+	v1auth.POST("/uploads/profile", user.UploadProfile)
+	v1auth.DELETE("/users/me", passwordIPLimit, passwordUserLimit, user.DeleteMe)
+	v1auth.GET("/users/me/export", exportLimit, user.GetExport)
+	v1auth.PUT("/users/me/password", passwordIPLimit, passwordUserLimit, user.PutPassword)
+	v1auth.PUT("/users/me/location", user.PutLocation)
+	v1auth.PUT("/users/me/last_login", user.PutLastLogin)
+	v1auth.POST("/users/me/verification", verifyLimit, user.CreateVerification)
+	v1auth.GET("/users/me/cat_kinds", cats.GetCaughtKind)
+	v1auth.GET("/users/me/progress", cats.GetThemeProgress)
+	v1auth.POST("/users/me/cats", cats.CreateCatch)
+	v1auth.GET("/users/me/cats", cats.GetHistory)
+	v1auth.GET("/users/me/quests", quests.GetQuests)
+	v1auth.GET("/users/me/referral", referrals.GetReferral)
+	v1auth.GET("/users/me/items", items.GetInventory)
+	v1auth.POST("/users/me/items/:item_id/use", items.UseItem)
+	v1auth.GET("/items", items.GetItems)
+	v1auth.PUT("/users/me/devices/:token", notify.PutDevice)
+	v1auth.DELETE("/users/me/devices/:token", notify.DeleteDevice)
+	v1auth.GET("/friends/:uid/cats", cats.GetFriendHistory)
+	v1auth.POST("/friends/:uid/messages", messageUserLimit, messages.CreateMessage)
+	v1auth.GET("/friends/:uid/messages", messages.GetMessages)
+	v1auth.PUT("/friends/:uid/messages/read", messages.ReadMessages)
+	v1auth.GET("/messages/unread", messages.GetUnread)
+	v1auth.GET("/events", realtime.GetEvents)
+	v1auth.POST("/trades", trades.CreateTrade)
+	v1auth.GET("/trades", trades.GetTrades)
+	v1auth.PUT("/trades/:trade_id", trades.AcceptTrade)
+	v1auth.DELETE("/trades/:trade_id", trades.DeleteTrade)
+	v1auth.POST("/teams", teams.CreateTeam)
+	v1auth.GET("/teams", teams.GetTeams)
+	v1auth.GET("/teams/invitations", teams.GetInvitations)
+	v1auth.GET("/teams/:team_id", teams.GetTeam)
+	v1auth.POST("/teams/:team_id/invitations", inviteIPLimit, inviteUserLimit, teams.CreateInvitation)
+	v1auth.DELETE("/teams/:team_id/invitations/:uid", teams.DeleteInvitation)
+	v1auth.POST("/teams/:team_id/members", teams.CreateMember)
+	v1auth.PUT("/teams/:team_id/members/:uid", teams.PutMember)
+	v1auth.DELETE("/teams/:team_id/members/:uid", teams.DeleteMember)
+	v1auth.GET("/themes/:theme_id/team_rank", teams.GetThemeRank)
+	v1auth.POST("/challenges", inviteIPLimit, inviteUserLimit, challenges.CreateChallenge)
+	v1auth.GET("/challenges", challenges.GetChallenges)
+	v1auth.GET("/challenges/invitations", challenges.GetInvitations)
+	v1auth.PUT("/challenges/invitations/:challenge_id", challenges.AcceptInvitation)
+	v1auth.DELETE("/challenges/invitations/:challenge_id", challenges.DeclineInvitation)
+	v1auth.GET("/challenges/:challenge_id", challenges.GetChallenge)
+	v1auth.GET("/feed", feed.GetFeed)
+	v1auth.GET("/notifications", inbox.GetNotifications)
+	v1auth.PUT("/notifications/read", inbox.ReadAllNotifications)
+	v1auth.PUT("/notifications/:notification_id/read", inbox.ReadNotification)
+	v1auth.DELETE("/notifications/:notification_id", inbox.DeleteNotification)
+	v1auth.GET("/themes/:theme_id", cats.GetTheme)
+	v1auth.GET("/themes/:theme_id/rank", friends.GetThemeRank)
+	v1auth.GET("/friends", friends.GetFriends)
+	v1auth.DELETE("/friends/:uid", friends.DeleteFriend)
+	v1auth.GET("/friends/positions", friends.GetPositions)
+	v1auth.GET("/friends/invitations", friends.GetInvitations)
+	v1auth.POST("/friends/invitations", inviteIPLimit, inviteUserLimit, friends.CreateInvitation)
+	v1auth.PUT("/friends/invitations/:uid", friends.AcceptInvitation)
+	v1auth.DELETE("/friends/invitations/:uid", friends.DeclineInvitation)
+
+	if err := spec.Check(r.Routes(), "/v1/"); err != nil {
+		slog.Error("the OpenAPI document does not match the routes", "err", err)
+		os.Exit(1)
+	}
+
 	r.Static("/images", "./images")
 	r.Static("/icons", "./web/icons")
 	r.Static("/assets", "./web/assets")
@@ -65,17 +224,69 @@ func main() {
 		context.HTML(http.StatusOK, "index.html", nil)
 	})
 
+	// start admin server
+	go serveAdmin()
+
+	// start sending push notifications
+	go notify.Run(context.Background(), notifier())
+
+	// start recording the results of the ended challenges
+	go challenges.Run(context.Background())
+
 	// start server
 	r.Run(":8080")
 }
 
+// serveAdmin serves the admin router on config.AdminAddr, apart from the
+// public listener.
+func serveAdmin() {
+	metrics.NewGaugeFunc("catch_cat_active_sessions", "Sessions in the session bucket.", func() float64 {
+		return float64(session.Count())
+	})
+	metrics.NewGaugeFunc("catch_cat_realtime_connections", "Clients connected to /v1/events.", func() float64 {
+		return float64(realtime.Connections())
+	})
+
+	if err := admin.Router().Run(config.AdminAddr); err != nil {
+		slog.Error("admin server", "err", err)
+	}
+}
+
+// notifier sends through config.PushURL, or only logs if it is not set.
+func notifier() notify.Notifier {
+	if config.PushURL == "" {
+		slog.Warn("CATCH_CAT_PUSH_URL is not set, push notifications are only logged")
+		return notify.Log{}
+	}
+	return &notify.FCM{
+		URL:    config.PushURL,
+		Token:  config.PushToken,
+		Client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// mailer sends through config.SMTPAddr, or only logs if it is not set.
+func mailer() mail.Mailer {
+	if config.SMTPAddr == "" {
+		slog.Warn("CATCH_CAT_SMTP_ADDR is not set, emails are only logged")
+		return mail.Log{}
+	}
+	return &mail.SMTP{
+		Addr:     config.SMTPAddr,
+		From:     config.SMTPFrom,
+		Username: config.SMTPUser,
+		Password: config.SMTPPassword,
+	}
+}
+
 // https://stackoverflow.com/questions/29418478/go-gin-framework-cors
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID, If-None-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After, Deprecation, Link, ETag")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -86,62 +297,11 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-func getThemeList(c *gin.Context) {
-	type Theme struct {
-		ThemeID     int    `json:"theme_id"`
-		Name        string `json:"name"`
-		Thumbnail   string `json:"thumbnail"`
-		Description string `json:"description"`
-	}
-	res := struct {
-		Error string  `json:"error"`
-		List  []Theme `json:"list"`
-	}{
-		List: []Theme{},
-	}
-
-	db := util.OpenDB()
-
-	rows, err := db.Query("SELECT theme_id, name, thumbnail, description FROM theme")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Query() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer rows.Close()
-	for rows.Next() {
-		theme := Theme{}
-		rows.Scan(&theme.ThemeID, &theme.Name, &theme.Thumbnail, &theme.Description)
-		res.List = append(res.List, theme)
-	}
-
-	c.IndentedJSON(http.StatusOK, res)
-}
-
-func uploadProfile(c *gin.Context) {
-	file, _ := c.FormFile("profile")
-	res := struct {
-		Error string `json:"error"`
-		Path  string `json:"path"`
-	}{}
-
-	// generate file name
-	res.Path = path.Join(config.UploadRoot, fmt.Sprintf("%s%s", util.RandomString(15), path.Ext(file.Filename)))
-	for fileExist(res.Path) {
-		res.Path = path.Join(config.UploadRoot, fmt.Sprintf("%s%s", util.RandomString(15), path.Ext(file.Filename)))
-	}
-
-	if err := c.SaveUploadedFile(file, res.Path); err != nil {
-		res.Error = fmt.Sprintf("upload fail %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+// deprecated marks a legacy route. successor is the /v1 route replacing it.
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		c.Next()
 	}
-
-	res.Path = "/" + res.Path
-	c.IndentedJSON(http.StatusCreated, res)
-}
-
-func fileExist(path string) bool {
-	_, err := os.Stat(path)
-	return !errors.Is(err, os.ErrNotExist)
 }
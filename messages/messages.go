@@ -0,0 +1,248 @@
+// Package messages lets friends send direct messages to each other. Only
+// accepted friends who do not ban each other can send or read a
+// conversation; this is checked on every request, so removing or banning a
+// friend cuts off the conversation at once.
+package messages
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/realtime"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Message = store.Message
+
+var (
+	messages store.Messages
+	friends  store.Friends
+	users    store.Users
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	messages = s.Messages
+	friends = s.Friends
+	users = s.Users
+}
+
+const (
+	maxMessageLength     = 1000 // characters
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 200
+)
+
+type sendRequest struct {
+	Body string `json:"body"`
+}
+
+type sendResponse struct {
+	Error   string  `json:"error"`
+	Message Message `json:"message"`
+}
+
+// POST /v1/friends/:uid/messages
+func CreateMessage(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	req := sendRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	m, err := send(c, session.UID(c), friendUID, req.Body)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, sendResponse{"", *m})
+}
+
+func send(c *gin.Context, uid uint64, friendUID uint64, body string) (*Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > maxMessageLength {
+		return nil, errcode.ErrMessageLength
+	}
+	if err := checkFriend(uid, friendUID); err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Src:      uid,
+		Dest:     friendUID,
+		Body:     body,
+		Creating: time.Now().Unix(),
+	}
+	if err := messages.Send(m); err != nil {
+		return nil, err
+	}
+	messageTotal.Inc()
+
+	// push only if the friend is not connected
+	if realtime.Publish(friendUID, realtime.Event{Type: realtime.Message, Data: m}) == 0 {
+		push(c, m)
+	}
+	return m, nil
+}
+
+// push notifies the receiver of m. The message is sent already, so a failure
+// is logged and does not fail the request.
+func push(c *gin.Context, m *Message) {
+	me, err := users.Get(m.Src)
+	if err != nil {
+		logging.From(c).Error("message notice failed", "message_id", m.MessageID, "uid", m.Src, "err", err)
+		return
+	}
+	notify.Enqueue(c, m.Dest, notify.DirectMessage, notify.Message{
+		Title: me.Name,
+		Body:  preview(m.Body),
+		Data: map[string]string{
+			"message_id": strconv.FormatUint(m.MessageID, 10),
+			"uid":        strconv.FormatUint(m.Src, 10),
+		},
+	})
+}
+
+// preview shortens body for a push notification.
+func preview(body string) string {
+	const n = 50
+	if utf8.RuneCountInString(body) <= n {
+		return body
+	}
+	return string([]rune(body)[:n]) + "…"
+}
+
+type conversationResponse struct {
+	Error      string    `json:"error"`
+	List       []Message `json:"list"`
+	NextBefore uint64    `json:"next_before"` // 0 if this is the last page
+}
+
+// GET /v1/friends/:uid/messages?before=&limit=
+//
+// List the messages between me and the friend, newest first.
+func GetMessages(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	before := uint64(0)
+	limit := defaultMessagesLimit
+	var err error
+	if v := c.Query("before"); v != "" {
+		if before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxMessagesLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+
+	uid := session.UID(c)
+	if err := checkFriend(uid, friendUID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	list, err := messages.Conversation(uid, friendUID, before, limit)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := conversationResponse{List: list}
+	if len(list) == limit {
+		res.NextBefore = list[len(list)-1].MessageID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+type readRequest struct {
+	MessageID uint64 `json:"message_id"` // the newest message read
+}
+
+// PUT /v1/friends/:uid/messages/read
+//
+// Mark the messages of the friend up to message_id as read, the friend gets
+// a read receipt.
+func ReadMessages(c *gin.Context) {
+	friendUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	req := readRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MessageID == 0 {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	uid := session.UID(c)
+	if err := checkFriend(uid, friendUID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	n, err := messages.MarkRead(uid, friendUID, req.MessageID, time.Now().Unix())
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if n > 0 {
+		realtime.Publish(friendUID, realtime.Event{Type: realtime.Read, Data: gin.H{
+			"uid":        uid,
+			"message_id": req.MessageID,
+		}})
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type unreadEntry struct {
+	UID    uint64 `json:"uid"`
+	Unread int    `json:"unread"`
+}
+
+type unreadResponse struct {
+	Error string        `json:"error"`
+	List  []unreadEntry `json:"list"` // friends with unread messages
+}
+
+// GET /v1/messages/unread
+func GetUnread(c *gin.Context) {
+	uid := session.UID(c)
+	unread, err := messages.Unread(uid)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := unreadResponse{List: []unreadEntry{}}
+	for friendUID, n := range unread {
+		res.List = append(res.List, unreadEntry{friendUID, n})
+	}
+	sort.Slice(res.List, func(i, j int) bool { return res.List[i].UID < res.List[j].UID })
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+func checkFriend(uid uint64, friendUID uint64) error {
+	if ok, err := friends.AreFriends(uid, friendUID); err != nil {
+		return err
+	} else if !ok {
+		return errcode.ErrNotFriend
+	}
+	return nil
+}
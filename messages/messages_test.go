@@ -0,0 +1,157 @@
+package messages
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers and creates alice, bob and
+// carol; alice is friends with bob.
+func setup(t *testing.T) (*store.Store, []uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	notify.Init(s)
+
+	uids := []uint64{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+	if err := s.Friends.Insert(store.Relation{Src: uids[0], Dest: uids[1]}); err != nil {
+		t.Fatalf("Insert friend: %v", err)
+	}
+	if err := s.Friends.Accept(uids[1], uids[0]); err != nil {
+		t.Fatalf("Accept friend: %v", err)
+	}
+	return s, uids
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+// brokenUsers fails to read any user.
+type brokenUsers struct {
+	store.Users
+}
+
+func (brokenUsers) Get(uid uint64) (*store.User, error) {
+	return nil, errors.New("broken")
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       int // index of the receiver
+		body     string
+		wantBody string
+		wantErr  error
+	}{
+		{name: "send", to: 1, body: " meow\n", wantBody: "meow"},
+		{name: "longest", to: 1, body: strings.Repeat("貓", maxMessageLength), wantBody: strings.Repeat("貓", maxMessageLength)},
+		{name: "empty", to: 1, body: " \n", wantErr: errcode.ErrMessageLength},
+		{name: "too long", to: 1, body: strings.Repeat("貓", maxMessageLength+1), wantErr: errcode.ErrMessageLength},
+		{name: "not friends", to: 2, body: "meow", wantErr: errcode.ErrNotFriend},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, uids := setup(t)
+			m, err := send(context(), uids[0], uids[tt.to], tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("send: err = %v, want %v", err, tt.wantErr)
+			}
+			list, err := s.Messages.Conversation(uids[0], uids[tt.to], 0, 10)
+			if err != nil {
+				t.Fatalf("Conversation: %v", err)
+			}
+			if tt.wantErr != nil {
+				if len(list) != 0 {
+					t.Errorf("%d messages sent, want none", len(list))
+				}
+				return
+			}
+			if len(list) != 1 || list[0] != *m || m.Body != tt.wantBody {
+				t.Errorf("sent %+v, conversation %+v, want the body %q", m, list, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSendPushFailure(t *testing.T) {
+	s, uids := setup(t)
+	users = brokenUsers{s.Users}
+	defer func() { users = s.Users }()
+
+	// the message is sent even if its push notice fails
+	if _, err := send(context(), uids[0], uids[1], "meow"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"meow", "meow"},
+		{strings.Repeat("貓", 50), strings.Repeat("貓", 50)},
+		{strings.Repeat("貓", 51), strings.Repeat("貓", 50) + "…"},
+	}
+	for _, tt := range tests {
+		if got := preview(tt.body); got != tt.want {
+			t.Errorf("preview(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestGetUnread(t *testing.T) {
+	s, uids := setup(t)
+	alice, bob, carol := uids[0], uids[1], uids[2]
+	for _, src := range []uint64{bob, bob, carol} {
+		if err := s.Messages.Send(&store.Message{Src: src, Dest: alice, Body: "meow", Creating: 1}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	r := gin.New()
+	r.GET("/v1/messages/unread", session.Auth(), GetUnread)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/messages/unread", nil)
+	req.Header.Set("Authorization", "Bearer "+session.NewSession(alice))
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	res := unreadResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// carol is not a friend of alice
+	if want := []unreadEntry{{bob, 2}}; !reflect.DeepEqual(res.List, want) {
+		t.Errorf("unread = %+v, want %+v", res.List, want)
+	}
+}
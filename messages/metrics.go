@@ -0,0 +1,6 @@
+package messages
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var messageTotal = metrics.NewCounter("catch_cat_messages_total",
+	"Direct messages sent.")
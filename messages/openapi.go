@@ -0,0 +1,37 @@
+package messages
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPost, Path: "/v1/friends/:uid/messages", Tag: "friend", Auth: true,
+		Summary: "Send a message to a friend",
+		Request: sendRequest{},
+		Status:  http.StatusCreated, Response: sendResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/friends/:uid/messages", Tag: "friend", Auth: true,
+		Summary: "List the messages between me and a friend, newest first",
+		Query: []openapi.Param{
+			{Name: "before", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: conversationResponse{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/friends/:uid/messages/read", Tag: "friend", Auth: true,
+		Summary: "Mark the messages of a friend as read up to message_id",
+		Request: readRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/messages/unread", Tag: "friend", Auth: true,
+		Summary: "Count the unread messages of each friend",
+		Status:  http.StatusOK, Response: unreadResponse{},
+	},
+}
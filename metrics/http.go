@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpDuration = NewHistogram("catch_cat_http_request_duration_seconds",
+		"Latency of HTTP requests by route.", DefBuckets, "method", "route")
+	httpRequests = NewCounter("catch_cat_http_requests_total",
+		"HTTP requests by route and status code.", "method", "route", "status")
+)
+
+// Middleware records the latency and status of every request. Requests
+// matching no route are grouped under route "unmatched" to bound the labels.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpDuration.Since(start, method, route)
+		httpRequests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
@@ -0,0 +1,276 @@
+// Package metrics collects counters, gauges and histograms in process and
+// exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the histogram buckets in seconds, for latencies from 1ms to
+// 10s.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]metric{}
+)
+
+func register(name string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	registry[name] = m
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		mu.Lock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		mu.Unlock()
+		sort.Strings(names)
+
+		for _, name := range names {
+			mu.Lock()
+			m := registry[name]
+			mu.Unlock()
+			m.write(w)
+		}
+	})
+}
+
+// series holds the values of a metric by its label values.
+type series[T any] struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.Mutex
+	values map[string]T
+	keys   map[string][]string
+}
+
+func newSeries[T any](name, help, typ string, labels []string) *series[T] {
+	return &series[T]{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: map[string]T{},
+		keys:   map[string][]string{},
+	}
+}
+
+// get returns the value of the label values, creating it by init.
+func (s *series[T]) get(values []string, init func() T) T {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", s.name, len(s.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		v = init()
+		s.values[key] = v
+		s.keys[key] = append([]string{}, values...)
+	}
+	return v
+}
+
+// each calls f on every label values in a stable order.
+func (s *series[T]) each(f func(labels string, v T)) {
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		v      T
+	}
+	entries := make([]entry, len(keys))
+	for i, key := range keys {
+		entries[i] = entry{formatLabels(s.labels, s.keys[key]), s.values[key]}
+	}
+	s.mu.Unlock()
+
+	for _, e := range entries {
+		f(e.labels, e.v)
+	}
+}
+
+func (s *series[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.typ)
+}
+
+// Counter is a monotonically increasing value partitioned by labels.
+type Counter struct {
+	s *series[*value]
+}
+
+type value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func (v *value) add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+func (v *value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newSeries[*value](name, help, "counter", labels)}
+	if len(labels) == 0 {
+		// expose 0 before the first Inc
+		c.Add(0)
+	}
+	register(name, c)
+	return c
+}
+
+// Inc adds 1 to the counter of the label values.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(d float64, labels ...string) {
+	c.s.get(labels, func() *value { return &value{} }).add(d)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.s.header(w)
+	c.s.each(func(labels string, v *value) {
+		fmt.Fprintf(w, "%s%s %s\n", c.s.name, labels, formatFloat(v.get()))
+	})
+}
+
+// GaugeFunc is a gauge whose value is read by f on every scrape.
+type GaugeFunc struct {
+	name string
+	help string
+	f    func() float64
+}
+
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{name, help, f}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.f()))
+}
+
+// Histogram counts observations in buckets partitioned by labels.
+type Histogram struct {
+	s       *series[*histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // counts[i] is the observations <= buckets[i]
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{newSeries[*histogram](name, help, "histogram", labels), buckets}
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	x := h.s.get(labels, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets))}
+	})
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, le := range h.buckets {
+		if v <= le {
+			x.counts[i]++
+		}
+	}
+	x.count++
+	x.sum += v
+}
+
+// Since observes the seconds elapsed since t.
+func (h *Histogram) Since(t time.Time, labels ...string) {
+	h.Observe(time.Since(t).Seconds(), labels...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.s.header(w)
+	h.s.each(func(labels string, x *histogram) {
+		x.mu.Lock()
+		counts := append([]uint64{}, x.counts...)
+		count, sum := x.count, x.sum
+		x.mu.Unlock()
+
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.s.name, withLabel(labels, "le", formatFloat(le)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.s.name, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.s.name, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.s.name, labels, count)
+	})
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = names[i] + "=" + strconv.Quote(values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel appends name="value" to formatted labels.
+func withLabel(labels string, name string, value string) string {
+	pair := name + "=" + strconv.Quote(value)
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// FCM sends through the HTTP v1 API of Firebase Cloud Messaging, or any
+// service speaking the same protocol such as a local stub.
+type FCM struct {
+	// URL is the messages:send endpoint, e.g.
+	// https://fcm.googleapis.com/v1/projects/<project>/messages:send
+	URL string
+	// Token is the bearer token of the requests, none if empty.
+	Token  string
+	Client *http.Client
+}
+
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func (f *FCM) Send(ctx context.Context, token string, m Message) error {
+	b, err := json.Marshal(fcmRequest{fcmMessage{
+		Token:        token,
+		Notification: fcmNotification{m.Title, m.Body},
+		Data:         m.Data,
+	}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	// FCM answers 404 UNREGISTERED for a token of an uninstalled app, and
+	// 400 INVALID_ARGUMENT mentioning the token for a malformed one
+	if res.StatusCode == http.StatusNotFound || bytes.Contains(body, []byte("UNREGISTERED")) ||
+		(res.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "registration token")) {
+		return ErrUnregistered
+	}
+	// any other 4xx but the ones about the credentials or the rate fails
+	// the same way when retried
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+	default:
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			return fmt.Errorf("%w: FCM responded %s: %s", ErrRejected, res.Status, bytes.TrimSpace(body))
+		}
+	}
+	return fmt.Errorf("notify: FCM responded %s: %s", res.Status, bytes.TrimSpace(body))
+}
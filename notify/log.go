@@ -0,0 +1,19 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log writes the messages to the log instead of sending them, for
+// development and for servers without a push service.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, token string, m Message) error {
+	// a push token is a credential of the device, only a prefix is logged
+	if len(token) > 8 {
+		token = token[:8] + "..."
+	}
+	slog.Info("push", "token", token, "title", m.Title, "body", m.Body, "data", m.Data)
+	return nil
+}
@@ -0,0 +1,6 @@
+package notify
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var pushTotal = metrics.NewCounter("catch_cat_push_total",
+	"Push notification attempts by result: sent, retried, failed or unregistered.", "result")
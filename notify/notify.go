@@ -0,0 +1,154 @@
+// Package notify delivers push notifications. Enqueue writes a message for
+// every registered device of a user into push_outbox within the request, and
+// Run sends them in the background through a Notifier, retrying with backoff,
+// so that a notification survives a restart or an outage of the push service.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// kinds of notifications, sent as "kind" in the data
+const (
+	FriendInvite  = "friend_invite"  // uid
+	FriendAccept  = "friend_accept"  // uid
+	TradeProposed = "trade_proposed" // trade_id, uid
+	TradeAccepted = "trade_accepted" // trade_id, uid
+	DirectMessage = "direct_message" // message_id, uid
+	TeamInvite    = "team_invite"    // team_id, uid
+
+	ChallengeInvite = "challenge_invite" // challenge_id, uid
+	ChallengeAccept = "challenge_accept" // challenge_id, uid
+)
+
+// Message is a push notification. Data is delivered to the app as is, the
+// push services accept string values only.
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// Notifier delivers a message to a device.
+type Notifier interface {
+	// Send returns ErrUnregistered if the token is no longer valid and
+	// ErrRejected if the message can never be sent, any other error is
+	// retried.
+	Send(ctx context.Context, token string, m Message) error
+}
+
+// ErrUnregistered tells that the app was uninstalled or the token expired.
+var ErrUnregistered = errors.New("notify: unregistered token")
+
+// ErrRejected tells that the push service refused the message itself.
+var ErrRejected = errors.New("notify: message rejected")
+
+var (
+	devices store.Devices
+	outbox  store.Outbox
+)
+
+// Init sets the repositories used by Enqueue, Run and the handlers.
+func Init(s *store.Store) {
+	devices = s.Devices
+	outbox = s.Outbox
+}
+
+// wake tells Run that a message was enqueued.
+var wake = make(chan struct{}, 1)
+
+// Enqueue queues m of kind for every device of uid. A failure is logged and
+// does not fail the request.
+func Enqueue(c *gin.Context, uid uint64, kind string, m Message) {
+	data := map[string]string{"kind": kind}
+	for k, v := range m.Data {
+		data[k] = v
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		b = []byte("{}")
+	}
+	now := time.Now().Unix()
+	n, err := outbox.Enqueue(&store.PushMessage{
+		UID:         uid,
+		Kind:        kind,
+		Title:       m.Title,
+		Body:        m.Body,
+		Data:        b,
+		NextAttempt: now,
+		Creating:    now,
+	})
+	if err != nil {
+		logging.From(c).Error("push enqueue failed", "kind", kind, "uid", uid, "err", err)
+		return
+	}
+	if n > 0 {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// platforms of the devices
+var platforms = map[string]bool{"android": true, "ios": true, "web": true}
+
+type deviceRequest struct {
+	Platform string `json:"platform"` // android, ios or web
+}
+
+// PUT /v1/users/me/devices/:token
+//
+// Register the push token of this app installation. Registering it again
+// refreshes it; a token registered by another account is moved to me.
+func PutDevice(c *gin.Context) {
+	req := deviceRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	token := c.Param("token")
+	if token == "" || len(token) > 4096 || !platforms[req.Platform] {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	if err := devices.Register(&store.Device{
+		Token:    token,
+		UID:      session.UID(c),
+		Platform: req.Platform,
+		Creating: now,
+		Updating: now,
+	}); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /v1/users/me/devices/:token
+//
+// Stop the notifications to this app installation, e.g. on logout.
+func DeleteDevice(c *gin.Context) {
+	err := devices.Unregister(session.UID(c), c.Param("token"))
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrDeviceNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
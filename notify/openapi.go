@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPut, Path: "/v1/users/me/devices/:token", Tag: "user", Auth: true,
+		Summary: "Register the push token of this app installation",
+		Request: deviceRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/users/me/devices/:token", Tag: "user", Auth: true,
+		Summary: "Stop the push notifications to this app installation",
+		Status:  http.StatusNoContent,
+	},
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/store"
+)
+
+const (
+	batchSize    = 50
+	pollInterval = 5 * time.Second
+	sendTimeout  = 10 * time.Second
+	// a claimed message is tried again after lease if the server stops
+	// before the attempt is recorded
+	lease         = time.Minute
+	maxAttempts   = 8
+	firstBackoff  = 30 * time.Second
+	maxBackoff    = time.Hour
+	keepFinished  = 7 * 24 * time.Hour
+	purgeInterval = time.Hour
+)
+
+// backoff returns the delay after the attempts-th failure: 30s, 1m, 2m, 4m,
+// ... up to an hour.
+func backoff(attempts int) time.Duration {
+	d := firstBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Run sends the due messages through n until ctx is done.
+func Run(ctx context.Context, n Notifier) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+
+	for {
+		now := time.Now()
+		if now.Sub(lastPurge) >= purgeInterval {
+			if err := outbox.Purge(now.Add(-keepFinished).Unix()); err != nil {
+				slog.Error("push purge failed", "err", err)
+			}
+			lastPurge = now
+		}
+
+		list, err := outbox.Claim(now.Unix(), now.Add(lease).Unix(), batchSize)
+		if err != nil {
+			slog.Error("push claim failed", "err", err)
+		}
+		for _, m := range list {
+			deliver(ctx, n, m)
+		}
+		// a full batch means more may be due
+		if len(list) == batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends m and records the result.
+func deliver(ctx context.Context, n Notifier, m store.PushMessage) {
+	msg := Message{Title: m.Title, Body: m.Body, Data: map[string]string{}}
+	if err := json.Unmarshal(m.Data, &msg.Data); err != nil {
+		slog.Error("push data is not an object of strings", "outbox_id", m.OutboxID, "err", err)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	err := n.Send(sendCtx, m.Token, msg)
+	cancel()
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		err = outbox.Finish(m.OutboxID, store.PushSent, "", now.Unix())
+		pushTotal.Inc("sent")
+	case errors.Is(err, ErrUnregistered):
+		if err := outbox.Finish(m.OutboxID, store.PushFailed, err.Error(), now.Unix()); err != nil {
+			slog.Error("push finish failed", "outbox_id", m.OutboxID, "err", err)
+		}
+		err = devices.Remove(m.Token)
+		pushTotal.Inc("unregistered")
+	case errors.Is(err, ErrRejected) || m.Attempts >= maxAttempts:
+		slog.Warn("push given up", "outbox_id", m.OutboxID, "uid", m.UID, "kind", m.Kind, "err", err)
+		err = outbox.Finish(m.OutboxID, store.PushFailed, err.Error(), now.Unix())
+		pushTotal.Inc("failed")
+	default:
+		next := now.Add(backoff(m.Attempts))
+		err = outbox.Retry(m.OutboxID, next.Unix(), err.Error(), now.Unix())
+		pushTotal.Inc("retried")
+	}
+	if err != nil {
+		slog.Error("push result not recorded", "outbox_id", m.OutboxID, "err", err)
+	}
+}
@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/store"
+)
+
+// recorder keeps the results deliver records in the outbox.
+type recorder struct {
+	store.Outbox
+	status    string
+	lastError string
+	delay     int64 // of the next attempt after a retry
+}
+
+func (r *recorder) Retry(outboxID uint64, next int64, lastError string, t int64) error {
+	r.status, r.lastError, r.delay = store.PushPending, lastError, next-t
+	return r.Outbox.Retry(outboxID, next, lastError, t)
+}
+
+func (r *recorder) Finish(outboxID uint64, status string, lastError string, t int64) error {
+	r.status, r.lastError = status, lastError
+	return r.Outbox.Finish(outboxID, status, lastError, t)
+}
+
+// fcmStub answers every message:send with status and body and keeps the
+// last request.
+func fcmStub(t *testing.T, status int, body string) (*FCM, *fcmRequest) {
+	t.Helper()
+	got := &fcmRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the bearer token", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &FCM{URL: srv.URL, Token: "secret", Client: srv.Client()}, got
+}
+
+// setup registers a device of a user, queues a message for it and returns
+// the claimed message.
+func setup(t *testing.T) (*recorder, store.PushMessage) {
+	t.Helper()
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	rec := &recorder{Outbox: s.Outbox}
+	outbox = rec
+
+	u := &store.User{Name: "alice", Email: "alice@example.com"}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := devices.Register(&store.Device{Token: "token-1", UID: u.UID, Platform: "android"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	now := time.Now().Unix()
+	if _, err := outbox.Enqueue(&store.PushMessage{
+		UID: u.UID, Kind: FriendInvite, Title: "title", Body: "body",
+		Data: []byte(`{"kind":"friend_invite","uid":"2"}`), NextAttempt: now, Creating: now,
+	}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	list, err := outbox.Claim(now, now, batchSize)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("Claim = (%d messages, %v), want one", len(list), err)
+	}
+	return rec, list[0]
+}
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		attempts   int // of the claimed message, 1 if 0
+		wantStatus string
+		wantDelay  time.Duration
+		wantDevice bool
+	}{
+		{name: "sent", status: http.StatusOK, body: `{"name":"m1"}`,
+			wantStatus: store.PushSent, wantDevice: true},
+		{name: "server error", status: http.StatusServiceUnavailable, body: "unavailable",
+			wantStatus: store.PushPending, wantDelay: 30 * time.Second, wantDevice: true},
+		{name: "server error again", status: http.StatusInternalServerError, attempts: 3,
+			wantStatus: store.PushPending, wantDelay: 2 * time.Minute, wantDevice: true},
+		{name: "rate limited", status: http.StatusTooManyRequests,
+			wantStatus: store.PushPending, wantDelay: 30 * time.Second, wantDevice: true},
+		{name: "last attempt", status: http.StatusServiceUnavailable, attempts: maxAttempts,
+			wantStatus: store.PushFailed, wantDevice: true},
+		{name: "unregistered", status: http.StatusNotFound, body: `{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`,
+			wantStatus: store.PushFailed},
+		{name: "invalid token", status: http.StatusBadRequest, body: `{"error":{"message":"The registration token is not a valid FCM registration token"}}`,
+			wantStatus: store.PushFailed},
+		{name: "invalid message", status: http.StatusBadRequest, body: `{"error":{"status":"INVALID_ARGUMENT","message":"Invalid data payload"}}`,
+			wantStatus: store.PushFailed, wantDevice: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, m := setup(t)
+			fcm, got := fcmStub(t, tt.status, tt.body)
+			if tt.attempts != 0 {
+				m.Attempts = tt.attempts
+			}
+
+			deliver(context.Background(), fcm, m)
+
+			want := fcmMessage{"token-1", fcmNotification{"title", "body"},
+				map[string]string{"kind": FriendInvite, "uid": "2"}}
+			if !reflect.DeepEqual(got.Message, want) {
+				t.Errorf("request = %+v, want %+v", got.Message, want)
+			}
+			if rec.status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", rec.status, tt.wantStatus)
+			}
+			if (rec.lastError == "") != (tt.wantStatus == store.PushSent) {
+				t.Errorf("last error = %q after %s", rec.lastError, tt.wantStatus)
+			}
+			if got := time.Duration(rec.delay) * time.Second; got != tt.wantDelay {
+				t.Errorf("retried after %v, want %v", got, tt.wantDelay)
+			}
+
+			// a retried message is claimed again once due and not before
+			now := time.Now().Unix()
+			if list, err := outbox.Claim(now, now, batchSize); err != nil || len(list) != 0 {
+				t.Errorf("Claim now = (%d messages, %v), want none", len(list), err)
+			}
+			later := now + int64(time.Hour/time.Second)
+			list, err := outbox.Claim(later, later, batchSize)
+			if err != nil {
+				t.Fatalf("Claim: %v", err)
+			}
+			if wantAgain := tt.wantStatus == store.PushPending; (len(list) == 1) != wantAgain {
+				t.Errorf("claimed %d messages later, want again = %v", len(list), wantAgain)
+			}
+
+			if devs, err := devices.List(m.UID); err != nil {
+				t.Fatalf("List: %v", err)
+			} else if (len(devs) == 1) != tt.wantDevice {
+				t.Errorf("%d devices left, want device = %v", len(devs), tt.wantDevice)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
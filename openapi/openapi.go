@@ -0,0 +1,203 @@
+// Package openapi builds the OpenAPI 3 document of the /v1 API from the
+// request and response types of the handlers, and validates request bodies
+// against it.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operation documents a route. Path is in gin syntax, e.g. "/v1/friends/:uid";
+// its parameters are documented as integers.
+type Operation struct {
+	Method  string
+	Path    string
+	Summary string
+	Tag     string
+	Auth    bool    // needs a session
+	Query   []Param // query parameters
+	Request any     // JSON body, a value of the request type
+	File    string  // multipart field of an uploaded file, instead of Request
+
+	Status      int // status on success
+	Response    any // JSON body on success, nil if there is none
+	ContentType string
+
+	request *Schema
+}
+
+// Param is a query parameter.
+type Param struct {
+	Name     string
+	Type     string // "integer", "number", "string" or "boolean"
+	Required bool
+}
+
+type Spec struct {
+	title   string
+	version string
+	ops     map[string]*Operation
+}
+
+func New(title string, version string) *Spec {
+	return &Spec{title, version, map[string]*Operation{}}
+}
+
+func key(method string, path string) string {
+	return method + " " + path
+}
+
+// Add documents the operations, it panics if one is documented twice.
+func (s *Spec) Add(ops ...Operation) {
+	for i := range ops {
+		op := ops[i]
+		k := key(op.Method, op.Path)
+		if _, ok := s.ops[k]; ok {
+			panic("openapi: duplicate operation " + k)
+		}
+		if op.Request != nil {
+			op.request = SchemaOf(op.Request)
+		}
+		s.ops[k] = &op
+	}
+}
+
+// Check compares the documented operations with the routes of r under
+// prefix, so that the document can not drift from the router.
+func (s *Spec) Check(routes gin.RoutesInfo, prefix string) error {
+	problems := []string{}
+	routed := map[string]bool{}
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, prefix) {
+			continue
+		}
+		k := key(r.Method, r.Path)
+		routed[k] = true
+		if _, ok := s.ops[k]; !ok {
+			problems = append(problems, "undocumented route "+k)
+		}
+	}
+	for k := range s.ops {
+		if !routed[k] {
+			problems = append(problems, "documented operation without route "+k)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+var pathParam = regexp.MustCompile(`:([A-Za-z_]+)`)
+
+// Document returns the OpenAPI 3 document.
+func (s *Spec) Document() map[string]any {
+	paths := map[string]map[string]any{}
+	for _, op := range s.ops {
+		path := pathParam.ReplaceAllString(op.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.Method)] = op.document()
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   s.title,
+			"version": s.version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Error": SchemaOf(errcode.Body{}),
+			},
+			"securitySchemes": map[string]any{
+				"session": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "the session returned by POST /v1/sessions",
+				},
+			},
+		},
+	}
+}
+
+func (op *Operation) document() map[string]any {
+	params := []any{}
+	for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]any{"type": "integer", "format": "int64"},
+		})
+	}
+	for _, p := range op.Query {
+		params = append(params, map[string]any{
+			"name": p.Name, "in": "query", "required": p.Required,
+			"schema": map[string]any{"type": p.Type},
+		})
+	}
+
+	errorRes := map[string]any{
+		"description": "error, see the error codes in README",
+		"content": map[string]any{
+			"application/json": map[string]any{
+				"schema": map[string]any{"$ref": "#/components/schemas/Error"},
+			},
+		},
+	}
+	success := map[string]any{"description": http.StatusText(op.Status)}
+	if op.Response != nil {
+		success["content"] = map[string]any{
+			"application/json": map[string]any{"schema": SchemaOf(op.Response)},
+		}
+	} else if op.ContentType != "" {
+		success["content"] = map[string]any{
+			op.ContentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+		}
+	}
+
+	doc := map[string]any{
+		"summary":    op.Summary,
+		"tags":       []string{op.Tag},
+		"parameters": params,
+		"responses": map[string]any{
+			fmt.Sprint(op.Status): success,
+			"default":             errorRes,
+		},
+	}
+	if op.Auth {
+		doc["security"] = []any{map[string]any{"session": []string{}}}
+	}
+	if op.Request != nil {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": op.request},
+			},
+		}
+	} else if op.File != "" {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"multipart/form-data": map[string]any{
+					"schema": &Schema{
+						Type:       "object",
+						Properties: map[string]*Schema{op.File: {Type: "string", Format: "binary"}},
+						Required:   []string{op.File},
+					},
+				},
+			},
+		}
+	}
+	return doc
+}
@@ -0,0 +1,73 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheck(t *testing.T) {
+	s := New("test", "1")
+	s.Add(
+		Operation{Method: http.MethodGet, Path: "/v1/cats/:cat_id", Status: http.StatusOK},
+		Operation{Method: http.MethodPost, Path: "/v1/trades", Status: http.StatusCreated},
+	)
+
+	routes := gin.RoutesInfo{
+		{Method: http.MethodGet, Path: "/v1/cats/:cat_id"},
+		{Method: http.MethodPost, Path: "/v1/trades"},
+		{Method: http.MethodPost, Path: "/login"}, // outside the prefix
+	}
+	if err := s.Check(routes, "/v1/"); err != nil {
+		t.Errorf("Check = %v, want nil", err)
+	}
+
+	routes = append(routes[1:], gin.RouteInfo{Method: http.MethodDelete, Path: "/v1/trades/:trade_id"})
+	err := s.Check(routes, "/v1/")
+	if err == nil {
+		t.Fatal("Check = nil, want the drift")
+	}
+	for _, want := range []string{
+		"undocumented route DELETE /v1/trades/:trade_id",
+		"documented operation without route GET /v1/cats/:cat_id",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Check = %v, want it to mention %q", err, want)
+		}
+	}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Add of a duplicate operation did not panic")
+		}
+	}()
+	op := Operation{Method: http.MethodGet, Path: "/v1/themes", Status: http.StatusOK}
+	New("test", "1").Add(op, op)
+}
+
+func TestDocument(t *testing.T) {
+	s := New("test", "1")
+	s.Add(Operation{
+		Method: http.MethodPut, Path: "/v1/teams/:team_id/members/:uid", Auth: true,
+		Query:  []Param{{Name: "theme_id", Type: "integer"}},
+		Status: http.StatusNoContent,
+	})
+	paths := s.Document()["paths"].(map[string]map[string]any)
+	op, ok := paths["/v1/teams/{team_id}/members/{uid}"]["put"].(map[string]any)
+	if !ok {
+		t.Fatalf("paths = %v, want the path in OpenAPI syntax", paths)
+	}
+	if params := op["parameters"].([]any); len(params) != 3 {
+		t.Errorf("%d parameters, want 2 in the path and 1 in the query", len(params))
+	}
+	if _, ok := op["security"]; !ok {
+		t.Error("no security on an operation needing a session")
+	}
+	if _, ok := op["responses"].(map[string]any)["204"]; !ok {
+		t.Error("no response of the success status")
+	}
+}
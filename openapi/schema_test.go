@@ -0,0 +1,56 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type embedded struct {
+	Base string `json:"base"`
+}
+
+type sample struct {
+	embedded
+	ID       uint64          `json:"id"`
+	Name     string          `json:"name"`
+	Note     string          `json:"note,omitempty"`
+	Parent   *uint64         `json:"parent"`
+	Tags     []string        `json:"tags"`
+	Raw      json.RawMessage `json:"raw"`
+	Skipped  string          `json:"-"`
+	internal string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(sample{})
+	if s.Type != "object" {
+		t.Fatalf("type = %q, want object", s.Type)
+	}
+	names := []string{"base", "id", "name", "note", "parent", "tags", "raw"}
+	if !reflect.DeepEqual(s.Required, []string{"base", "id", "name", "tags", "raw"}) {
+		t.Errorf("required = %v, want all but the pointer and omitempty fields", s.Required)
+	}
+	for _, name := range names {
+		if s.Properties[name] == nil {
+			t.Errorf("no property %q", name)
+		}
+	}
+	for _, name := range []string{"Skipped", "-", "internal", "embedded"} {
+		if s.Properties[name] != nil {
+			t.Errorf("property %q, want it skipped", name)
+		}
+	}
+	if id := s.Properties["id"]; id.Type != "integer" || id.Minimum == nil || *id.Minimum != 0 {
+		t.Errorf("id = %+v, want a non negative integer", id)
+	}
+	if p := s.Properties["parent"]; !p.Nullable {
+		t.Error("pointer field not nullable")
+	}
+	if tags := s.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("tags = %+v, want an array of strings", tags)
+	}
+	if raw := s.Properties["raw"]; raw.Type != "" {
+		t.Errorf("raw = %+v, want any value", raw)
+	}
+}
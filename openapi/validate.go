@@ -0,0 +1,139 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Validate checks the JSON body of the requests whose operation has a Request
+// type and aborts with errcode.ErrBadRequest listing the invalid fields. The
+// body is restored for the handler.
+func (s *Spec) Validate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, ok := s.ops[key(c.Request.Method, c.FullPath())]
+		if !ok || op.Request == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		if fields := validateBody(op.request, body); len(fields) > 0 {
+			errcode.Abort(c, errcode.ErrBadRequest.Fields(fields))
+			return
+		}
+		c.Next()
+	}
+}
+
+func validateBody(s *Schema, body []byte) []errcode.FieldError {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return []errcode.FieldError{{Field: "", Reason: "invalid_json"}}
+	}
+	fields := []errcode.FieldError{}
+	validate(s, v, "", &fields)
+	return fields
+}
+
+func validate(s *Schema, v any, path string, fields *[]errcode.FieldError) {
+	fail := func(reason string) {
+		*fields = append(*fields, errcode.FieldError{Field: path, Reason: reason})
+	}
+	if v == nil {
+		if !s.Nullable && s.Type != "" {
+			fail("expected_" + s.Type)
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			fail("expected_object")
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := m[name]; !ok {
+				*fields = append(*fields, errcode.FieldError{Field: join(path, name), Reason: "required"})
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if x, ok := m[name]; ok {
+				validate(s.Properties[name], x, join(path, name), fields)
+			}
+		}
+	case "array":
+		a, ok := v.([]any)
+		if !ok {
+			fail("expected_array")
+			return
+		}
+		for i, x := range a {
+			validate(s.Items, x, fmt.Sprintf("%s[%d]", path, i), fields)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			fail("expected_string")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected_boolean")
+		}
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("expected_" + s.Type)
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			fail("expected_" + s.Type)
+			return
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				fail("expected_integer")
+				return
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("minimum")
+		}
+	}
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Handler serves the document as JSON.
+func (s *Spec) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.Document())
+	}
+}
@@ -0,0 +1,86 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+type item struct {
+	UID uint64 `json:"uid"`
+}
+
+type request struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+	Agree bool    `json:"agree"`
+	List  []item  `json:"list"`
+	Note  *string `json:"note"`
+}
+
+func field(path string, reason string) errcode.FieldError {
+	return errcode.FieldError{Field: path, Reason: reason}
+}
+
+func TestValidateBody(t *testing.T) {
+	s := SchemaOf(request{})
+	tests := []struct {
+		name string
+		body string
+		want []errcode.FieldError
+	}{
+		{"valid", `{"name":"a","score":1.5,"agree":true,"list":[{"uid":1}],"note":null}`, []errcode.FieldError{}},
+		{"unknown fields are ignored", `{"name":"a","score":1,"agree":false,"list":[],"extra":1}`, []errcode.FieldError{}},
+		{"invalid json", `{"name":`, []errcode.FieldError{field("", "invalid_json")}},
+		{"not an object", `[]`, []errcode.FieldError{field("", "expected_object")}},
+		{"required", `{"name":"a"}`, []errcode.FieldError{
+			field("score", "required"), field("agree", "required"), field("list", "required")}},
+		{"types", `{"name":1,"score":"1","agree":"yes","list":{}}`, []errcode.FieldError{
+			field("agree", "expected_boolean"), field("list", "expected_array"), field("name", "expected_string"), field("score", "expected_number")}},
+		{"nested", `{"name":"a","score":1,"agree":true,"list":[{"uid":-1},{"uid":1.5},{}]}`, []errcode.FieldError{
+			field("list[0].uid", "minimum"), field("list[1].uid", "expected_integer"), field("list[2].uid", "required")}},
+		{"null", `{"name":null,"score":1,"agree":true,"list":[]}`, []errcode.FieldError{field("name", "expected_string")}},
+	}
+	for _, tt := range tests {
+		if got := validateBody(s, []byte(tt.body)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := New("test", "1")
+	s.Add(Operation{Method: http.MethodPost, Path: "/v1/things/:id", Request: item{}, Status: http.StatusCreated})
+
+	r := gin.New()
+	r.Use(s.Validate())
+	r.POST("/v1/things/:id", func(c *gin.Context) {
+		req := item{}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			t.Errorf("body not restored: %v", err)
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"uid":1}`, http.StatusCreated},
+		{`{"uid":"1"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/things/1", strings.NewReader(tt.body)))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.body, w.Code, tt.wantStatus)
+		}
+	}
+}
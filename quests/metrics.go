@@ -0,0 +1,10 @@
+package quests
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var (
+	completedTotal = metrics.NewCounter("catch_cat_quests_completed_total",
+		"Quests completed by period: daily or weekly.", "period")
+	bonusTotal = metrics.NewCounter("catch_cat_bonus_points_total",
+		"Bonus points granted by source: quest or streak.", "source")
+)
@@ -0,0 +1,16 @@
+package quests
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/quests", Tag: "user", Auth: true,
+		Summary: "Get my quests of today and this week, and my login streak",
+		Status:  http.StatusOK, Response: questsResponse{},
+	},
+}
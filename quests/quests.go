@@ -0,0 +1,318 @@
+// Package quests gives every user objectives for the day and the week, such
+// as catching three cats, a cat of a kind or opening a theme, and counts the consecutive days
+// the user comes back. Completing a quest or checking in adds bonus points to
+// the score; some quests also reward items. Days begin at midnight in the
+// time zone of the user and weeks on Monday.
+package quests
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/items"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Quest  = store.Quest
+	Streak = store.Streak
+)
+
+var (
+	quests store.Quests
+	users  store.Users
+	cats   store.Cats
+	// liveThemes is cats.LiveThemes, which imports this package
+	liveThemes func(now time.Time) ([]store.Theme, error)
+)
+
+// Init sets the repositories used by the handlers, and live which returns
+// the themes whose cats can be caught.
+func Init(s *store.Store, live func(now time.Time) ([]store.Theme, error)) {
+	quests = s.Quests
+	users = s.Users
+	cats = s.Cats
+	liveThemes = live
+}
+
+// template is a quest of a period, the target is picked for each user.
+type template struct {
+	kind        string
+	goal        int // at most the cats left to catch for a theme
+	bonus       int
+	rewardItem  string
+	rewardCount int
+}
+
+// the quests of a day and a week, the index is the slot
+var (
+	dailyQuests = []template{
+		{store.QuestCatch, 3, 30, "", 0},
+		{store.QuestVisit, 1, 20, "", 0},
+		{store.QuestCatKind, 1, 40, store.ItemRadar, 1},
+	}
+	weeklyQuests = []template{
+		{store.QuestCatch, 15, 150, store.ItemLure, 1},
+		{store.QuestTheme, 5, 100, store.ItemBait, 2},
+	}
+)
+
+// streakBonus is the bonus of the n-th day of a login streak, the last one
+// for a longer streak.
+var streakBonus = []int{5, 10, 15, 20, 25, 30, 50}
+
+const dateLayout = "2006-01-02"
+
+// calendar is the day and the week of a moment in the time zone of a user.
+type calendar struct {
+	loc       *time.Location
+	day       string // YYYY-MM-DD
+	yesterday string
+	week      string // the Monday
+	dayEnds   time.Time
+	weekEnds  time.Time
+}
+
+func calendarOf(u *store.User, now time.Time) calendar {
+	loc := config.Location
+	if u.Timezone != "" {
+		if l, err := time.LoadLocation(u.Timezone); err == nil {
+			loc = l
+		}
+	}
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	return calendar{
+		loc:       loc,
+		day:       today.Format(dateLayout),
+		yesterday: today.AddDate(0, 0, -1).Format(dateLayout),
+		week:      monday.Format(dateLayout),
+		dayEnds:   today.AddDate(0, 0, 1),
+		weekEnds:  monday.AddDate(0, 0, 7),
+	}
+}
+
+// current returns the quests of the day and the week of uid, assigning the
+// missing ones.
+func current(uid uint64, cal calendar) ([]Quest, error) {
+	list, err := quests.List(uid, cal.day, cal.week)
+	if err != nil || len(list) == len(dailyQuests)+len(weeklyQuests) {
+		return list, err
+	}
+
+	// the quests of a theme need a live theme with cats left to catch, they
+	// are assigned once there is one
+	themes, err := liveThemes(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	themeIDs := []uint64{}
+	for _, theme := range themes {
+		themeIDs = append(themeIDs, uint64(theme.ThemeID))
+	}
+	uncaught, err := cats.Uncaught(uid, themeIDs)
+	if err != nil {
+		return nil, err
+	}
+	left := map[uint64][]store.Cat{}
+	for _, cat := range uncaught {
+		left[cat.ThemeID] = append(left[cat.ThemeID], cat)
+	}
+	targets := []uint64{}
+	for _, themeID := range themeIDs {
+		if len(left[themeID]) > 0 {
+			targets = append(targets, themeID)
+		}
+	}
+
+	if err := quests.Assign(uid, pick(uid, store.QuestDaily, cal.day, dailyQuests, targets, left)); err != nil {
+		return nil, err
+	}
+	if err := quests.Assign(uid, pick(uid, store.QuestWeekly, cal.week, weeklyQuests, targets, left)); err != nil {
+		return nil, err
+	}
+	return quests.List(uid, cal.day, cal.week)
+}
+
+// pick fills in the templates of a period. The targets are random but the
+// same for the same user and period, so concurrent requests agree.
+func pick(uid uint64, period string, start string, templates []template, targets []uint64, left map[uint64][]store.Cat) []Quest {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatUint(uid, 10) + "/" + period + "/" + start))
+	rnd := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	list := []Quest{}
+	for slot, t := range templates {
+		q := Quest{
+			Slot: slot, Period: period, PeriodStart: start, Kind: t.kind, Goal: t.goal, Bonus: t.bonus,
+			RewardItem: t.rewardItem, RewardCount: t.rewardCount,
+		}
+		switch t.kind {
+		case store.QuestVisit:
+			if len(targets) == 0 {
+				continue
+			}
+			q.TargetID = targets[rnd.Intn(len(targets))]
+		case store.QuestTheme:
+			if len(targets) == 0 {
+				continue
+			}
+			q.TargetID = targets[rnd.Intn(len(targets))]
+			q.Goal = min(q.Goal, len(left[q.TargetID]))
+		case store.QuestCatKind:
+			if len(targets) == 0 {
+				continue
+			}
+			themeCats := left[targets[rnd.Intn(len(targets))]]
+			q.TargetID = themeCats[rnd.Intn(len(themeCats))].CatKindID
+		}
+		list = append(list, q)
+	}
+	return list
+}
+
+// Caught counts a catch toward the quests of uid and returns the bonus
+// points of the quests completed. A failure is logged and does not fail the
+// catch.
+func Caught(c *gin.Context, uid uint64, cat store.Cat) int {
+	u, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("quest progress failed", "uid", uid, "err", err)
+		return 0
+	}
+	now := time.Now()
+	cal := calendarOf(u, now)
+	if _, err := current(uid, cal); err != nil {
+		logging.From(c).Error("quest assign failed", "uid", uid, "err", err)
+		return 0
+	}
+	done, err := quests.Advance(uid, cal.day, cal.week, cat.ThemeID, cat.CatKindID, now.Unix())
+	if err != nil {
+		logging.From(c).Error("quest progress failed", "uid", uid, "err", err)
+		return 0
+	}
+	return completed(c, uid, done)
+}
+
+// Visited counts opening the theme toward the visit quests of uid. A failure
+// is logged and does not fail the request.
+func Visited(c *gin.Context, uid uint64, themeID uint64) {
+	u, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("quest progress failed", "uid", uid, "err", err)
+		return
+	}
+	now := time.Now()
+	cal := calendarOf(u, now)
+	if _, err := current(uid, cal); err != nil {
+		logging.From(c).Error("quest assign failed", "uid", uid, "err", err)
+		return
+	}
+	done, err := quests.Visit(uid, cal.day, cal.week, themeID, now.Unix())
+	if err != nil {
+		logging.From(c).Error("quest progress failed", "uid", uid, "err", err)
+		return
+	}
+	completed(c, uid, done)
+}
+
+// completed tells uid of the quests done and returns their bonus points.
+func completed(c *gin.Context, uid uint64, done []Quest) int {
+	bonus := 0
+	for _, q := range done {
+		bonus += q.Bonus
+		completedTotal.Inc(q.Period)
+		if q.RewardItem != "" {
+			items.Granted(q.RewardItem, q.RewardCount)
+		}
+		inbox.Add(c, uid, inbox.QuestComplete, inbox.Detail{
+			"quest_id":     q.QuestID,
+			"period":       q.Period,
+			"kind":         q.Kind,
+			"bonus":        q.Bonus,
+			"reward_item":  q.RewardItem,
+			"reward_count": q.RewardCount,
+		})
+	}
+	bonusTotal.Add(float64(bonus), "quest")
+	return bonus
+}
+
+// CheckIn counts the day toward the login streak of uid. A failure is logged
+// and does not fail the request.
+func CheckIn(c *gin.Context, uid uint64) {
+	u, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("check-in failed", "uid", uid, "err", err)
+		return
+	}
+	cal := calendarOf(u, time.Now())
+	_, bonus, err := quests.CheckIn(uid, cal.day, cal.yesterday, streakBonus)
+	if err != nil {
+		logging.From(c).Error("check-in failed", "uid", uid, "err", err)
+		return
+	}
+	bonusTotal.Add(float64(bonus), "streak")
+}
+
+type questsResponse struct {
+	Error    string  `json:"error"`
+	Timezone string  `json:"timezone"`  // in which days begin
+	DayEnds  int64   `json:"day_ends"`  // unix time
+	WeekEnds int64   `json:"week_ends"` // unix time
+	Daily    []Quest `json:"daily"`
+	Weekly   []Quest `json:"weekly"`
+	Streak   Streak  `json:"streak"`
+}
+
+// GET /v1/users/me/quests
+//
+// My quests of today and this week, and my login streak.
+func GetQuests(c *gin.Context) {
+	uid := session.UID(c)
+	u, err := users.Get(uid)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	cal := calendarOf(u, time.Now())
+	list, err := current(uid, cal)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := questsResponse{
+		Timezone: cal.loc.String(),
+		DayEnds:  cal.dayEnds.Unix(),
+		WeekEnds: cal.weekEnds.Unix(),
+		Daily:    []Quest{},
+		Weekly:   []Quest{},
+	}
+	for _, q := range list {
+		if q.Period == store.QuestDaily {
+			res.Daily = append(res.Daily, q)
+		} else {
+			res.Weekly = append(res.Weekly, q)
+		}
+	}
+	if res.Streak, err = quests.Streak(uid); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	// a streak is over when yesterday was missed
+	if res.Streak.LastDay != cal.day && res.Streak.LastDay != cal.yesterday {
+		res.Streak.Current = 0
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
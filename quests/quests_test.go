@@ -0,0 +1,162 @@
+package quests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCalendarOf(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name     string
+		timezone string
+		now      time.Time
+		want     calendar // without loc
+		wantLoc  string
+	}{
+		{"monday begins in Taipei", "Asia/Taipei", utc("2026-10-18T16:00:00Z"),
+			calendar{day: "2026-10-19", yesterday: "2026-10-18", week: "2026-10-19",
+				dayEnds: utc("2026-10-19T16:00:00Z"), weekEnds: utc("2026-10-25T16:00:00Z")}, "Asia/Taipei"},
+		{"last second of sunday in Taipei", "Asia/Taipei", utc("2026-10-18T15:59:59Z"),
+			calendar{day: "2026-10-18", yesterday: "2026-10-17", week: "2026-10-12",
+				dayEnds: utc("2026-10-18T16:00:00Z"), weekEnds: utc("2026-10-18T16:00:00Z")}, "Asia/Taipei"},
+		{"still sunday in Los Angeles", "America/Los_Angeles", utc("2026-10-18T16:00:00Z"),
+			calendar{day: "2026-10-18", yesterday: "2026-10-17", week: "2026-10-12",
+				dayEnds: utc("2026-10-19T07:00:00Z"), weekEnds: utc("2026-10-19T07:00:00Z")}, "America/Los_Angeles"},
+		// the clocks go back an hour at the end of the day, which lasts 25 hours
+		{"daylight saving ends", "America/Los_Angeles", utc("2026-11-01T12:00:00Z"),
+			calendar{day: "2026-11-01", yesterday: "2026-10-31", week: "2026-10-26",
+				dayEnds: utc("2026-11-02T08:00:00Z"), weekEnds: utc("2026-11-02T08:00:00Z")}, "America/Los_Angeles"},
+		{"unknown time zone", "Mars/Olympus", utc("2026-10-18T16:00:00Z"),
+			calendar{day: "2026-10-19", yesterday: "2026-10-18", week: "2026-10-19",
+				dayEnds: utc("2026-10-19T16:00:00Z"), weekEnds: utc("2026-10-25T16:00:00Z")}, config.Location.String()},
+	}
+	if config.Location.String() != "Asia/Taipei" {
+		t.Skipf("CATCH_CAT_TIMEZONE is %s", config.Location)
+	}
+	for _, tt := range tests {
+		got := calendarOf(&store.User{Timezone: tt.timezone}, tt.now)
+		if got.loc.String() != tt.wantLoc {
+			t.Errorf("%s: location = %s, want %s", tt.name, got.loc, tt.wantLoc)
+		}
+		got.loc = nil
+		if !got.dayEnds.Equal(tt.want.dayEnds) || !got.weekEnds.Equal(tt.want.weekEnds) {
+			t.Errorf("%s: ends = (%v, %v), want (%v, %v)", tt.name, got.dayEnds, got.weekEnds, tt.want.dayEnds, tt.want.weekEnds)
+		}
+		got.dayEnds, got.weekEnds = tt.want.dayEnds, tt.want.weekEnds
+		if got != tt.want {
+			t.Errorf("%s: calendar = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	left := map[uint64][]store.Cat{
+		1: {{CatID: 1, CatKind: store.CatKind{CatKindID: 10}}},
+		2: {{CatID: 2, CatKind: store.CatKind{CatKindID: 20}}, {CatID: 3, CatKind: store.CatKind{CatKindID: 30}}},
+	}
+	targets := []uint64{1, 2}
+
+	daily := pick(7, store.QuestDaily, "2026-10-19", dailyQuests, targets, left)
+	if again := pick(7, store.QuestDaily, "2026-10-19", dailyQuests, targets, left); !reflect.DeepEqual(daily, again) {
+		t.Errorf("pick again = %+v, want %+v", again, daily)
+	}
+	if len(daily) != len(dailyQuests) {
+		t.Fatalf("%d daily quests, want %d", len(daily), len(dailyQuests))
+	}
+	for _, q := range daily {
+		switch q.Kind {
+		case store.QuestVisit:
+			if len(left[q.TargetID]) == 0 || q.Goal != 1 {
+				t.Errorf("visit quest = %+v, want a theme of targets", q)
+			}
+		case store.QuestCatKind:
+			if q.TargetID != 10 && q.TargetID != 20 && q.TargetID != 30 {
+				t.Errorf("cat kind quest = %+v, want a kind left to catch", q)
+			}
+		}
+	}
+
+	// the goal of a theme is at most the cats left to catch
+	for _, q := range pick(7, store.QuestWeekly, "2026-10-19", weeklyQuests, targets, left) {
+		if q.Kind == store.QuestTheme && q.Goal != len(left[q.TargetID]) {
+			t.Errorf("theme quest = %+v, want a goal of %d", q, len(left[q.TargetID]))
+		}
+	}
+
+	// without targets only the quests of any cat are assigned
+	for _, q := range pick(7, store.QuestDaily, "2026-10-19", dailyQuests, nil, left) {
+		if q.Kind != store.QuestCatch {
+			t.Errorf("quest without targets = %+v", q)
+		}
+	}
+}
+
+func TestVisited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	defer s.Close()
+	theme := store.Theme{ThemeID: 1, Name: "theme"}
+	Init(s, func(now time.Time) ([]store.Theme, error) { return []store.Theme{theme}, nil })
+	inbox.Init(s)
+	if err := s.Themes.Save(theme); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	if err := s.Themes.SaveKind(store.CatKind{CatKindID: 1, Name: "kind", Weight: 10}); err != nil {
+		t.Fatalf("SaveKind: %v", err)
+	}
+	if err := s.Themes.AddCat(store.Cat{CatID: 1, ThemeID: 1, CatKind: store.CatKind{CatKindID: 1}}); err != nil {
+		t.Fatalf("AddCat: %v", err)
+	}
+	u := &store.User{Name: "alice", Email: "alice@example.com"}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	Visited(c, u.UID, 1)
+	Visited(c, u.UID, 1)
+
+	cal := calendarOf(u, time.Now())
+	list, err := quests.List(u.UID, cal.day, cal.week)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	visits := 0
+	for _, q := range list {
+		if q.Kind != store.QuestVisit {
+			continue
+		}
+		visits++
+		if q.Completed == 0 || q.Progress != 1 {
+			t.Errorf("visit quest = %+v, want it completed", q)
+		}
+	}
+	if visits != 1 {
+		t.Errorf("%d visit quests, want 1", visits)
+	}
+	if stats, err := s.Users.Stats(u.UID); err != nil {
+		t.Fatalf("Stats: %v", err)
+	} else if stats.Score != dailyQuests[1].bonus {
+		t.Errorf("score = %d, want the bonus %d once", stats.Score, dailyQuests[1].bonus)
+	}
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout locks a key for a while after too many failures, e.g. wrong
+// passwords of an account.
+type Lockout struct {
+	max     int           // failures allowed in window
+	window  time.Duration // failures older than window are forgotten
+	lock    time.Duration // how long a key is locked
+	mu      sync.Mutex
+	entries map[string]*lockEntry
+	sweep   time.Time
+}
+
+type lockEntry struct {
+	fails int
+	first time.Time
+	until time.Time
+}
+
+func NewLockout(max int, window time.Duration, lock time.Duration) *Lockout {
+	return &Lockout{
+		max:     max,
+		window:  window,
+		lock:    lock,
+		entries: map[string]*lockEntry{},
+		sweep:   time.Now(),
+	}
+}
+
+// Locked reports whether key is locked and how long it remains locked.
+func (l *Lockout) Locked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return false, 0
+	}
+	if remain := time.Until(e.until); remain > 0 {
+		return true, remain
+	}
+	return false, 0
+}
+
+// Fail records a failure of key and locks it once max failures happen within
+// the window.
+func (l *Lockout) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.gc(now)
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.first) > l.window {
+		e = &lockEntry{first: now}
+		l.entries[key] = e
+	}
+	e.fails++
+	if e.fails >= l.max {
+		e.until = now.Add(l.lock)
+		e.fails = 0
+		e.first = now
+	}
+}
+
+// Reset forgets the failures of key, e.g. after a successful login.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+func (l *Lockout) gc(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	for key, e := range l.entries {
+		if now.Sub(e.first) > l.window && now.After(e.until) {
+			delete(l.entries, key)
+		}
+	}
+}
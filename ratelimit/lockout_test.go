@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	l := NewLockout(3, 15*time.Minute, 10*time.Minute)
+
+	l.Fail("a")
+	l.Fail("a")
+	if locked, _ := l.Locked("a"); locked {
+		t.Fatal("locked before max failures")
+	}
+	l.Fail("a")
+	locked, remain := l.Locked("a")
+	if !locked || remain <= 9*time.Minute || remain > 10*time.Minute {
+		t.Fatalf("Locked = (%v, %v), want about 10m", locked, remain)
+	}
+	if locked, _ := l.Locked("b"); locked {
+		t.Error("another key locked")
+	}
+
+	l.Reset("a")
+	if locked, _ := l.Locked("a"); locked {
+		t.Error("locked after Reset")
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	l := NewLockout(3, 15*time.Minute, 10*time.Minute)
+
+	l.Fail("a")
+	l.Fail("a")
+	// the failures happened longer than the window ago
+	l.entries["a"].first = l.entries["a"].first.Add(-16 * time.Minute)
+	l.Fail("a")
+	if locked, _ := l.Locked("a"); locked {
+		t.Error("failures older than the window counted")
+	}
+	if got := l.entries["a"].fails; got != 1 {
+		t.Errorf("fails = %d, want 1 in the new window", got)
+	}
+
+	// the lock expires
+	l.Fail("a")
+	l.Fail("a")
+	l.entries["a"].until = time.Now().Add(-time.Second)
+	if locked, _ := l.Locked("a"); locked {
+		t.Error("locked after the lock expired")
+	}
+}
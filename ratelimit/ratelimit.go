@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+)
+
+// Limiter is an in-process token bucket limiter. Every key owns a bucket of
+// n tokens which is refilled at n tokens per period.
+type Limiter struct {
+	burst   float64
+	rate    float64 // tokens per second
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(n int, period time.Duration) *Limiter {
+	return &Limiter{
+		burst:   float64(n),
+		rate:    float64(n) / period.Seconds(),
+		buckets: map[string]*bucket{},
+		sweep:   time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key. If the bucket is empty it
+// returns false and how long to wait for the next token.
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.gc(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// gc drops the buckets which have been refilled completely, the result is the
+// same as keeping them.
+func (l *Limiter) gc(now time.Time) {
+	if now.Sub(l.sweep) < time.Minute {
+		return
+	}
+	l.sweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ByIP limits the requests of each client IP.
+func ByIP(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, after := l.Allow(c.ClientIP()); !ok {
+			errcode.Abort(c, errcode.ErrTooManyRequests.Retry(after))
+			return
+		}
+		c.Next()
+	}
+}
+
+// ByUser limits the requests of each logged in user, it must be used after
+// session.Auth.
+func ByUser(l *Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, after := l.Allow(strconv.FormatUint(session.UID(c), 10)); !ok {
+			errcode.Abort(c, errcode.ErrTooManyRequests.Retry(after))
+			return
+		}
+		c.Next()
+	}
+}
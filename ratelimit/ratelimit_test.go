@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rewind moves the last refill of the bucket of key back by d, as if d
+// passed.
+func (l *Limiter) rewind(key string, d time.Duration) {
+	l.buckets[key].last = l.buckets[key].last.Add(-d)
+}
+
+func TestLimiterAllow(t *testing.T) {
+	l := New(3, time.Minute) // a token every 20s
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	ok, after := l.Allow("a")
+	if ok {
+		t.Fatal("request over the burst allowed")
+	}
+	if after <= 19*time.Second || after > 20*time.Second {
+		t.Errorf("retry after %v, want about 20s", after)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another key rejected")
+	}
+
+	l.rewind("a", 20*time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refilled token rejected")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("more than one token refilled in 20s")
+	}
+
+	// a long pause refills the burst and no more
+	l.rewind("a", time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d after a pause rejected", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("bucket refilled over the burst")
+	}
+}
+
+func TestLimiterGC(t *testing.T) {
+	l := New(2, time.Minute)
+	l.Allow("full")
+	l.Allow("empty")
+	l.Allow("empty")
+	l.rewind("full", 30*time.Second)
+	l.sweep = l.sweep.Add(-time.Minute)
+
+	l.Allow("other")
+	if _, ok := l.buckets["full"]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := l.buckets["empty"]; !ok {
+		t.Error("empty bucket dropped")
+	}
+}
+
+func TestByIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", ByIP(New(1, time.Hour)), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		ip         string
+		wantStatus int
+		wantRetry  string
+	}{
+		{"192.0.2.1:1234", http.StatusNoContent, ""},
+		{"192.0.2.1:5678", http.StatusTooManyRequests, "3600"},
+		{"192.0.2.2:1234", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.ip
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus || w.Header().Get("Retry-After") != tt.wantRetry {
+			t.Errorf("%s: status %d, Retry-After %q, want %d, %q", tt.ip,
+				w.Code, w.Header().Get("Retry-After"), tt.wantStatus, tt.wantRetry)
+		}
+	}
+}
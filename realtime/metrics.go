@@ -0,0 +1,6 @@
+package realtime
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var droppedTotal = metrics.NewCounter("catch_cat_realtime_dropped_total",
+	"Events not delivered to a connected client whose buffer was full, by type.", "type")
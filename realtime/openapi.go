@@ -0,0 +1,16 @@
+package realtime
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/events", Tag: "user", Auth: true,
+		Summary: "Stream my events as server-sent events",
+		Status:  http.StatusOK, ContentType: "text/event-stream",
+	},
+}
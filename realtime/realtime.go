@@ -0,0 +1,119 @@
+// Package realtime streams events to the connected clients of a user over
+// server-sent events. Delivery is best effort: a client that is not
+// connected, or too slow, misses the event and catches up through the REST
+// API, so whatever is sent here must also be stored elsewhere.
+package realtime
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// types of events
+const (
+	Message      = "message"      // a store.Message sent to me
+	Read         = "read"         // uid, message_id: uid read my messages up to message_id
+	Notification = "notification" // a store.Notification of the inbox
+)
+
+const (
+	bufferSize = 16
+	heartbeat  = 25 * time.Second
+)
+
+// Event is sent as "event: <Type>" with Data encoded as JSON.
+type Event struct {
+	Type string
+	Data any
+}
+
+var (
+	mu      sync.Mutex
+	clients = map[uint64]map[chan Event]bool{}
+)
+
+// Publish sends e to every connected client of uid without blocking, and
+// returns to how many.
+func Publish(uid uint64, e Event) int {
+	mu.Lock()
+	defer mu.Unlock()
+	n := 0
+	for ch := range clients[uid] {
+		select {
+		case ch <- e:
+			n++
+		default:
+			droppedTotal.Inc(e.Type)
+		}
+	}
+	return n
+}
+
+func subscribe(uid uint64) chan Event {
+	ch := make(chan Event, bufferSize)
+	mu.Lock()
+	defer mu.Unlock()
+	if clients[uid] == nil {
+		clients[uid] = map[chan Event]bool{}
+	}
+	clients[uid][ch] = true
+	return ch
+}
+
+func unsubscribe(uid uint64, ch chan Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(clients[uid], ch)
+	if len(clients[uid]) == 0 {
+		delete(clients, uid)
+	}
+}
+
+// Connections returns the number of connected clients.
+func Connections() int {
+	mu.Lock()
+	defer mu.Unlock()
+	n := 0
+	for _, set := range clients {
+		n += len(set)
+	}
+	return n
+}
+
+// GET /v1/events
+//
+// Stream my events until the client disconnects or the session ends.
+func GetEvents(c *gin.Context) {
+	p := session.Current(c)
+	ch := subscribe(p.UID)
+	defer unsubscribe(p.UID, ch)
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // for nginx
+	c.Status(http.StatusOK)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case e := <-ch:
+			c.SSEvent(e.Type, e.Data)
+			return true
+		case <-ticker.C:
+			// logging out ends the stream
+			if _, ok := session.Get(p.Token); !ok {
+				return false
+			}
+			io.WriteString(w, ": ping\n\n")
+			return true
+		}
+	})
+}
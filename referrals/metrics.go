@@ -0,0 +1,6 @@
+package referrals
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var referralTotal = metrics.NewCounter("catch_cat_referrals_total",
+	"Referrals by result: linked, limited, rewarded or capped.", "result")
@@ -0,0 +1,16 @@
+package referrals
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/referral", Tag: "user", Auth: true,
+		Summary: "Get my referral code and the users I referred",
+		Status:  http.StatusOK, Response: referralResponse{},
+	},
+}
@@ -0,0 +1,194 @@
+// Package referrals lets a user invite others with a referral code. A user
+// registering with a code becomes a friend of its owner, and both are
+// rewarded once the new user has verified the email and caught a cat, in
+// either order. A referrer can refer a few users a day and is rewarded for a
+// limited number of them, so that made-up accounts do not pay.
+package referrals
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/items"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Referral = store.Referral
+
+var (
+	referrals store.Referrals
+	users     store.Users
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	referrals = s.Referrals
+	users = s.Users
+}
+
+const (
+	// maxPerDay is how many users a referrer can refer in 24 hours
+	maxPerDay = 5
+	// maxRewards is how many referrals a referrer is rewarded for
+	maxRewards = 20
+
+	codeLength  = 8
+	codeCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0, 1, I and O
+)
+
+var (
+	referrerReward = store.ReferralReward{Bonus: 100, Item: store.ItemRadar, Count: 2}
+	refereeReward  = store.ReferralReward{Bonus: 50, Item: store.ItemBait, Count: 2}
+)
+
+// code returns the referral code of uid, creating it on first use.
+func code(uid uint64) (string, error) {
+	for {
+		code, err := referrals.Code(uid)
+		if !errors.Is(err, store.ErrNotFound) {
+			return code, err
+		}
+		b := make([]byte, codeLength)
+		for i := range b {
+			b[i] = codeCharset[rand.Intn(len(codeCharset))]
+		}
+		// on a conflict the code is taken or uid got one meanwhile
+		if err := referrals.SetCode(uid, string(b)); err != nil && !errors.Is(err, store.ErrConflict) {
+			return "", err
+		}
+	}
+}
+
+// Referrer returns the owner of a code entered at registration, which is
+// case insensitive, and checks that the owner can refer one more user.
+func Referrer(code string) (uint64, error) {
+	uid, err := referrals.Referrer(strings.ToUpper(strings.TrimSpace(code)))
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, errcode.ErrReferralCode
+	} else if err != nil {
+		return 0, err
+	}
+	if n, err := referrals.Count(uid, time.Now().Add(-24*time.Hour).Unix()); err != nil {
+		return 0, err
+	} else if n >= maxPerDay {
+		referralTotal.Inc("limited")
+		return 0, errcode.ErrReferralLimit
+	}
+	return uid, nil
+}
+
+// Link records that referrerID referred the new user uid and makes them
+// friends. A failure is logged and does not fail the registration.
+func Link(c *gin.Context, referrerID uint64, uid uint64, name string) {
+	now := time.Now()
+	ok, err := referrals.Link(referrerID, uid, now.Unix(), now.Add(-24*time.Hour).Unix(), maxPerDay)
+	if err != nil {
+		logging.From(c).Error("referral link failed", "referrer", referrerID, "uid", uid, "err", err)
+		return
+	} else if !ok {
+		// the referrer reached the limit after Referrer checked it
+		referralTotal.Inc("limited")
+		return
+	}
+	referralTotal.Inc("linked")
+	inbox.Add(c, referrerID, inbox.ReferralJoin, inbox.Detail{"uid": uid, "name": name})
+}
+
+// Caught records a catch of uid and rewards the referral of uid if uid has
+// verified the email. A failure is logged and does not fail the catch.
+func Caught(c *gin.Context, uid uint64) {
+	if err := referrals.Caught(uid, time.Now().Unix()); err != nil {
+		logging.From(c).Error("referral catch failed", "uid", uid, "err", err)
+		return
+	}
+	reward(c, uid)
+}
+
+// Verified rewards the referral of uid, who verified the email, if uid has
+// caught a cat. A failure is logged and does not fail the verification.
+func Verified(c *gin.Context, uid uint64) {
+	reward(c, uid)
+}
+
+// reward rewards the referral of uid if uid has verified the email and
+// caught a cat.
+func reward(c *gin.Context, uid uint64) {
+	ref, err := referrals.Reward(uid, time.Now().Unix(), maxRewards, referrerReward, refereeReward)
+	if err != nil {
+		logging.From(c).Error("referral reward failed", "uid", uid, "err", err)
+		return
+	} else if ref == nil {
+		return
+	}
+	referralTotal.Inc(ref.Status)
+
+	referrer, err := users.Get(ref.Referrer)
+	if err != nil {
+		logging.From(c).Error("referral reward failed", "uid", uid, "err", err)
+		return
+	}
+	notice(c, uid, referrer.UID, referrer.Name, refereeReward)
+	if ref.Status == store.ReferralRewarded {
+		notice(c, referrer.UID, uid, ref.Name, referrerReward)
+	}
+}
+
+// notice tells uid about the reward of the referral with otherUID.
+func notice(c *gin.Context, uid uint64, otherUID uint64, name string, reward store.ReferralReward) {
+	items.Granted(reward.Item, reward.Count)
+	inbox.Add(c, uid, inbox.ReferralReward, inbox.Detail{
+		"uid":          otherUID,
+		"name":         name,
+		"bonus":        reward.Bonus,
+		"reward_item":  reward.Item,
+		"reward_count": reward.Count,
+	})
+}
+
+type referralResponse struct {
+	Error      string     `json:"error"`
+	Code       string     `json:"code"`
+	ReferredBy uint64     `json:"referred_by"` // 0 if nobody
+	List       []Referral `json:"list"`        // the users I referred
+	Rewarded   int        `json:"rewarded"`    // how many of them I was rewarded for
+	MaxRewards int        `json:"max_rewards"`
+	MaxPerDay  int        `json:"max_per_day"`
+}
+
+// GET /v1/users/me/referral
+//
+// My referral code and the users I referred.
+func GetReferral(c *gin.Context) {
+	uid := session.UID(c)
+	res := referralResponse{MaxRewards: maxRewards, MaxPerDay: maxPerDay}
+	var err error
+	if res.Code, err = code(uid); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if ref, err := referrals.ReferredBy(uid); err == nil {
+		res.ReferredBy = ref.Referrer
+	} else if !errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, err)
+		return
+	}
+	if res.List, err = referrals.List(uid); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	for _, ref := range res.List {
+		if ref.Status == store.ReferralRewarded {
+			res.Rewarded++
+		}
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
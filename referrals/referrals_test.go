@@ -0,0 +1,142 @@
+package referrals
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers and creates bob, who has the
+// code BOBCODE1.
+func setup(t *testing.T) (*store.Store, uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	inbox.Init(s)
+
+	bob := newUser(t, s, "bob")
+	if err := s.Referrals.SetCode(bob, "BOBCODE1"); err != nil {
+		t.Fatalf("SetCode: %v", err)
+	}
+	return s, bob
+}
+
+func newUser(t *testing.T, s *store.Store, name string) uint64 {
+	t.Helper()
+	u := &store.User{Name: name, Email: name + "@example.com"}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return u.UID
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+// notices returns the kinds of the notifications of uid.
+func notices(t *testing.T, s *store.Store, uid uint64) []string {
+	t.Helper()
+	list, err := s.Notifications.List(uid, false, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	kinds := []string{}
+	for _, n := range list {
+		kinds = append(kinds, n.Kind)
+	}
+	return kinds
+}
+
+func TestReferrer(t *testing.T) {
+	s, bob := setup(t)
+	tests := []struct {
+		code    string
+		wantErr error
+	}{
+		{"BOBCODE1", nil},
+		{" bobcode1\n", nil},
+		{"BOBCODE2", errcode.ErrReferralCode},
+	}
+	for _, tt := range tests {
+		uid, err := Referrer(tt.code)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Referrer(%q): err = %v, want %v", tt.code, err, tt.wantErr)
+		} else if err == nil && uid != bob {
+			t.Errorf("Referrer(%q) = %d, want %d", tt.code, uid, bob)
+		}
+	}
+
+	// a code can be used by maxPerDay users a day
+	for i := 0; i < maxPerDay; i++ {
+		name := "referee" + strconv.Itoa(i)
+		Link(context(), bob, newUser(t, s, name), name)
+	}
+	if _, err := Referrer("BOBCODE1"); !errors.Is(err, errcode.ErrReferralLimit) {
+		t.Errorf("Referrer over the limit: err = %v, want ErrReferralLimit", err)
+	}
+}
+
+func TestVerifiedAndCaught(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []string // "verify" and "catch"
+	}{
+		{"verify first", []string{"verify", "catch"}},
+		{"catch first", []string{"catch", "verify"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, bob := setup(t)
+			alice := newUser(t, s, "alice")
+			Link(context(), bob, alice, "alice")
+
+			for i, step := range tt.order {
+				switch step {
+				case "verify":
+					if err := s.Users.AddVerifyEmail(alice, "alice@example.com", "hash", 1<<40); err != nil {
+						t.Fatalf("AddVerifyEmail: %v", err)
+					}
+					if _, err := s.Users.Verify("hash", 1); err != nil {
+						t.Fatalf("Verify: %v", err)
+					}
+					Verified(context(), alice)
+				case "catch":
+					Caught(context(), alice)
+				}
+				want := 0
+				if i == len(tt.order)-1 {
+					want = 1 // rewarded by the last step only
+				}
+				if got := len(notices(t, s, alice)); got != want {
+					t.Errorf("after %s: %d notices of alice, want %d", step, got, want)
+				}
+			}
+
+			ref, err := s.Referrals.ReferredBy(alice)
+			if err != nil || ref.Status != store.ReferralRewarded {
+				t.Fatalf("ReferredBy = (%+v, %v), want rewarded", ref, err)
+			}
+			// bob was told that alice joined and that both were rewarded
+			want := []string{inbox.ReferralReward, inbox.ReferralJoin}
+			if got := notices(t, s, bob); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+				t.Errorf("notices of bob = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package session
 
 import (
-	"net/http"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ksw2000/catch_cat_server/errcode"
 	"github.com/ksw2000/catch_cat_server/util"
 )
 
@@ -11,12 +16,22 @@ type Session struct {
 	value map[string]interface{}
 }
 
-var bucket map[string]*Session
+// Principal is the authenticated user of a request, set by Auth.
+type Principal struct {
+	UID   uint64
+	Token string
+}
+
+const principalKey = "session.principal"
+
+var bucket = map[string]*Session{}
+var mu sync.RWMutex
+
+// NewSession creates a session of uid and returns its token.
+func NewSession(uid uint64) (token string) {
+	mu.Lock()
+	defer mu.Unlock()
 
-func NewSession() (token string) {
-	if bucket == nil {
-		bucket = make(map[string]*Session)
-	}
 	token = util.RandomString(256)
 
 	// if token has been existed
@@ -26,32 +41,102 @@ func NewSession() (token string) {
 	}
 
 	bucket[token] = &Session{
-		value: map[string]interface{}{},
+		value: map[string]interface{}{"uid": uid},
 	}
 	return token
 }
 
-func Get(token string) (value map[string]interface{}, ok bool) {
+// Get returns the uid of the session, ok is false if there is no such
+// session.
+func Get(token string) (uid uint64, ok bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
 	session, ok := bucket[token]
 	if !ok {
-		return nil, ok
+		return 0, ok
 	}
-	return session.value, ok
+	uid, ok = session.value["uid"].(uint64)
+	return uid, ok
 }
 
 func Destroy(token string) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	delete(bucket, token)
 }
 
-func CheckLogin(c *gin.Context, sessionID string) (uid uint64, isLogin bool) {
-	val, isLogin := Get(sessionID)
-	if !isLogin {
-		c.IndentedJSON(http.StatusUnauthorized, struct {
-			Error string `json:"error"`
-		}{"未登入"})
-		return
+// Count returns the number of sessions in the bucket.
+func Count() int {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return len(bucket)
+}
+
+// DestroyUser logs out every session of uid.
+func DestroyUser(uid uint64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for token, session := range bucket {
+		if id, ok := session.value["uid"].(uint64); ok && id == uid {
+			delete(bucket, token)
+		}
 	}
+}
+
+// Auth authenticates the request and puts a *Principal into the gin context.
+// The token is read from "Authorization: Bearer <token>"; for backward
+// compatibility the "session" field of a JSON body is accepted as well.
+func Auth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := Token(c)
+		uid, ok := Get(token)
+		if !ok {
+			errcode.Abort(c, errcode.ErrNotLogin)
+			return
+		}
+		c.Set(principalKey, &Principal{
+			UID:   uid,
+			Token: token,
+		})
+		c.Next()
+	}
+}
+
+// Token returns the session token carried by the request, or "" if there is
+// none. The request body is left intact for the handler to bind.
+func Token(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	legacy := struct {
+		Session string `json:"session"`
+	}{}
+	json.Unmarshal(body, &legacy)
+	return legacy.Session
+}
+
+// Current returns the principal set by Auth.
+func Current(c *gin.Context) *Principal {
+	return c.MustGet(principalKey).(*Principal)
+}
 
-	uid = val["uid"].(uint64)
-	return
+// UID returns the uid of the principal set by Auth.
+func UID(c *gin.Context) uint64 {
+	return Current(c).UID
 }
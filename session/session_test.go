@@ -0,0 +1,92 @@
+package session
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSession(t *testing.T) {
+	a := NewSession(1)
+	b := NewSession(1)
+	other := NewSession(2)
+	if a == b {
+		t.Fatal("two sessions share a token")
+	}
+	if uid, ok := Get(a); !ok || uid != 1 {
+		t.Errorf("Get = (%d, %v), want (1, true)", uid, ok)
+	}
+	if _, ok := Get("unknown"); ok {
+		t.Error("Get of an unknown token ok")
+	}
+
+	Destroy(a)
+	if _, ok := Get(a); ok {
+		t.Error("Get after Destroy ok")
+	}
+	if _, ok := Get(b); !ok {
+		t.Error("Destroy logged out another session of the user")
+	}
+
+	DestroyUser(1)
+	if _, ok := Get(b); ok {
+		t.Error("Get after DestroyUser ok")
+	}
+	if _, ok := Get(other); !ok {
+		t.Error("DestroyUser logged out another user")
+	}
+	Destroy(other)
+}
+
+func TestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token := NewSession(7)
+	defer Destroy(token)
+
+	r := gin.New()
+	r.POST("/", Auth(), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		if p := Current(c); p.Token != token {
+			t.Errorf("principal token %q, want the session", p.Token)
+		}
+		c.String(http.StatusOK, "%d %s", UID(c), body)
+	})
+
+	tests := []struct {
+		name        string
+		auth        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"bearer", "Bearer " + token, "", "", http.StatusOK, "7 "},
+		{"legacy body left intact", "", gin.MIMEJSON, `{"session":"` + token + `"}`,
+			http.StatusOK, `7 {"session":"` + token + `"}`},
+		{"header wins over the body", "Bearer unknown", gin.MIMEJSON, `{"session":"` + token + `"}`,
+			http.StatusUnauthorized, ""},
+		{"not a bearer", "Basic " + token, "", "", http.StatusUnauthorized, ""},
+		{"body that is not JSON", "", "text/plain", `{"session":"` + token + `"}`, http.StatusUnauthorized, ""},
+		{"none", "", "", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		} else if tt.wantStatus == http.StatusOK && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: body %q, want %q", tt.name, w.Body.String(), tt.wantBody)
+		}
+	}
+}
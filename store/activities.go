@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+)
+
+// Activity is a row of activity joined with the actor. Detail is a JSON
+// object.
+type Activity struct {
+	ActivityID uint64          `json:"activity_id"`
+	ActorUID   uint64          `json:"uid"`
+	Name       string          `json:"name"`    // of the actor
+	Profile    string          `json:"profile"` // of the actor
+	Kind       string          `json:"kind"`
+	Detail     json.RawMessage `json:"detail"`
+	Timing     int64           `json:"timing"`
+}
+
+type Activities interface {
+	// Publish copies the activity of actor into the feeds of the friends of
+	// actor, unless actor does not share activities.
+	Publish(actor uint64, kind string, detail json.RawMessage, t int64) error
+	// Feed returns the activities in the feed of uid, newest first, whose
+	// actors are still friends and still share activities. before is the
+	// activity_id to continue from, 0 for the first page.
+	Feed(uid uint64, before uint64, limit int) ([]Activity, error)
+}
+
+type activities struct {
+	db *conn
+}
+
+func (r *activities) Publish(actor uint64, kind string, detail json.RawMessage, t int64) error {
+	// fan out to the friends as Friends.AreFriends
+	_, err := r.db.Exec(`
+		INSERT INTO activity(owner_id, actor_id, kind, detail, timing)
+		SELECT f1.user_id_dest, ?, ?, ?, ?
+		FROM friend f1
+		JOIN friend f2 ON f2.user_id_src = f1.user_id_dest and f2.user_id_dest = f1.user_id_src
+		JOIN "user" ON "user".user_id = f1.user_id_src
+		WHERE f1.user_id_src = ?
+			and f1.accepted = TRUE and f1.ban = FALSE
+			and f2.accepted = TRUE and f2.ban = FALSE
+			and "user".share_activity = TRUE`,
+		actor, kind, string(detail), t, actor)
+	return err
+}
+
+func (r *activities) Feed(uid uint64, before uint64, limit int) ([]Activity, error) {
+	where := ""
+	args := []any{uid}
+	if before != 0 {
+		where = "and activity.activity_id < ?"
+		args = append(args, before)
+	}
+	args = append(args, limit)
+
+	list := []Activity{}
+	rows, err := r.db.Query(`
+		SELECT activity.activity_id, activity.actor_id, "user".name, "user".profile,
+		       activity.kind, activity.detail, activity.timing
+		FROM activity
+		JOIN "user" ON "user".user_id = activity.actor_id
+		JOIN friend ON friend.user_id_src = activity.owner_id and friend.user_id_dest = activity.actor_id
+		WHERE activity.owner_id = ? `+where+`
+			and "user".share_activity = TRUE
+			and friend.accepted = TRUE and friend.ban = FALSE
+		ORDER BY activity.activity_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := Activity{}
+		// detail is TEXT, see Audits.Query
+		var detail []byte
+		if err := rows.Scan(&a.ActivityID, &a.ActorUID, &a.Name, &a.Profile, &a.Kind, &detail, &a.Timing); err != nil {
+			return nil, err
+		}
+		a.Detail = detail
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestActivitiesFeed(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	carol := f.newUser("carol")
+	dave := f.newUser("dave")
+	f.befriend(alice, bob)
+	f.befriend(alice, carol)
+	// dave is still inviting alice
+	if err := f.Friends.Insert(Relation{Src: dave, Dest: alice}); err != nil {
+		t.Fatalf("Insert friend: %v", err)
+	}
+
+	share := func(uid uint64, share bool) {
+		t.Helper()
+		if err := f.Users.UpdateShareActivity(uid, share); err != nil {
+			t.Fatalf("UpdateShareActivity: %v", err)
+		}
+	}
+	publish := func(uid uint64, kind string, at int64) {
+		t.Helper()
+		if err := f.Activities.Publish(uid, kind, []byte(`{"level":2}`), at); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	feed := func(uid uint64, before uint64, limit int) []Activity {
+		t.Helper()
+		list, err := f.Activities.Feed(uid, before, limit)
+		if err != nil {
+			t.Fatalf("Feed: %v", err)
+		}
+		return list
+	}
+	kinds := func(list []Activity) []string {
+		got := []string{}
+		for _, a := range list {
+			got = append(got, a.Kind)
+		}
+		return got
+	}
+
+	// carol does not share, so alice gets nothing
+	publish(carol, "hidden", 1)
+	share(alice, true)
+	publish(alice, "a", 2)
+	publish(alice, "b", 3)
+	publish(alice, "c", 4)
+
+	// the feed of bob is paged by activity_id, newest first
+	page := feed(bob, 0, 2)
+	if got, want := kinds(page), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first page of bob = %v, want %v", got, want)
+	}
+	if a := page[0]; a.ActorUID != alice || a.Name != "alice" || a.Timing != 4 || string(a.Detail) != `{"level":2}` {
+		t.Errorf("activity = %+v, want c of alice at 4", a)
+	}
+	if got, want := kinds(feed(bob, page[1].ActivityID, 2)), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second page of bob = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name string
+		uid  uint64
+		want []string
+	}{
+		{"alice", alice, []string{}},
+		{"carol", carol, []string{"c", "b", "a"}},
+		{"dave, not accepted", dave, []string{}},
+	}
+	for _, tt := range tests {
+		if got := kinds(feed(tt.uid, 0, 10)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("feed of %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// the activities are hidden while alice does not share and after carol
+	// is no longer a friend
+	share(alice, false)
+	publish(alice, "d", 5)
+	if err := f.Friends.Delete(carol, alice); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := kinds(feed(bob, 0, 10)); len(got) != 0 {
+		t.Errorf("feed of bob while alice does not share = %v, want none", got)
+	}
+	share(alice, true)
+	if got, want := kinds(feed(bob, 0, 10)), []string{"c", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("feed of bob after sharing again = %v, want %v", got, want)
+	}
+	if got := kinds(feed(carol, 0, 10)); len(got) != 0 {
+		t.Errorf("feed of carol after the deletion = %v, want none", got)
+	}
+}
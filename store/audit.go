@@ -0,0 +1,100 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// AuditEvent is a row of audit_log. UID is 0 if the user is unknown, e.g. a
+// login with an unregistered email. Detail is a JSON object.
+type AuditEvent struct {
+	AuditID   uint64          `json:"audit_id"`
+	Timing    int64           `json:"timing"`
+	Event     string          `json:"event"`
+	UID       uint64          `json:"uid"`
+	IP        string          `json:"ip"`
+	RequestID string          `json:"request_id"`
+	Detail    json.RawMessage `json:"detail"`
+}
+
+// AuditFilter selects audit events, zero fields match everything. Events are
+// returned newest first; Before is the audit_id to continue from.
+type AuditFilter struct {
+	UID    uint64
+	Event  string
+	Since  int64
+	Until  int64
+	Before uint64
+	Limit  int
+}
+
+type Audits interface {
+	Record(e *AuditEvent) error
+	Query(f AuditFilter) ([]AuditEvent, error)
+}
+
+type audits struct {
+	db *conn
+}
+
+func (r *audits) Record(e *AuditEvent) error {
+	return recordAudit(r.db, e)
+}
+
+// recordAudit writes e by db, a conn or a tx.
+func recordAudit(db execer, e *AuditEvent) error {
+	_, err := db.Exec(`
+		INSERT INTO audit_log(timing, event, user_id, ip, request_id, detail)
+		values(?, ?, ?, ?, ?, ?)`, e.Timing, e.Event, e.UID, e.IP, e.RequestID, string(e.Detail))
+	return err
+}
+
+func (r *audits) Query(f AuditFilter) ([]AuditEvent, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+	if f.UID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UID)
+	}
+	if f.Event != "" {
+		where = append(where, "event = ?")
+		args = append(args, f.Event)
+	}
+	if f.Since != 0 {
+		where = append(where, "timing >= ?")
+		args = append(args, f.Since)
+	}
+	if f.Until != 0 {
+		where = append(where, "timing < ?")
+		args = append(args, f.Until)
+	}
+	if f.Before != 0 {
+		where = append(where, "audit_id < ?")
+		args = append(args, f.Before)
+	}
+	args = append(args, f.Limit)
+
+	list := []AuditEvent{}
+	rows, err := r.db.Query(`
+		SELECT audit_id, timing, event, user_id, ip, request_id, detail
+		FROM audit_log
+		WHERE `+strings.Join(where, " and ")+`
+		ORDER BY audit_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := AuditEvent{}
+		// detail is TEXT, which database/sql scans into []byte but not into
+		// json.RawMessage
+		var detail []byte
+		if err := rows.Scan(&e.AuditID, &e.Timing, &e.Event, &e.UID, &e.IP, &e.RequestID, &detail); err != nil {
+			return nil, err
+		}
+		e.Detail = detail
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
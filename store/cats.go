@@ -0,0 +1,483 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type CatKind struct {
+	CatKindID   uint64 `json:"cat_kind_id"`
+	Weight      int    `json:"weight"`
+	Thumbnail   string `json:"thumbnail"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type Cat struct {
+	CatID    uint64  `json:"cat_id"`
+	ThemeID  uint64  `json:"theme_id"`
+	Lng      float64 `json:"lng"`
+	Lat      float64 `json:"lat"`
+	IsCaught bool    `json:"is_caught"`
+	CatKind
+}
+
+type CatKindCaught struct {
+	IsCaught bool `json:"is_caught"`
+	CatKind
+}
+
+// Catch is a row of user_cat with the cat it refers to.
+type Catch struct {
+	CatID     uint64
+	CatKindID uint64
+	ThemeID   uint64
+	Lng       float64
+	Lat       float64
+	Timing    int64
+}
+
+type Cats interface {
+	// Get returns the cat with its kind, IsCaught is not filled.
+	Get(catID uint64) (*Cat, error)
+	// ThemeCats returns the cats of the theme and whether uid caught them or
+	// traded them away.
+	ThemeCats(uid uint64, themeID uint64) ([]Cat, error)
+	// IsCaught reports whether uid caught the cat or traded it away, either
+	// way uid can not catch it.
+	IsCaught(uid uint64, catID uint64) (bool, error)
+	// Uncaught returns the cats of the themes uid can still catch, that is
+	// neither caught nor traded away, ordered by cat_id.
+	Uncaught(uid uint64, themeIDs []uint64) ([]Cat, error)
+	// Catch records that uid caught the cat, it reports false if uid had
+	// caught it already, and whether uid completed the theme of the cat for
+	// the first time, for which uid gets reward. The effects of uid raise
+	// the points of the cat by boost, which returns bonus. It returns
+	// ErrConflict if uid traded the cat away.
+	Catch(uid uint64, catID uint64, t int64, reward Stack, boost Boost) (caught bool, completed bool, bonus int, err error)
+	// CaughtKinds returns every kind and whether uid caught one of it.
+	CaughtKinds(uid uint64) ([]CatKindCaught, error)
+	// Nearby returns at most limit cats of the theme, nearest to (lat, lng)
+	// first, and whether uid caught them or traded them away.
+	Nearby(uid uint64, themeID uint64, lat float64, lng float64, limit int) ([]Cat, error)
+	// History returns what uid caught, oldest first.
+	History(uid uint64) ([]Catch, error)
+	// Timeline returns what uid caught, newest first, with the kinds and
+	// the themes.
+	Timeline(uid uint64, f TimelineFilter) ([]CatchEntry, error)
+	// Progress returns how much of every theme with cats uid caught.
+	Progress(uid uint64) ([]ThemeProgress, error)
+	// Completions returns when uid first caught every cat of a theme.
+	Completions(uid uint64) ([]Completion, error)
+}
+
+// CatchEntry is a catch with the kind of the cat and the name of its theme.
+type CatchEntry struct {
+	Catch
+	ThemeName string
+	Kind      CatKind
+}
+
+// TimelineFilter selects catches, zero fields match everything. Catches are
+// returned newest first; BeforeTiming and BeforeCat are the timing and the
+// cat_id of the last catch of the previous page.
+type TimelineFilter struct {
+	ThemeID      uint64
+	CatKindID    uint64
+	Since        int64
+	Until        int64
+	BeforeTiming int64
+	BeforeCat    uint64
+	Limit        int
+}
+
+// ThemeProgress is how much of a theme a user caught.
+type ThemeProgress struct {
+	ThemeID    uint64
+	Cats       int // cats in the theme
+	Caught     int
+	Score      int       // weights of the caught cats
+	TotalScore int       // weights of all the cats
+	Completed  int64     // when it was first completed, 0 if never
+	Missing    []CatKind // kinds with no caught cat in the theme
+}
+
+// Completion is a row of theme_completion.
+type Completion struct {
+	ThemeID   uint64
+	Completed int64
+}
+
+type cats struct {
+	db *conn
+}
+
+func (r *cats) Get(catID uint64) (*Cat, error) {
+	cat := &Cat{}
+	err := r.db.QueryRow(`
+		SELECT cat.cat_id, cat.theme_id, cat.cat_kind_id, cat.lng, cat.lat,
+		       cat_kind.thumbnail, cat_kind.weight,
+		       cat_kind.description, cat_kind.name
+		FROM cat, cat_kind
+		WHERE cat.cat_id = ? and cat.cat_kind_id = cat_kind.cat_kind_id`, catID).Scan(
+		&cat.CatID, &cat.ThemeID, &cat.CatKindID, &cat.Lng, &cat.Lat, &cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return cat, err
+}
+
+func (r *cats) ThemeCats(uid uint64, themeID uint64) ([]Cat, error) {
+	list := []Cat{}
+
+	rows, err := r.db.Query(`
+		SELECT cat.cat_id, cat.cat_kind_id, cat.lng, cat.lat,
+		       cat_kind.thumbnail, cat_kind.weight,
+		       cat_kind.description, cat_kind.name,
+		       user_cat.user_id IS NOT NULL or traded_away.user_id IS NOT NULL
+		FROM cat
+		JOIN cat_kind ON cat.cat_kind_id = cat_kind.cat_kind_id
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		LEFT JOIN traded_away ON traded_away.cat_id = cat.cat_id and traded_away.user_id = ?
+		WHERE cat.theme_id = ?
+		ORDER BY cat.cat_id`, uid, uid, themeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cat := Cat{ThemeID: themeID}
+		if err := rows.Scan(&cat.CatID, &cat.CatKindID, &cat.Lng, &cat.Lat, &cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name, &cat.IsCaught); err != nil {
+			return nil, err
+		}
+		list = append(list, cat)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) Nearby(uid uint64, themeID uint64, lat float64, lng float64, limit int) ([]Cat, error) {
+	list := []Cat{}
+
+	distance, args := r.db.dialect.distance("cat.lat", "cat.lng", lat, lng)
+	args = append([]any{uid, uid, themeID}, args...)
+	rows, err := r.db.Query(`
+		SELECT cat.cat_id, cat.cat_kind_id, cat.lng, cat.lat,
+		       cat_kind.thumbnail, cat_kind.weight,
+		       cat_kind.description, cat_kind.name,
+		       user_cat.user_id IS NOT NULL or traded_away.user_id IS NOT NULL
+		FROM cat
+		JOIN cat_kind ON cat.cat_kind_id = cat_kind.cat_kind_id
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		LEFT JOIN traded_away ON traded_away.cat_id = cat.cat_id and traded_away.user_id = ?
+		WHERE cat.theme_id = ?
+		ORDER BY `+distance+`, cat.cat_id
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cat := Cat{ThemeID: themeID}
+		if err := rows.Scan(&cat.CatID, &cat.CatKindID, &cat.Lng, &cat.Lat, &cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name, &cat.IsCaught); err != nil {
+			return nil, err
+		}
+		list = append(list, cat)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) IsCaught(uid uint64, catID uint64) (bool, error) {
+	n, err := count(r.db, `
+		SELECT (SELECT COUNT(*) FROM user_cat WHERE user_id = ? and cat_id = ?) +
+		       (SELECT COUNT(*) FROM traded_away WHERE user_id = ? and cat_id = ?)`, uid, catID, uid, catID)
+	return n > 0, err
+}
+
+func (r *cats) Uncaught(uid uint64, themeIDs []uint64) ([]Cat, error) {
+	list := []Cat{}
+	if len(themeIDs) == 0 {
+		return list, nil
+	}
+
+	args := []any{uid, uid}
+	for _, id := range themeIDs {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query(`
+		SELECT cat.cat_id, cat.theme_id, cat.cat_kind_id, cat.lng, cat.lat,
+		       cat_kind.thumbnail, cat_kind.weight,
+		       cat_kind.description, cat_kind.name
+		FROM cat
+		JOIN cat_kind ON cat.cat_kind_id = cat_kind.cat_kind_id
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		LEFT JOIN traded_away ON traded_away.cat_id = cat.cat_id and traded_away.user_id = ?
+		WHERE user_cat.user_id IS NULL and traded_away.user_id IS NULL
+			and cat.theme_id IN (`+placeholders(len(themeIDs))+`)
+		ORDER BY cat.cat_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cat := Cat{}
+		if err := rows.Scan(&cat.CatID, &cat.ThemeID, &cat.CatKindID, &cat.Lng, &cat.Lat, &cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name); err != nil {
+			return nil, err
+		}
+		list = append(list, cat)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) Catch(uid uint64, catID uint64, t int64, reward Stack, boost Boost) (bool, bool, int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, false, 0, err
+	}
+	defer tx.Rollback()
+
+	// a cat traded away is not caught again for its points
+	if n, err := count(tx, "SELECT COUNT(*) FROM traded_away WHERE user_id = ? and cat_id = ?", uid, catID); err != nil {
+		return false, false, 0, err
+	} else if n > 0 {
+		return false, false, 0, ErrConflict
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO user_cat(user_id, cat_id, timing) values(?, ?, ?)
+		ON CONFLICT (user_id, cat_id) DO NOTHING`, uid, catID, t)
+	if err != nil {
+		return false, false, 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, false, 0, err
+	}
+
+	// keep user_stats in step with user_cat
+	if _, err := tx.Exec(`
+		INSERT INTO user_stats(user_id, cats, score)
+		SELECT ?, 1, cat_kind.weight
+		FROM cat, cat_kind
+		WHERE cat.cat_id = ? and cat.cat_kind_id = cat_kind.cat_kind_id
+		ON CONFLICT (user_id) DO UPDATE SET
+			cats = user_stats.cats + excluded.cats,
+			score = user_stats.score + excluded.score`, uid, catID); err != nil {
+		return false, false, 0, err
+	}
+
+	bonus, err := boostCatch(tx, uid, catID, t, boost)
+	if err != nil {
+		return false, false, 0, err
+	}
+
+	// the first time every cat of the theme is caught
+	themeID := uint64(0)
+	err = tx.QueryRow(`
+		INSERT INTO theme_completion(user_id, theme_id, completed)
+		SELECT ?, cat.theme_id, ?
+		FROM cat
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		WHERE cat.theme_id = (SELECT theme_id FROM cat WHERE cat_id = ?)
+		GROUP BY cat.theme_id
+		HAVING COUNT(user_cat.cat_id) = COUNT(cat.cat_id)
+		ON CONFLICT (user_id, theme_id) DO NOTHING
+		RETURNING theme_id`, uid, t, uid, catID).Scan(&themeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, false, bonus, tx.Commit()
+	} else if err != nil {
+		return false, false, 0, err
+	}
+	if err := grantItem(tx, uid, reward.ItemID, reward.Quantity, ItemFromThemeComplete, themeID, t); err != nil {
+		return false, false, 0, err
+	}
+	return true, true, bonus, tx.Commit()
+}
+
+func (r *cats) CaughtKinds(uid uint64) ([]CatKindCaught, error) {
+	list := []CatKindCaught{}
+
+	rows, err := r.db.Query(`SELECT
+		cat_kind.cat_kind_id,
+		cat_kind.name,
+		cat_kind.description,
+		cat_kind.weight,
+		cat_kind.thumbnail,
+		COUNT(user_cat.user_id)
+	FROM
+		cat_kind
+	JOIN
+		cat
+	on cat.cat_kind_id = cat_kind.cat_kind_id
+	LEFT JOIN
+		user_cat
+	on cat.cat_id = user_cat.cat_id and user_cat.user_id = ?
+	GROUP BY cat_kind.cat_kind_id
+	ORDER BY cat_kind.cat_kind_id ASC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		kind := CatKindCaught{}
+		var caught int
+		if err := rows.Scan(&kind.CatKindID, &kind.Name, &kind.Description, &kind.Weight, &kind.Thumbnail, &caught); err != nil {
+			return nil, err
+		}
+		kind.IsCaught = caught > 0
+		list = append(list, kind)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) History(uid uint64) ([]Catch, error) {
+	list := []Catch{}
+	rows, err := r.db.Query(`
+		SELECT user_cat.cat_id, cat.cat_kind_id, cat.theme_id, cat.lng, cat.lat, user_cat.timing
+		FROM user_cat, cat
+		WHERE user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		ORDER BY user_cat.timing ASC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := Catch{}
+		if err := rows.Scan(&c.CatID, &c.CatKindID, &c.ThemeID, &c.Lng, &c.Lat, &c.Timing); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) Progress(uid uint64) ([]ThemeProgress, error) {
+	list := []ThemeProgress{}
+	index := map[uint64]int{}
+
+	rows, err := r.db.Query(`
+		SELECT cat.theme_id,
+		       COUNT(cat.cat_id), COUNT(user_cat.cat_id),
+		       COALESCE(SUM(CASE WHEN user_cat.cat_id IS NULL THEN 0 ELSE cat_kind.weight END), 0),
+		       COALESCE(SUM(cat_kind.weight), 0),
+		       COALESCE(theme_completion.completed, 0)
+		FROM cat
+		JOIN cat_kind ON cat.cat_kind_id = cat_kind.cat_kind_id
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		LEFT JOIN theme_completion ON theme_completion.theme_id = cat.theme_id and theme_completion.user_id = ?
+		GROUP BY cat.theme_id, theme_completion.completed
+		ORDER BY cat.theme_id`, uid, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := ThemeProgress{Missing: []CatKind{}}
+		if err := rows.Scan(&p.ThemeID, &p.Cats, &p.Caught, &p.Score, &p.TotalScore, &p.Completed); err != nil {
+			return nil, err
+		}
+		index[p.ThemeID] = len(list)
+		list = append(list, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(`
+		SELECT cat.theme_id, cat_kind.cat_kind_id, cat_kind.weight,
+		       cat_kind.thumbnail, cat_kind.name, cat_kind.description
+		FROM cat
+		JOIN cat_kind ON cat.cat_kind_id = cat_kind.cat_kind_id
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		GROUP BY cat.theme_id, cat_kind.cat_kind_id, cat_kind.weight,
+		         cat_kind.thumbnail, cat_kind.name, cat_kind.description
+		HAVING COUNT(user_cat.cat_id) = 0
+		ORDER BY cat.theme_id, cat_kind.cat_kind_id`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var themeID uint64
+		kind := CatKind{}
+		if err := rows.Scan(&themeID, &kind.CatKindID, &kind.Weight, &kind.Thumbnail, &kind.Name, &kind.Description); err != nil {
+			return nil, err
+		}
+		if i, ok := index[themeID]; ok {
+			list[i].Missing = append(list[i].Missing, kind)
+		}
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) Completions(uid uint64) ([]Completion, error) {
+	list := []Completion{}
+	rows, err := r.db.Query(`
+		SELECT theme_id, completed FROM theme_completion
+		WHERE user_id = ? ORDER BY completed ASC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := Completion{}
+		if err := rows.Scan(&c.ThemeID, &c.Completed); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+func (r *cats) Timeline(uid uint64, f TimelineFilter) ([]CatchEntry, error) {
+	where := []string{"user_cat.user_id = ?"}
+	args := []any{uid}
+	if f.ThemeID != 0 {
+		where = append(where, "cat.theme_id = ?")
+		args = append(args, f.ThemeID)
+	}
+	if f.CatKindID != 0 {
+		where = append(where, "cat.cat_kind_id = ?")
+		args = append(args, f.CatKindID)
+	}
+	if f.Since != 0 {
+		where = append(where, "user_cat.timing >= ?")
+		args = append(args, f.Since)
+	}
+	if f.Until != 0 {
+		where = append(where, "user_cat.timing < ?")
+		args = append(args, f.Until)
+	}
+	if f.BeforeTiming != 0 {
+		where = append(where, "(user_cat.timing < ? or (user_cat.timing = ? and user_cat.cat_id < ?))")
+		args = append(args, f.BeforeTiming, f.BeforeTiming, f.BeforeCat)
+	}
+	args = append(args, f.Limit)
+
+	list := []CatchEntry{}
+	rows, err := r.db.Query(`
+		SELECT user_cat.cat_id, cat.theme_id, cat.lng, cat.lat, user_cat.timing,
+		       COALESCE(theme.name, ''),
+		       cat_kind.cat_kind_id, cat_kind.weight, cat_kind.thumbnail,
+		       cat_kind.name, cat_kind.description
+		FROM user_cat
+		JOIN cat ON cat.cat_id = user_cat.cat_id
+		JOIN cat_kind ON cat_kind.cat_kind_id = cat.cat_kind_id
+		LEFT JOIN theme ON theme.theme_id = cat.theme_id
+		WHERE `+strings.Join(where, " and ")+`
+		ORDER BY user_cat.timing DESC, user_cat.cat_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := CatchEntry{}
+		if err := rows.Scan(&e.CatID, &e.ThemeID, &e.Lng, &e.Lat, &e.Timing, &e.ThemeName,
+			&e.Kind.CatKindID, &e.Kind.Weight, &e.Kind.Thumbnail, &e.Kind.Name, &e.Kind.Description); err != nil {
+			return nil, err
+		}
+		e.CatKindID = e.Kind.CatKindID
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCatch(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	lure := Stack{ItemID: ItemLure, Quantity: 1}
+
+	// the steps run in order on the same store
+	steps := []struct {
+		name          string
+		uid           uint64
+		catID         uint64
+		wantCaught    bool
+		wantCompleted bool
+		wantStats     Stats
+	}{
+		{"first catch", alice, testCat1, true, false, Stats{Cats: 1, Score: 10}},
+		{"same cat again", alice, testCat1, false, false, Stats{Cats: 1, Score: 10}},
+		{"another user", bob, testCat1, true, false, Stats{Cats: 1, Score: 10}},
+		{"second cat", alice, testCat2, true, false, Stats{Cats: 2, Score: 30}},
+		{"last cat completes the theme", alice, testCat3, true, true, Stats{Cats: 3, Score: 60}},
+		{"caught after completing", alice, testCat3, false, false, Stats{Cats: 3, Score: 60}},
+	}
+	for _, step := range steps {
+		caught, completed, _, err := f.Cats.Catch(step.uid, step.catID, 100, lure, Boost{})
+		if err != nil {
+			t.Fatalf("%s: Catch: %v", step.name, err)
+		}
+		if caught != step.wantCaught || completed != step.wantCompleted {
+			t.Errorf("%s: Catch = (%v, %v), want (%v, %v)", step.name, caught, completed, step.wantCaught, step.wantCompleted)
+		}
+		if got := f.stats(step.uid); got != step.wantStats {
+			t.Errorf("%s: Stats = %+v, want %+v", step.name, got, step.wantStats)
+		}
+	}
+
+	if list, err := f.Cats.History(alice); err != nil {
+		t.Fatalf("History: %v", err)
+	} else if len(list) != 3 {
+		t.Errorf("History has %d catches, want 3", len(list))
+	}
+	if got := f.quantity(alice, ItemLure); got != 1 {
+		t.Errorf("lures of alice = %d, want 1 for completing the theme once", got)
+	}
+	if got := f.quantity(bob, ItemLure); got != 0 {
+		t.Errorf("lures of bob = %d, want 0", got)
+	}
+}
+
+func TestCatchBoost(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	if err := f.Items.Grant(alice, ItemLure, 2, ItemFromAdmin, 0, 1); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	for _, e := range []*Effect{
+		{CatID: testCat2, Starts: 1, Ends: 100},
+		{CatID: testCat3, Starts: 1, Ends: 10}, // over before the catch
+	} {
+		if err := f.Items.Use(alice, ItemLure, e, 0, 1); err != nil {
+			t.Fatalf("Use: %v", err)
+		}
+	}
+	// twice the weight of the cat for a lure on it
+	boost := func(catID uint64, weight int) Boost {
+		return Boost{
+			Points: func(e Effect) int {
+				if e.CatID == catID {
+					return 2 * weight
+				}
+				return 0
+			},
+			Audit: AuditEvent{Event: "item_bonus", UID: alice, RequestID: "catch"},
+		}
+	}
+
+	tests := []struct {
+		catID     uint64
+		weight    int
+		wantBonus int
+	}{
+		{testCat1, 10, 0},
+		{testCat2, 20, 40},
+		{testCat3, 30, 0},
+	}
+	score := 0
+	for _, tt := range tests {
+		_, _, bonus, err := f.Cats.Catch(alice, tt.catID, 50, Stack{}, boost(tt.catID, tt.weight))
+		if err != nil {
+			t.Fatalf("Catch %d: %v", tt.catID, err)
+		}
+		if bonus != tt.wantBonus {
+			t.Errorf("Catch %d: bonus = %d, want %d", tt.catID, bonus, tt.wantBonus)
+		}
+		score += tt.weight + tt.wantBonus
+	}
+	if got := f.stats(alice); got.Score != score {
+		t.Errorf("score = %d, want %d", got.Score, score)
+	}
+
+	// the bonus is recorded in the transaction of the catch
+	list, err := f.Audits.Query(AuditFilter{UID: alice, Event: "item_bonus", Limit: 10})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(list) != 1 || list[0].Timing != 50 || list[0].RequestID != "catch" {
+		t.Fatalf("audit = %+v, want one bonus of the catch", list)
+	}
+	detail := map[string]any{}
+	if err := json.Unmarshal(list[0].Detail, &detail); err != nil {
+		t.Fatalf("detail: %v", err)
+	}
+	if detail["cat_id"] != float64(testCat2) || detail["points"] != float64(40) || detail["item_id"] != ItemLure {
+		t.Errorf("detail = %v, want the lure on cat %d for 40 points", detail, testCat2)
+	}
+}
+
+func TestCatchTradedAway(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	f.befriend(alice, bob)
+	f.catch(alice, testCat1)
+
+	gift := &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+	if err := f.Trades.Create(gift); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := f.Trades.Accept(gift.TradeID, 2, Stack{}); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	if _, _, _, err := f.Cats.Catch(alice, testCat1, 3, Stack{}, Boost{}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Catch of a cat traded away: err = %v, want ErrConflict", err)
+	}
+	if caught, err := f.Cats.IsCaught(alice, testCat1); err != nil || !caught {
+		t.Errorf("IsCaught of a cat traded away = (%v, %v), want (true, nil)", caught, err)
+	}
+	if got := f.stats(alice); got != (Stats{}) {
+		t.Errorf("Stats of alice = %+v, want none", got)
+	}
+
+	// the theme shows the cat as caught to both of them
+	for _, uid := range []uint64{alice, bob} {
+		list, err := f.Cats.ThemeCats(uid, testTheme)
+		if err != nil {
+			t.Fatalf("ThemeCats: %v", err)
+		}
+		for _, cat := range list {
+			if cat.IsCaught != (cat.CatID == testCat1) {
+				t.Errorf("ThemeCats of %d: cat %d IsCaught = %v", uid, cat.CatID, cat.IsCaught)
+			}
+		}
+	}
+}
+
+func TestNearby(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	const theme = 2
+	if err := f.Themes.Save(Theme{ThemeID: theme, Name: "north"}); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	// at 60°N a degree of longitude is half as long as a degree of latitude
+	cats := []Cat{
+		{CatID: 10, Lat: 60.6, Lng: 10}, // 67 km
+		{CatID: 11, Lat: 60, Lng: 11},   // 56 km
+		{CatID: 12, Lat: 60.1, Lng: 10}, // 11 km
+		{CatID: 13, Lat: 59, Lng: 10},   // 111 km
+	}
+	for _, cat := range cats {
+		cat.ThemeID, cat.CatKindID = theme, 1
+		if err := f.Themes.AddCat(cat); err != nil {
+			t.Fatalf("AddCat: %v", err)
+		}
+	}
+	f.catch(alice, 11)
+
+	list, err := f.Cats.Nearby(alice, theme, 60, 10, 3)
+	if err != nil {
+		t.Fatalf("Nearby: %v", err)
+	}
+	got := []uint64{}
+	for _, cat := range list {
+		got = append(got, cat.CatID)
+		if cat.IsCaught != (cat.CatID == 11) {
+			t.Errorf("cat %d IsCaught = %v", cat.CatID, cat.IsCaught)
+		}
+	}
+	if want := []uint64{12, 11, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Nearby = %v, want %v", got, want)
+	}
+}
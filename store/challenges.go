@@ -0,0 +1,390 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// challenge goals, what the participants compete for
+const (
+	GoalCount  = "count"  // the most cats caught
+	GoalWeight = "weight" // the highest sum of weights
+)
+
+// challenge status
+const (
+	ChallengeOpen     = "open"
+	ChallengeFinished = "finished"
+	ChallengeExpired  = "expired" // ended with fewer than two participants
+)
+
+// participant status
+const (
+	ParticipantInvited  = "invited"
+	ParticipantAccepted = "accepted"
+	ParticipantDeclined = "declined"
+)
+
+// Challenge is a row of challenge with its participants. The participants
+// compete for Goal with the cats of the theme caught in [Starts, Ends).
+type Challenge struct {
+	ChallengeID  uint64        `json:"challenge_id"`
+	Creator      uint64        `json:"creator_uid"`
+	ThemeID      uint64        `json:"theme_id"`
+	Goal         string        `json:"goal"`
+	Starts       int64         `json:"starts"`
+	Ends         int64         `json:"ends"`
+	Status       string        `json:"status"`
+	Participants []Participant `json:"participants"`
+}
+
+// Participant is a row of challenge_participant. Score and Place are kept
+// once the challenge is finished; Place is 0 for those who did not accept,
+// and tied participants share a place.
+type Participant struct {
+	UID     uint64 `json:"uid"`
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+	Status  string `json:"status"`
+	Score   int    `json:"score"`
+	Place   int    `json:"place"`
+}
+
+type ChallengeFilter struct {
+	UID    uint64
+	Status string // "" for every status
+	Before uint64
+	Limit  int
+}
+
+type Challenges interface {
+	// Create inserts ch with the creator accepted and the other participants
+	// invited, only their UID is used, and sets ChallengeID.
+	Create(ch *Challenge) error
+	// Get returns the challenge with the standings at now if it is open, or
+	// the final result. It returns ErrNotFound if there is no such challenge.
+	Get(challengeID uint64, now int64) (*Challenge, error)
+	// List returns the challenges uid takes part in or is invited to, newest
+	// first, with the final result of the finished ones.
+	List(f ChallengeFilter) ([]Challenge, error)
+	// Invitations returns the open challenges inviting uid.
+	Invitations(uid uint64, now int64) ([]Challenge, error)
+	// Respond accepts or declines the invitation. It returns ErrNotFound if
+	// uid is not invited or the challenge has ended.
+	Respond(challengeID uint64, uid uint64, accept bool, now int64) error
+
+	// Due returns the open challenges which have ended.
+	Due(now int64, limit int) ([]uint64, error)
+	// Finish records the result of an ended challenge. It returns false if
+	// the challenge is not open or has not ended, e.g. finished by another
+	// caller.
+	Finish(challengeID uint64, now int64) (bool, error)
+}
+
+type challenges struct {
+	db *conn
+}
+
+func (r *challenges) Create(ch *Challenge) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+		INSERT INTO challenge(user_id, theme_id, goal, starts, ends, status)
+		values(?, ?, ?, ?, ?, ?) RETURNING challenge_id`,
+		ch.Creator, ch.ThemeID, ch.Goal, ch.Starts, ch.Ends, ChallengeOpen).Scan(&ch.ChallengeID); err != nil {
+		return err
+	}
+	ch.Status = ChallengeOpen
+	for i, p := range ch.Participants {
+		status := ParticipantInvited
+		if p.UID == ch.Creator {
+			status = ParticipantAccepted
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO challenge_participant(challenge_id, user_id, status, score, place)
+			values(?, ?, ?, 0, 0)`, ch.ChallengeID, p.UID, status); err != nil {
+			return err
+		}
+		ch.Participants[i].Status = status
+	}
+	return tx.Commit()
+}
+
+func (r *challenges) Get(challengeID uint64, now int64) (*Challenge, error) {
+	list, err := r.query("challenge_id = ?", []any{challengeID}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	ch := &list[0]
+	if ch.Status == ChallengeOpen {
+		if ch.Participants, err = standings(r.db, ch, now); err != nil {
+			return nil, err
+		}
+	}
+	return ch, nil
+}
+
+func (r *challenges) List(f ChallengeFilter) ([]Challenge, error) {
+	where := []string{`challenge_id IN (
+		SELECT challenge_id FROM challenge_participant WHERE user_id = ? and status <> ?
+	)`}
+	args := []any{f.UID, ParticipantDeclined}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Before != 0 {
+		where = append(where, "challenge_id < ?")
+		args = append(args, f.Before)
+	}
+	return r.query(strings.Join(where, " and "), args, f.Limit)
+}
+
+func (r *challenges) Invitations(uid uint64, now int64) ([]Challenge, error) {
+	return r.query(`status = ? and ends > ? and challenge_id IN (
+		SELECT challenge_id FROM challenge_participant WHERE user_id = ? and status = ?
+	)`, []any{ChallengeOpen, now, uid, ParticipantInvited}, 100)
+}
+
+// query returns the challenges matching where with their participants, in
+// two queries.
+func (r *challenges) query(where string, args []any, limit int) ([]Challenge, error) {
+	list := []Challenge{}
+	index := map[uint64]int{}
+
+	rows, err := r.db.Query(`
+		SELECT challenge_id, user_id, theme_id, goal, starts, ends, status
+		FROM challenge
+		WHERE `+where+`
+		ORDER BY challenge_id DESC
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ch := Challenge{Participants: []Participant{}}
+		if err := rows.Scan(&ch.ChallengeID, &ch.Creator, &ch.ThemeID, &ch.Goal, &ch.Starts, &ch.Ends, &ch.Status); err != nil {
+			return nil, err
+		}
+		index[ch.ChallengeID] = len(list)
+		list = append(list, ch)
+	}
+	if err := rows.Err(); err != nil || len(list) == 0 {
+		return list, err
+	}
+
+	ids := make([]any, 0, len(list))
+	for _, ch := range list {
+		ids = append(ids, ch.ChallengeID)
+	}
+	prows, err := r.db.Query(`
+		SELECT challenge_participant.challenge_id, challenge_participant.user_id,
+		       "user".name, "user".profile, challenge_participant.status,
+		       challenge_participant.score, challenge_participant.place
+		FROM challenge_participant
+		JOIN "user" ON "user".user_id = challenge_participant.user_id
+		WHERE challenge_participant.challenge_id IN (`+placeholders(len(ids))+`)
+		ORDER BY challenge_participant.place = 0, challenge_participant.place, challenge_participant.user_id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer prows.Close()
+	for prows.Next() {
+		var challengeID uint64
+		p := Participant{}
+		if err := prows.Scan(&challengeID, &p.UID, &p.Name, &p.Profile, &p.Status, &p.Score, &p.Place); err != nil {
+			return nil, err
+		}
+		ch := &list[index[challengeID]]
+		ch.Participants = append(ch.Participants, p)
+	}
+	return list, prows.Err()
+}
+
+// standings scores the accepted participants of ch with the cats caught in
+// the theme from ch.Starts until now or ch.Ends, whichever is earlier. Cats
+// received by a trade are not counted, they keep the time of the trade in
+// user_cat.timing.
+func standings(q querier, ch *Challenge, now int64) ([]Participant, error) {
+	until := ch.Ends
+	if now < until {
+		until = now + 1
+	}
+	rows, err := q.Query(`
+		SELECT challenge_participant.user_id, "user".name, "user".profile,
+		       challenge_participant.status, COUNT(tb.cat_id), COALESCE(SUM(tb.weight), 0)
+		FROM challenge_participant
+		JOIN "user" ON "user".user_id = challenge_participant.user_id
+		LEFT JOIN
+			(
+				SELECT user_cat.user_id, user_cat.cat_id, cat_kind.weight
+				FROM user_cat
+				JOIN cat ON cat.cat_id = user_cat.cat_id
+				JOIN cat_kind ON cat_kind.cat_kind_id = cat.cat_kind_id
+				WHERE
+					cat.theme_id = ? and
+					user_cat.timing >= ? and user_cat.timing < ? and
+					NOT EXISTS (
+						SELECT 1 FROM trade_cat
+						JOIN trade ON trade.trade_id = trade_cat.trade_id
+						WHERE
+							trade_cat.cat_id = user_cat.cat_id and
+							trade_cat.giver <> user_cat.user_id and
+							trade.status = ? and
+							trade.updating = user_cat.timing
+					)
+			) as tb
+			ON tb.user_id = challenge_participant.user_id and challenge_participant.status = ?
+		WHERE challenge_participant.challenge_id = ?
+		GROUP BY challenge_participant.user_id, "user".name, "user".profile, challenge_participant.status`,
+		ch.ThemeID, ch.Starts, until, TradeAccepted, ParticipantAccepted, ch.ChallengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Participant{}
+	for rows.Next() {
+		p := Participant{}
+		var caught, weight int
+		if err := rows.Scan(&p.UID, &p.Name, &p.Profile, &p.Status, &caught, &weight); err != nil {
+			return nil, err
+		}
+		if ch.Goal == GoalWeight {
+			p.Score = weight
+		} else {
+			p.Score = caught
+		}
+		list = append(list, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.Status == ParticipantAccepted) != (b.Status == ParticipantAccepted) {
+			return a.Status == ParticipantAccepted
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.UID < b.UID
+	})
+	for i := range list {
+		if list[i].Status != ParticipantAccepted {
+			list[i].Score = 0
+			continue
+		}
+		if i > 0 && list[i].Score == list[i-1].Score {
+			list[i].Place = list[i-1].Place
+		} else {
+			list[i].Place = i + 1
+		}
+	}
+	return list, nil
+}
+
+func (r *challenges) Respond(challengeID uint64, uid uint64, accept bool, now int64) error {
+	status := ParticipantDeclined
+	if accept {
+		status = ParticipantAccepted
+	}
+	res, err := r.db.Exec(`
+		UPDATE challenge_participant SET status = ?
+		WHERE challenge_id = ? and user_id = ? and status = ? and challenge_id IN (
+			SELECT challenge_id FROM challenge WHERE status = ? and ends > ?
+		)`, status, challengeID, uid, ParticipantInvited, ChallengeOpen, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *challenges) Due(now int64, limit int) ([]uint64, error) {
+	list := []uint64{}
+	rows, err := r.db.Query(`
+		SELECT challenge_id FROM challenge
+		WHERE status = ? and ends <= ?
+		ORDER BY ends
+		LIMIT ?`, ChallengeOpen, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		list = append(list, id)
+	}
+	return list, rows.Err()
+}
+
+func (r *challenges) Finish(challengeID uint64, now int64) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	ch := &Challenge{}
+	err = tx.QueryRow(`
+		SELECT challenge_id, theme_id, goal, starts, ends FROM challenge
+		WHERE challenge_id = ? and status = ? and ends <= ?`, challengeID, ChallengeOpen, now).
+		Scan(&ch.ChallengeID, &ch.ThemeID, &ch.Goal, &ch.Starts, &ch.Ends)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	list, err := standings(tx, ch, now)
+	if err != nil {
+		return false, err
+	}
+
+	accepted := 0
+	for _, p := range list {
+		if p.Status != ParticipantAccepted {
+			continue
+		}
+		accepted++
+		if _, err := tx.Exec(`
+			UPDATE challenge_participant SET score = ?, place = ?
+			WHERE challenge_id = ? and user_id = ?`, p.Score, p.Place, challengeID, p.UID); err != nil {
+			return false, err
+		}
+	}
+	status := ChallengeFinished
+	if accepted < 2 {
+		status = ChallengeExpired
+	}
+	// the status guards against finishing twice
+	res, err := tx.Exec("UPDATE challenge SET status = ? WHERE challenge_id = ? and status = ?",
+		status, challengeID, ChallengeOpen)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestChallengeFinish(t *testing.T) {
+	tests := []struct {
+		name       string
+		goal       string
+		wantStatus string
+		// participant name -> score and place
+		want map[string][2]int
+	}{
+		{"count", GoalCount, ChallengeFinished,
+			map[string][2]int{"alice": {2, 1}, "bob": {1, 2}, "carol": {1, 2}, "dave": {0, 0}}},
+		{"weight", GoalWeight, ChallengeFinished,
+			map[string][2]int{"alice": {30, 1}, "bob": {30, 1}, "carol": {20, 3}, "dave": {0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			users := map[uint64]string{}
+			uid := map[string]uint64{}
+			for _, name := range []string{"alice", "bob", "carol", "dave"} {
+				uid[name] = f.newUser(name)
+				users[uid[name]] = name
+			}
+			ch := &Challenge{Creator: uid["alice"], ThemeID: testTheme, Goal: tt.goal, Starts: 100, Ends: 200,
+				Participants: []Participant{{UID: uid["alice"]}, {UID: uid["bob"]}, {UID: uid["carol"]}, {UID: uid["dave"]}}}
+			if err := f.Challenges.Create(ch); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			for name, accept := range map[string]bool{"bob": true, "carol": true, "dave": false} {
+				if err := f.Challenges.Respond(ch.ChallengeID, uid[name], accept, 100); err != nil {
+					t.Fatalf("Respond of %s: %v", name, err)
+				}
+			}
+
+			catches := []struct {
+				name   string
+				catID  uint64
+				timing int64
+			}{
+				{"alice", testCat1, 150},
+				{"alice", testCat2, 199},
+				{"bob", testCat1, 99}, // before the challenge
+				{"bob", testCat3, 150},
+				{"carol", testCat2, 100},
+				{"carol", testCat3, 200}, // after the challenge
+				{"dave", testCat1, 150},  // declined
+				{"dave", testCat2, 150},
+			}
+			for _, c := range catches {
+				if _, _, _, err := f.Cats.Catch(uid[c.name], c.catID, c.timing, Stack{}, Boost{}); err != nil {
+					t.Fatalf("Catch: %v", err)
+				}
+			}
+			// a cat received by a trade does not count
+			f.befriend(uid["dave"], uid["bob"])
+			gift := &Trade{Src: uid["dave"], Dest: uid["bob"], Give: []Cat{{CatID: testCat2}}}
+			if err := f.Trades.Create(gift); err != nil {
+				t.Fatalf("Create trade: %v", err)
+			}
+			if _, err := f.Trades.Accept(gift.TradeID, 160, Stack{}); err != nil {
+				t.Fatalf("Accept trade: %v", err)
+			}
+
+			if ok, err := f.Challenges.Finish(ch.ChallengeID, 199); err != nil || ok {
+				t.Errorf("Finish before the end = (%v, %v), want (false, nil)", ok, err)
+			}
+			if ok, err := f.Challenges.Finish(ch.ChallengeID, 200); err != nil || !ok {
+				t.Fatalf("Finish = (%v, %v), want (true, nil)", ok, err)
+			}
+			if ok, err := f.Challenges.Finish(ch.ChallengeID, 300); err != nil || ok {
+				t.Errorf("Finish again = (%v, %v), want (false, nil)", ok, err)
+			}
+
+			got, err := f.Challenges.Get(ch.ChallengeID, 300)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			places := map[string][2]int{}
+			for _, p := range got.Participants {
+				places[users[p.UID]] = [2]int{p.Score, p.Place}
+			}
+			if !reflect.DeepEqual(places, tt.want) {
+				t.Errorf("results = %v, want %v", places, tt.want)
+			}
+		})
+	}
+}
+
+func TestChallengeExpired(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	ch := &Challenge{Creator: alice, ThemeID: testTheme, Goal: GoalCount, Starts: 100, Ends: 200,
+		Participants: []Participant{{UID: alice}, {UID: bob}}}
+	if err := f.Challenges.Create(ch); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.Challenges.Respond(ch.ChallengeID, bob, true, 200); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Respond after the end: err = %v, want ErrNotFound", err)
+	}
+
+	if due, err := f.Challenges.Due(200, 10); err != nil || !reflect.DeepEqual(due, []uint64{ch.ChallengeID}) {
+		t.Errorf("Due = (%v, %v), want the challenge", due, err)
+	}
+	if ok, err := f.Challenges.Finish(ch.ChallengeID, 200); err != nil || !ok {
+		t.Fatalf("Finish = (%v, %v), want (true, nil)", ok, err)
+	}
+	if got, err := f.Challenges.Get(ch.ChallengeID, 200); err != nil {
+		t.Fatalf("Get: %v", err)
+	} else if got.Status != ChallengeExpired {
+		t.Errorf("status = %s, want %s with one participant", got.Status, ChallengeExpired)
+	}
+	if due, err := f.Challenges.Due(200, 10); err != nil || len(due) != 0 {
+		t.Errorf("Due = (%v, %v), want none", due, err)
+	}
+}
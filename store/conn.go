@@ -0,0 +1,62 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/metrics"
+)
+
+var queryDuration = metrics.NewHistogram("catch_cat_db_query_duration_seconds",
+	"Latency of database statements by operation.", metrics.DefBuckets, "op")
+
+// conn is a database handle which rebinds the queries for its dialect and
+// times them. QueryRow is timed until the row is fetched, not scanned.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	defer queryDuration.Since(time.Now(), "exec")
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*sql.Rows, error) {
+	defer queryDuration.Since(time.Now(), "query")
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...any) *sql.Row {
+	defer queryDuration.Since(time.Now(), "query")
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*tx, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &tx{t, c.dialect}, nil
+}
+
+// tx is a transaction of conn.
+type tx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t *tx) Exec(query string, args ...any) (sql.Result, error) {
+	defer queryDuration.Since(time.Now(), "exec")
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) Query(query string, args ...any) (*sql.Rows, error) {
+	defer queryDuration.Since(time.Now(), "query")
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *tx) QueryRow(query string, args ...any) *sql.Row {
+	defer queryDuration.Since(time.Now(), "query")
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// dialect hides what differs between the databases. Queries and migrations
+// are written once in the common subset of SQLite and PostgreSQL with ?
+// placeholders; the dialect rewrites what can not be shared. Upserts are
+// INSERT ... ON CONFLICT and inserts return ids with RETURNING, which both
+// databases support since the versions that check requires.
+type dialect interface {
+	// rebind rewrites the ? placeholders of query.
+	rebind(query string) string
+	// ddl translates the column types of a migration.
+	ddl(stmt string) string
+	// distance returns an expression of the distance from (lat, lng) to the
+	// columns latCol and lngCol and its arguments, to order rows by.
+	distance(latCol string, lngCol string, lat float64, lng float64) (string, []any)
+	// check returns an error if the server lacks a feature the queries use.
+	check(db *sql.DB) error
+}
+
+var dialects = map[string]dialect{
+	"sqlite3":  sqlite{},
+	"postgres": postgres{},
+}
+
+type sqlite struct{}
+
+func (sqlite) rebind(query string) string {
+	return query
+}
+
+func (sqlite) ddl(stmt string) string {
+	return stmt
+}
+
+// distance is an equirectangular approximation, good enough to order cats in
+// a city. The cosine is computed here as SQLite has no math functions by
+// default, so the expression is the square of the distance in degrees.
+func (sqlite) distance(latCol string, lngCol string, lat float64, lng float64) (string, []any) {
+	k := math.Cos(lat * math.Pi / 180)
+	return fmt.Sprintf("(%[1]s - ?) * (%[1]s - ?) + (%[2]s - ?) * (%[2]s - ?) * ?", latCol, lngCol),
+		[]any{lat, lat, lng, lng, k * k}
+}
+
+// check requires SQLite 3.35 for RETURNING, upserts are there since 3.24.
+func (sqlite) check(db *sql.DB) error {
+	var version string
+	if err := db.QueryRow("SELECT sqlite_version()").Scan(&version); err != nil {
+		return err
+	}
+	var major, minor int
+	fmt.Sscanf(version, "%d.%d", &major, &minor)
+	if major < 3 || major == 3 && minor < 35 {
+		return fmt.Errorf("store: SQLite %s is too old, 3.35 or later is needed", version)
+	}
+	return nil
+}
+
+type postgres struct{}
+
+// rebind replaces the ? outside string literals with $1, $2, ...
+func (postgres) rebind(query string) string {
+	b := strings.Builder{}
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var postgresTypes = strings.NewReplacer(
+	"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+	"INTEGER", "BIGINT",
+	"REAL", "DOUBLE PRECISION",
+)
+
+func (postgres) ddl(stmt string) string {
+	return postgresTypes.Replace(stmt)
+}
+
+// distance is the great-circle distance in meters by the haversine formula,
+// which needs no extension such as PostGIS or earthdistance.
+func (postgres) distance(latCol string, lngCol string, lat float64, lng float64) (string, []any) {
+	return fmt.Sprintf(`%d * 2 * ASIN(LEAST(1, SQRT(
+		POWER(SIN(RADIANS(%[2]s - ?) / 2), 2) +
+		COS(RADIANS(?)) * COS(RADIANS(%[2]s)) * POWER(SIN(RADIANS(%[3]s - ?) / 2), 2))))`,
+		earthRadius, latCol, lngCol), []any{lat, lat, lng}
+}
+
+// check requires PostgreSQL 9.5 for upserts.
+func (postgres) check(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("SELECT current_setting('server_version_num')::int").Scan(&version); err != nil {
+		return err
+	}
+	if version < 90500 {
+		return fmt.Errorf("store: PostgreSQL %d is too old, 9.5 or later is needed", version)
+	}
+	return nil
+}
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371000
@@ -0,0 +1,313 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type Friend struct {
+	Name       string  `json:"name"`
+	Uid        uint64  `json:"uid"`
+	Profile    string  `json:"profile"`
+	Level      int     `json:"level"`
+	Score      int     `json:"score"`
+	Cats       int     `json:"cats"`
+	LastLogin  int     `json:"last_login"`
+	ThemeScore int     `json:"theme_score"` // optional
+	ThemeCats  int     `json:"theme_cats"`  // optional
+	Lat        float64 `json:"lat"`         // optional
+	Lng        float64 `json:"lng"`         // optional
+}
+
+// Relation is a row of friend. src invites dest; accepted tells whether dest
+// accepted, and ban means src banned dest.
+type Relation struct {
+	Src      uint64
+	Dest     uint64
+	Accepted bool
+	Ban      bool
+}
+
+type Friends interface {
+	Get(src uint64, dest uint64) (*Relation, error)
+	// Relations returns the relations from or to uid.
+	Relations(uid uint64) ([]Relation, error)
+	Insert(r Relation) error
+	// Accept accepts the invitation from friendUID to uid and adds the
+	// relation of the other direction.
+	Accept(uid uint64, friendUID uint64) error
+	// Decline deletes the pending invitation from friendUID to uid.
+	Decline(uid uint64, friendUID uint64) error
+	// Delete deletes uid -> friendUID, and friendUID -> uid unless it is a ban.
+	Delete(uid uint64, friendUID uint64) error
+
+	// AreFriends reports whether uid and friendUID accepted each other and
+	// neither bans the other.
+	AreFriends(uid uint64, friendUID uint64) (bool, error)
+
+	// List returns the accepted friends of uid.
+	List(uid uint64) ([]Friend, error)
+	// InvitingMe returns the users who are inviting uid.
+	InvitingMe(uid uint64) ([]Friend, error)
+	// Positions returns the friends of uid with their position and score
+	// in the theme.
+	Positions(uid uint64, themeID int) ([]Friend, error)
+	// ThemeRank returns uid and the friends of uid ordered by the score in
+	// the theme.
+	ThemeRank(uid uint64, themeID int) ([]Friend, error)
+}
+
+type friends struct {
+	db *conn
+}
+
+func (r *friends) Get(src uint64, dest uint64) (*Relation, error) {
+	rel := &Relation{}
+	err := r.db.QueryRow(`
+		SELECT user_id_src, user_id_dest, accepted, ban
+		FROM friend WHERE user_id_src = ? and user_id_dest = ?`, src, dest).Scan(&rel.Src, &rel.Dest, &rel.Accepted, &rel.Ban)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return rel, err
+}
+
+func (r *friends) AreFriends(uid uint64, friendUID uint64) (bool, error) {
+	n, err := count(r.db, `
+		SELECT COUNT(*) FROM friend
+		WHERE ((user_id_src = ? and user_id_dest = ?) or (user_id_src = ? and user_id_dest = ?))
+			and accepted = TRUE and ban = FALSE`, uid, friendUID, friendUID, uid)
+	return n == 2, err
+}
+
+func (r *friends) Relations(uid uint64) ([]Relation, error) {
+	list := []Relation{}
+	rows, err := r.db.Query(`
+		SELECT user_id_src, user_id_dest, accepted, ban
+		FROM friend
+		WHERE user_id_src = ? or user_id_dest = ?`, uid, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rel := Relation{}
+		if err := rows.Scan(&rel.Src, &rel.Dest, &rel.Accepted, &rel.Ban); err != nil {
+			return nil, err
+		}
+		list = append(list, rel)
+	}
+	return list, rows.Err()
+}
+
+func (r *friends) Insert(rel Relation) error {
+	_, err := r.db.Exec("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)", rel.Src, rel.Dest, rel.Accepted, rel.Ban)
+	return err
+}
+
+func (r *friends) Accept(uid uint64, friendUID uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// insert uid -> friend_uid
+	if _, err := tx.Exec("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)", uid, friendUID, true, false); err != nil {
+		return err
+	}
+	// accept friend_uid -> uid
+	if _, err := tx.Exec("UPDATE friend SET accepted = TRUE WHERE user_id_src = ? and user_id_dest = ?", friendUID, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *friends) Decline(uid uint64, friendUID uint64) error {
+	_, err := r.db.Exec("DELETE FROM friend WHERE user_id_src = ? and user_id_dest = ? and accepted = FALSE", friendUID, uid)
+	return err
+}
+
+func (r *friends) Delete(uid uint64, friendUID uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// delete uid -> friend_uid
+	if _, err := tx.Exec("DELETE FROM friend WHERE user_id_src = ? and user_id_dest = ?", uid, friendUID); err != nil {
+		return err
+	}
+	// delete friend_uid -> uid if ban = 0
+	if _, err := tx.Exec("DELETE FROM friend WHERE user_id_src = ? and user_id_dest = ? and ban = FALSE", friendUID, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *friends) List(uid uint64) ([]Friend, error) {
+	rows, err := r.db.Query(`
+		SELECT
+			friend.user_id_dest as fid,
+			"user".name,
+			"user".profile,
+			"user".last_login,
+			COALESCE(user_stats.cats, 0),
+			COALESCE(user_stats.score, 0)
+		FROM friend
+		JOIN "user" ON "user".user_id = friend.user_id_dest
+		LEFT JOIN user_stats ON user_stats.user_id = friend.user_id_dest
+		WHERE
+			friend.accepted = TRUE and
+			friend.ban = FALSE and
+			friend.user_id_src = ?
+	`, uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows, func(f *Friend) []any {
+		return []any{&f.Uid, &f.Name, &f.Profile, &f.LastLogin, &f.Cats, &f.Score}
+	})
+}
+
+func (r *friends) InvitingMe(uid uint64) ([]Friend, error) {
+	rows, err := r.db.Query(`
+		SELECT
+			friend.user_id_src as fid,
+			"user".name,
+			"user".profile,
+			"user".last_login,
+			COALESCE(user_stats.cats, 0),
+			COALESCE(user_stats.score, 0)
+		FROM friend
+		JOIN "user" ON "user".user_id = friend.user_id_src
+		LEFT JOIN user_stats ON user_stats.user_id = friend.user_id_src
+		WHERE
+			friend.accepted = FALSE and
+			friend.ban = FALSE and
+			friend.user_id_dest = ?
+	`, uid)
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows, func(f *Friend) []any {
+		return []any{&f.Uid, &f.Name, &f.Profile, &f.LastLogin, &f.Cats, &f.Score}
+	})
+}
+
+func (r *friends) Positions(uid uint64, themeID int) ([]Friend, error) {
+	rows, err := r.db.Query(`
+		SELECT
+			ta.user_id, ta.name, ta.profile, ta.last_login, ta.last_lat, ta.last_lng,
+			ta.total_cats, ta.total_score,
+			COALESCE(SUM(tb.weight), 0) as score,
+			COUNT(tb.cat_id) as cats
+		FROM
+			(
+				SELECT
+					"user".user_id,
+					"user".name,
+					"user".profile,
+					"user".last_login,
+					"user".last_lat,
+					"user".last_lng,
+					COALESCE(user_stats.cats, 0) as total_cats,
+					COALESCE(user_stats.score, 0) as total_score
+				FROM friend
+				JOIN "user" ON "user".user_id = friend.user_id_dest
+				LEFT JOIN user_stats ON user_stats.user_id = friend.user_id_dest
+				WHERE
+					friend.user_id_src = ? and
+					friend.accepted = TRUE AND
+					friend.ban = FALSE
+			) as ta
+		LEFT JOIN
+			(
+				SELECT user_cat.user_id, user_cat.cat_id, cat_kind.weight
+				FROM user_cat, cat, cat_kind
+				WHERE
+					user_cat.cat_id = cat.cat_id and
+					cat.cat_kind_id = cat_kind.cat_kind_id and
+					cat.theme_id = ?
+			) as tb
+			ON
+				tb.user_id = ta.user_id
+		GROUP BY ta.user_id, ta.name, ta.profile, ta.last_login, ta.last_lat, ta.last_lng, ta.total_cats, ta.total_score
+		ORDER BY score DESC`, uid, themeID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows, func(f *Friend) []any {
+		return []any{&f.Uid, &f.Name, &f.Profile, &f.LastLogin, &f.Lat, &f.Lng, &f.Cats, &f.Score, &f.ThemeScore, &f.ThemeCats}
+	})
+}
+
+func (r *friends) ThemeRank(uid uint64, themeID int) ([]Friend, error) {
+	rows, err := r.db.Query(`
+		SELECT
+			ta.user_id, ta.name, ta.profile, ta.last_login,
+			COALESCE(user_stats.cats, 0), COALESCE(user_stats.score, 0),
+			COALESCE(SUM(tb.weight), 0) as score,
+			COUNT(tb.cat_id) as cats
+		FROM
+			(
+				SELECT
+					"user".user_id,
+					"user".name,
+					"user".profile,
+					"user".last_login
+				FROM "user", friend
+				WHERE
+					(
+						friend.user_id_src = ? and
+						friend.user_id_dest = "user".user_id and
+						friend.accepted = TRUE AND
+						friend.ban = FALSE
+					) UNION
+				SELECT
+					"user".user_id,
+					"user".name,
+					"user".profile,
+					"user".last_login
+				FROM "user"
+				WHERE "user".user_id = ?
+			) as ta
+		LEFT JOIN user_stats ON user_stats.user_id = ta.user_id
+		LEFT JOIN
+			(
+				SELECT user_cat.user_id, user_cat.cat_id, cat_kind.weight
+				FROM user_cat, cat, cat_kind
+				WHERE
+					user_cat.cat_id = cat.cat_id and
+					cat.cat_kind_id = cat_kind.cat_kind_id and
+					cat.theme_id = ?
+			) as tb
+			ON
+				tb.user_id = ta.user_id
+		GROUP BY ta.user_id, ta.name, ta.profile, ta.last_login, user_stats.cats, user_stats.score
+		ORDER BY score DESC`, uid, uid, themeID)
+	if err != nil {
+		return nil, err
+	}
+	return scanFriends(rows, func(f *Friend) []any {
+		return []any{&f.Uid, &f.Name, &f.Profile, &f.LastLogin, &f.Cats, &f.Score, &f.ThemeScore, &f.ThemeCats}
+	})
+}
+
+// scanFriends reads the friends from rows, dest tells the columns of a row.
+// The level is derived from the score.
+func scanFriends(rows *sql.Rows, dest func(f *Friend) []any) ([]Friend, error) {
+	defer rows.Close()
+
+	list := []Friend{}
+	for rows.Next() {
+		friend := Friend{}
+		if err := rows.Scan(dest(&friend)...); err != nil {
+			return nil, err
+		}
+		friend.Level = Stats{Cats: friend.Cats, Score: friend.Score}.Level()
+		list = append(list, friend)
+	}
+	return list, rows.Err()
+}
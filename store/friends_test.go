@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFriends(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	carol := f.newUser("carol")
+
+	// uids returns the uids of list
+	uids := func(list []Friend, err error) []uint64 {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		ids := []uint64{}
+		for _, friend := range list {
+			ids = append(ids, friend.Uid)
+		}
+		return ids
+	}
+	check := func(step string, a uint64, b uint64, want bool) {
+		t.Helper()
+		if got, err := f.Friends.AreFriends(a, b); err != nil || got != want {
+			t.Errorf("%s: AreFriends = (%v, %v), want %v", step, got, err, want)
+		}
+		if got, err := f.Friends.AreFriends(b, a); err != nil || got != want {
+			t.Errorf("%s: AreFriends the other way = (%v, %v), want %v", step, got, err, want)
+		}
+	}
+
+	// alice invites bob and carol
+	for _, uid := range []uint64{bob, carol} {
+		if err := f.Friends.Insert(Relation{Src: alice, Dest: uid}); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	check("invited", alice, bob, false)
+	if got := uids(f.Friends.InvitingMe(bob)); len(got) != 1 || got[0] != alice {
+		t.Errorf("InvitingMe of bob = %v, want alice", got)
+	}
+	if got := uids(f.Friends.List(alice)); len(got) != 0 {
+		t.Errorf("List of alice = %v, want none before an answer", got)
+	}
+
+	// bob accepts, carol declines
+	if err := f.Friends.Accept(bob, alice); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	check("accepted", alice, bob, true)
+	if got := uids(f.Friends.List(bob)); len(got) != 1 || got[0] != alice {
+		t.Errorf("List of bob = %v, want alice", got)
+	}
+	if got := uids(f.Friends.InvitingMe(bob)); len(got) != 0 {
+		t.Errorf("InvitingMe of bob = %v, want none after accepting", got)
+	}
+	if err := f.Friends.Decline(carol, alice); err != nil {
+		t.Fatalf("Decline: %v", err)
+	}
+	if _, err := f.Friends.Get(alice, carol); !errors.Is(err, ErrNotFound) {
+		t.Errorf("invitation after Decline: err = %v, want ErrNotFound", err)
+	}
+	// declining does not undo a friendship
+	if err := f.Friends.Decline(bob, alice); err != nil {
+		t.Fatalf("Decline: %v", err)
+	}
+	check("declined after accepting", alice, bob, true)
+
+	// a ban of alice by bob survives alice deleting bob
+	if err := f.Friends.Delete(bob, alice); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	check("deleted", alice, bob, false)
+	if err := f.Friends.Insert(Relation{Src: bob, Dest: alice, Ban: true}); err != nil {
+		t.Fatalf("Insert ban: %v", err)
+	}
+	if err := f.Friends.Delete(alice, bob); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if rel, err := f.Friends.Get(bob, alice); err != nil || !rel.Ban {
+		t.Errorf("ban after the banned deleted = (%+v, %v), want kept", rel, err)
+	}
+	if got := uids(f.Friends.InvitingMe(alice)); len(got) != 0 {
+		t.Errorf("InvitingMe of alice = %v, want the ban hidden", got)
+	}
+	check("banned", alice, bob, false)
+}
+
+func TestThemeRank(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	carol := f.newUser("carol") // not a friend
+	f.befriend(alice, bob)
+	f.catch(alice, testCat1)
+	f.catch(bob, testCat2, testCat3)
+	f.catch(carol, testCat3)
+
+	list, err := f.Friends.ThemeRank(alice, testTheme)
+	if err != nil {
+		t.Fatalf("ThemeRank: %v", err)
+	}
+	want := []Friend{{Uid: bob, ThemeScore: 50, ThemeCats: 2}, {Uid: alice, ThemeScore: 10, ThemeCats: 1}}
+	if len(list) != len(want) {
+		t.Fatalf("ThemeRank = %+v, want bob and alice", list)
+	}
+	for i, w := range want {
+		if got := list[i]; got.Uid != w.Uid || got.ThemeScore != w.ThemeScore || got.ThemeCats != w.ThemeCats {
+			t.Errorf("place %d = %+v, want %+v", i+1, got, w)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package store
+
+import "encoding/json"
+
+// items
+const (
+	ItemBait  = "bait"  // put at a position
+	ItemLure  = "lure"  // put on a cat
+	ItemRadar = "radar" // reveals the nearest uncaught cat
+)
+
+// reasons of an item change
+const (
+	ItemFromQuest         = "quest"          // RefID is the quest_id
+	ItemFromThemeComplete = "theme_complete" // RefID is the theme_id
+	ItemFromAdmin         = "admin"          // RefID is 0
+	ItemFromReferral      = "referral"       // RefID is the other user of the referral
+	ItemUsed              = "use"            // RefID is the effect_id or the cat_id found by a radar
+)
+
+// Stack is a row of inventory, how many of an item a user has.
+type Stack struct {
+	ItemID   string `json:"item_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// ItemChange is a row of item_log. Delta is positive when items are granted
+// and negative when they are used.
+type ItemChange struct {
+	LogID  uint64 `json:"log_id"`
+	ItemID string `json:"item_id"`
+	Delta  int    `json:"delta"`
+	Reason string `json:"reason"`
+	RefID  uint64 `json:"ref_id"`
+	Timing int64  `json:"timing"`
+}
+
+// Effect is a row of item_effect, an item in use until Ends. A lure is on
+// the cat CatID, a bait is at (Lat, Lng) and CatID is 0.
+type Effect struct {
+	EffectID uint64  `json:"effect_id"`
+	ItemID   string  `json:"item_id"`
+	CatID    uint64  `json:"cat_id"`
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+	Starts   int64   `json:"starts"`
+	Ends     int64   `json:"ends"`
+}
+
+// Boost is how the effects of a user raise the points of a catch. Points
+// returns what an effect adds to the cat caught, only the greatest is added
+// to the bonus of the user. Audit is the event recorded with it, Catch sets
+// its Timing and Detail.
+type Boost struct {
+	Points func(e Effect) int
+	Audit  AuditEvent
+}
+
+type Items interface {
+	// Inventory returns the items uid has at least one of.
+	Inventory(uid uint64) ([]Stack, error)
+	// Grant adds n of the item to the inventory of uid at t.
+	Grant(uid uint64, itemID string, n int, reason string, refID uint64, t int64) error
+	// Use takes one of the item from the inventory of uid at t, it returns
+	// ErrConflict if uid has none. If e is not nil it is recorded as the
+	// effect of the item and refID is replaced by its EffectID.
+	Use(uid uint64, itemID string, e *Effect, refID uint64, t int64) error
+	// Effects returns the effects of uid that have not ended at t.
+	Effects(uid uint64, t int64) ([]Effect, error)
+	// Log returns the changes of the inventory of uid, newest first.
+	Log(uid uint64) ([]ItemChange, error)
+}
+
+type items struct {
+	db *conn
+}
+
+func (r *items) Inventory(uid uint64) ([]Stack, error) {
+	list := []Stack{}
+	rows, err := r.db.Query(`
+		SELECT item_id, quantity FROM inventory
+		WHERE user_id = ? and quantity > 0
+		ORDER BY item_id`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := Stack{}
+		if err := rows.Scan(&s.ItemID, &s.Quantity); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (r *items) Grant(uid uint64, itemID string, n int, reason string, refID uint64, t int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := grantItem(tx, uid, itemID, n, reason, refID, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// grantItem adds n of the item to the inventory of uid and logs it.
+func grantItem(tx *tx, uid uint64, itemID string, n int, reason string, refID uint64, t int64) error {
+	if n == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO inventory(user_id, item_id, quantity) values(?, ?, ?)
+		ON CONFLICT (user_id, item_id) DO UPDATE SET quantity = inventory.quantity + excluded.quantity`,
+		uid, itemID, n); err != nil {
+		return err
+	}
+	return logItem(tx, uid, itemID, n, reason, refID, t)
+}
+
+func logItem(tx *tx, uid uint64, itemID string, delta int, reason string, refID uint64, t int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO item_log(user_id, item_id, delta, reason, ref_id, timing)
+		values(?, ?, ?, ?, ?, ?)`, uid, itemID, delta, reason, refID, t)
+	return err
+}
+
+func (r *items) Use(uid uint64, itemID string, e *Effect, refID uint64, t int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the condition on quantity keeps concurrent uses from going below 0
+	res, err := tx.Exec(`
+		UPDATE inventory SET quantity = quantity - 1
+		WHERE user_id = ? and item_id = ? and quantity > 0`, uid, itemID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+
+	if e != nil {
+		e.ItemID = itemID
+		if err := tx.QueryRow(`
+			INSERT INTO item_effect(user_id, item_id, cat_id, lat, lng, starts, ends)
+			values(?, ?, ?, ?, ?, ?, ?)
+			RETURNING effect_id`, uid, e.ItemID, e.CatID, e.Lat, e.Lng, e.Starts, e.Ends).Scan(&e.EffectID); err != nil {
+			return err
+		}
+		refID = e.EffectID
+	}
+	if err := logItem(tx, uid, itemID, -1, ItemUsed, refID, t); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *items) Effects(uid uint64, t int64) ([]Effect, error) {
+	return effects(r.db, uid, t)
+}
+
+func effects(db querier, uid uint64, t int64) ([]Effect, error) {
+	list := []Effect{}
+	rows, err := db.Query(`
+		SELECT effect_id, item_id, cat_id, lat, lng, starts, ends FROM item_effect
+		WHERE user_id = ? and ends > ?
+		ORDER BY effect_id`, uid, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := Effect{}
+		if err := rows.Scan(&e.EffectID, &e.ItemID, &e.CatID, &e.Lat, &e.Lng, &e.Starts, &e.Ends); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// boostCatch adds the points of the best effect of uid at t to a catch of
+// the cat and records it in audit_log.
+func boostCatch(tx *tx, uid uint64, catID uint64, t int64, boost Boost) (int, error) {
+	if boost.Points == nil {
+		return 0, nil
+	}
+	list, err := effects(tx, uid, t)
+	if err != nil {
+		return 0, err
+	}
+	best, points := Effect{}, 0
+	for _, e := range list {
+		if p := boost.Points(e); p > points {
+			best, points = e, p
+		}
+	}
+	if points == 0 {
+		return 0, nil
+	}
+	if err := addBonus(tx, uid, points); err != nil {
+		return 0, err
+	}
+	e := boost.Audit
+	e.Timing = t
+	if e.Detail, err = json.Marshal(map[string]any{
+		"effect_id": best.EffectID, "item_id": best.ItemID, "cat_id": catID, "points": points,
+	}); err != nil {
+		return 0, err
+	}
+	return points, recordAudit(tx, &e)
+}
+
+func (r *items) Log(uid uint64) ([]ItemChange, error) {
+	list := []ItemChange{}
+	rows, err := r.db.Query(`
+		SELECT log_id, item_id, delta, reason, ref_id, timing FROM item_log
+		WHERE user_id = ?
+		ORDER BY log_id DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ch := ItemChange{}
+		if err := rows.Scan(&ch.LogID, &ch.ItemID, &ch.Delta, &ch.Reason, &ch.RefID, &ch.Timing); err != nil {
+			return nil, err
+		}
+		list = append(list, ch)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"strings"
+)
+
+// Message is a row of message, a direct message from Src to Dest.
+type Message struct {
+	MessageID uint64 `json:"message_id"`
+	Src       uint64 `json:"src_uid"`
+	Dest      uint64 `json:"dest_uid"`
+	Body      string `json:"body"`
+	Creating  int64  `json:"creating"`
+	ReadAt    int64  `json:"read_at"` // 0 if Dest has not read it
+}
+
+type Messages interface {
+	// Send inserts m and sets MessageID.
+	Send(m *Message) error
+	// Conversation returns the messages between uid and friendUID, newest
+	// first. before is the message_id to continue from, 0 for the first page.
+	Conversation(uid uint64, friendUID uint64, before uint64, limit int) ([]Message, error)
+	// List returns every message from or to uid as Conversation.
+	List(uid uint64, before uint64, limit int) ([]Message, error)
+	// MarkRead marks the messages from friendUID to uid up to messageID as
+	// read at t, and returns how many were unread.
+	MarkRead(uid uint64, friendUID uint64, messageID uint64, t int64) (int, error)
+	// Unread returns the number of unread messages to uid by sender, of the
+	// senders who are still friends of uid; the messages of a former friend
+	// can not be read any more.
+	Unread(uid uint64) (map[uint64]int, error)
+}
+
+type messages struct {
+	db *conn
+}
+
+func (r *messages) Send(m *Message) error {
+	return r.db.QueryRow(`
+		INSERT INTO message(user_id_src, user_id_dest, body, creating, read_at)
+		values(?, ?, ?, ?, 0) RETURNING message_id`,
+		m.Src, m.Dest, m.Body, m.Creating).Scan(&m.MessageID)
+}
+
+func (r *messages) Conversation(uid uint64, friendUID uint64, before uint64, limit int) ([]Message, error) {
+	where := []string{"((user_id_src = ? and user_id_dest = ?) or (user_id_src = ? and user_id_dest = ?))"}
+	return r.query(where, []any{uid, friendUID, friendUID, uid}, before, limit)
+}
+
+func (r *messages) List(uid uint64, before uint64, limit int) ([]Message, error) {
+	return r.query([]string{"(user_id_src = ? or user_id_dest = ?)"}, []any{uid, uid}, before, limit)
+}
+
+func (r *messages) query(where []string, args []any, before uint64, limit int) ([]Message, error) {
+	if before != 0 {
+		where = append(where, "message_id < ?")
+		args = append(args, before)
+	}
+	args = append(args, limit)
+
+	list := []Message{}
+	rows, err := r.db.Query(`
+		SELECT message_id, user_id_src, user_id_dest, body, creating, read_at
+		FROM message
+		WHERE `+strings.Join(where, " and ")+`
+		ORDER BY message_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m := Message{}
+		if err := rows.Scan(&m.MessageID, &m.Src, &m.Dest, &m.Body, &m.Creating, &m.ReadAt); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (r *messages) MarkRead(uid uint64, friendUID uint64, messageID uint64, t int64) (int, error) {
+	res, err := r.db.Exec(`
+		UPDATE message SET read_at = ?
+		WHERE user_id_src = ? and user_id_dest = ? and message_id <= ? and read_at = 0`,
+		t, friendUID, uid, messageID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *messages) Unread(uid uint64) (map[uint64]int, error) {
+	unread := map[uint64]int{}
+	rows, err := r.db.Query(`
+		SELECT message.user_id_src, COUNT(*) FROM message
+		JOIN friend mine ON
+			mine.user_id_src = message.user_id_dest and mine.user_id_dest = message.user_id_src and
+			mine.accepted = TRUE and mine.ban = FALSE
+		JOIN friend theirs ON
+			theirs.user_id_src = message.user_id_src and theirs.user_id_dest = message.user_id_dest and
+			theirs.accepted = TRUE and theirs.ban = FALSE
+		WHERE message.user_id_dest = ? and message.read_at = 0
+		GROUP BY message.user_id_src`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var src uint64
+		var n int
+		if err := rows.Scan(&src, &n); err != nil {
+			return nil, err
+		}
+		unread[src] = n
+	}
+	return unread, rows.Err()
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessagesUnread(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	carol := f.newUser("carol")
+	dave := f.newUser("dave")
+	f.befriend(alice, bob)
+	f.befriend(alice, carol)
+	f.befriend(alice, dave)
+
+	send := func(src uint64, dest uint64) uint64 {
+		t.Helper()
+		m := &Message{Src: src, Dest: dest, Body: "meow", Creating: 1}
+		if err := f.Messages.Send(m); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		return m.MessageID
+	}
+	first := send(bob, alice)
+	send(bob, alice)
+	send(carol, alice)
+	send(dave, alice)
+	send(alice, bob)
+
+	// carol is no longer a friend and dave is banned
+	if err := f.Friends.Delete(alice, carol); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := f.db.Exec("UPDATE friend SET ban = TRUE WHERE user_id_src = ? and user_id_dest = ?", alice, dave); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+
+	if got, err := f.Messages.Unread(alice); err != nil {
+		t.Fatalf("Unread: %v", err)
+	} else if want := map[uint64]int{bob: 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unread of alice = %v, want %v", got, want)
+	}
+	if got, err := f.Messages.Unread(bob); err != nil {
+		t.Fatalf("Unread: %v", err)
+	} else if want := map[uint64]int{alice: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unread of bob = %v, want %v", got, want)
+	}
+
+	if n, err := f.Messages.MarkRead(alice, bob, first, 2); err != nil || n != 1 {
+		t.Errorf("MarkRead = (%d, %v), want (1, nil)", n, err)
+	}
+	if got, err := f.Messages.Unread(alice); err != nil {
+		t.Fatalf("Unread: %v", err)
+	} else if want := map[uint64]int{bob: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unread of alice after reading = %v, want %v", got, want)
+	}
+}
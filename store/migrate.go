@@ -0,0 +1,435 @@
+package store
+
+import "fmt"
+
+// migration is a schema change. sql is written for SQLite and translated by
+// the dialect; postgres, if set, replaces it on PostgreSQL.
+type migration struct {
+	sql      string
+	postgres string
+}
+
+func (m migration) stmt(d dialect) string {
+	if _, ok := d.(postgres); ok && m.postgres != "" {
+		return m.postgres
+	}
+	return d.ddl(m.sql)
+}
+
+// migrations are applied in order and recorded in schema_version, append new
+// ones at the end and never edit an applied one.
+var migrations = []migration{
+	// 1: the tables described in README
+	{sql: `
+	CREATE TABLE IF NOT EXISTS cat_kind (
+		cat_kind_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT    NOT NULL,
+		thumbnail   TEXT    NOT NULL,
+		description TEXT    NOT NULL,
+		weight      INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS theme (
+		theme_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT NOT NULL,
+		thumbnail   TEXT NOT NULL,
+		description TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS cat (
+		cat_id      INTEGER PRIMARY KEY AUTOINCREMENT,
+		cat_kind_id INTEGER NOT NULL,
+		lng         REAL    NOT NULL,
+		lat         REAL    NOT NULL,
+		theme_id    INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS user_cat (
+		user_id INTEGER NOT NULL,
+		cat_id  INTEGER NOT NULL,
+		timing  INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS "user" (
+		user_id    INTEGER PRIMARY KEY,
+		salt       TEXT    NOT NULL,
+		password   TEXT    NOT NULL,
+		name       TEXT    NOT NULL,
+		profile    TEXT    NOT NULL DEFAULT '',
+		email      TEXT    NOT NULL,
+		creating   INTEGER NOT NULL,
+		last_login INTEGER NOT NULL,
+		last_lng   REAL    NOT NULL DEFAULT 0,
+		last_lat   REAL    NOT NULL DEFAULT 0,
+		verified   BOOLEAN NOT NULL DEFAULT FALSE,
+		share_gps  BOOLEAN NOT NULL DEFAULT FALSE
+	);
+	CREATE TABLE IF NOT EXISTS verify_email (
+		verify_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id   INTEGER NOT NULL,
+		email     TEXT    NOT NULL,
+		token     TEXT    NOT NULL,
+		expire    INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS friend (
+		friend_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id_src  INTEGER NOT NULL,
+		user_id_dest INTEGER NOT NULL,
+		accepted     BOOLEAN NOT NULL DEFAULT FALSE,
+		ban          BOOLEAN NOT NULL DEFAULT FALSE
+	);
+	CREATE INDEX IF NOT EXISTS user_cat_user ON user_cat(user_id);
+	CREATE INDEX IF NOT EXISTS cat_theme ON cat(theme_id);
+	CREATE INDEX IF NOT EXISTS friend_src ON friend(user_id_src);
+	CREATE INDEX IF NOT EXISTS friend_dest ON friend(user_id_dest);
+	`},
+	// 2: a cat is caught once by a user, which catching relies on
+	{sql: `
+	DELETE FROM user_cat WHERE rowid NOT IN (
+		SELECT MIN(rowid) FROM user_cat GROUP BY user_id, cat_id
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS user_cat_unique ON user_cat(user_id, cat_id);
+	`, postgres: `
+	CREATE UNIQUE INDEX IF NOT EXISTS user_cat_unique ON user_cat(user_id, cat_id);
+	`},
+	// 3: security audit log
+	{sql: `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		audit_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		timing     INTEGER NOT NULL,
+		event      TEXT    NOT NULL,
+		user_id    INTEGER NOT NULL,
+		ip         TEXT    NOT NULL,
+		request_id TEXT    NOT NULL,
+		detail     TEXT    NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log(user_id);
+	CREATE INDEX IF NOT EXISTS audit_log_event ON audit_log(event);
+	`},
+	// 4: user_stats, the read model of what a user caught, maintained by
+	// Catch so that the score needs no aggregation when read
+	{sql: `
+	CREATE TABLE IF NOT EXISTS user_stats (
+		user_id INTEGER PRIMARY KEY,
+		cats    INTEGER NOT NULL DEFAULT 0,
+		score   INTEGER NOT NULL DEFAULT 0
+	);
+	INSERT INTO user_stats(user_id, cats, score)
+	SELECT "user".user_id, COUNT(t.weight), COALESCE(SUM(t.weight), 0)
+	FROM "user"
+	LEFT JOIN (
+		SELECT user_cat.user_id, cat_kind.weight
+		FROM user_cat
+		JOIN cat ON cat.cat_id = user_cat.cat_id
+		JOIN cat_kind ON cat_kind.cat_kind_id = cat.cat_kind_id
+	) t ON t.user_id = "user".user_id
+	GROUP BY "user".user_id;
+	`},
+	// 5: availability windows of themes, 0 means unbounded and an empty
+	// recurrence means always within the window
+	{sql: `
+	ALTER TABLE theme ADD COLUMN starts INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE theme ADD COLUMN ends INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE theme ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+	ALTER TABLE theme ADD COLUMN coming_soon BOOLEAN NOT NULL DEFAULT FALSE;
+	`},
+	// 6: when a user first caught every cat of a theme, backfilled with the
+	// time of the last catch of the themes already completed
+	{sql: `
+	CREATE TABLE IF NOT EXISTS theme_completion (
+		user_id   INTEGER NOT NULL,
+		theme_id  INTEGER NOT NULL,
+		completed INTEGER NOT NULL,
+		PRIMARY KEY (user_id, theme_id)
+	);
+	INSERT INTO theme_completion(user_id, theme_id, completed)
+	SELECT user_cat.user_id, cat.theme_id, MAX(user_cat.timing)
+	FROM user_cat
+	JOIN cat ON cat.cat_id = user_cat.cat_id
+	GROUP BY user_cat.user_id, cat.theme_id
+	HAVING COUNT(user_cat.cat_id) = (SELECT COUNT(*) FROM cat c WHERE c.theme_id = cat.theme_id);
+	`},
+	// 7: the catch timeline is read newest first
+	{sql: `
+	CREATE INDEX IF NOT EXISTS user_cat_timing ON user_cat(user_id, timing);
+	`},
+	// 8: trades of caught cats between friends, giver is the user who gives
+	// the cat
+	{sql: `
+	CREATE TABLE IF NOT EXISTS trade (
+		trade_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id_src  INTEGER NOT NULL,
+		user_id_dest INTEGER NOT NULL,
+		status       TEXT    NOT NULL,
+		creating     INTEGER NOT NULL,
+		updating     INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS trade_cat (
+		trade_id INTEGER NOT NULL,
+		cat_id   INTEGER NOT NULL,
+		giver    INTEGER NOT NULL,
+		PRIMARY KEY (trade_id, cat_id)
+	);
+	CREATE INDEX IF NOT EXISTS trade_src ON trade(user_id_src);
+	CREATE INDEX IF NOT EXISTS trade_dest ON trade(user_id_dest);
+	`},
+	// 9: the activity feed, a row for every friend who sees the activity
+	{sql: `
+	ALTER TABLE "user" ADD COLUMN share_activity BOOLEAN NOT NULL DEFAULT TRUE;
+	CREATE TABLE IF NOT EXISTS activity (
+		activity_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		owner_id    INTEGER NOT NULL,
+		actor_id    INTEGER NOT NULL,
+		kind        TEXT    NOT NULL,
+		detail      TEXT    NOT NULL,
+		timing      INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS activity_owner ON activity(owner_id, activity_id);
+	CREATE INDEX IF NOT EXISTS activity_actor ON activity(actor_id);
+	`},
+	// 10: push tokens and the outbox of push notifications
+	{sql: `
+	CREATE TABLE IF NOT EXISTS device (
+		token    TEXT    PRIMARY KEY,
+		user_id  INTEGER NOT NULL,
+		platform TEXT    NOT NULL,
+		creating INTEGER NOT NULL,
+		updating INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS device_user ON device(user_id);
+	CREATE TABLE IF NOT EXISTS push_outbox (
+		outbox_id    INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER NOT NULL,
+		token        TEXT    NOT NULL,
+		kind         TEXT    NOT NULL,
+		title        TEXT    NOT NULL,
+		body         TEXT    NOT NULL,
+		data         TEXT    NOT NULL,
+		status       TEXT    NOT NULL,
+		attempts     INTEGER NOT NULL,
+		next_attempt INTEGER NOT NULL,
+		last_error   TEXT    NOT NULL,
+		creating     INTEGER NOT NULL,
+		updating     INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS push_outbox_due ON push_outbox(status, next_attempt);
+	CREATE INDEX IF NOT EXISTS push_outbox_user ON push_outbox(user_id);
+	`},
+	// 11: the in-app inbox
+	{sql: `
+	CREATE TABLE IF NOT EXISTS notification (
+		notification_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id         INTEGER NOT NULL,
+		kind            TEXT    NOT NULL,
+		detail          TEXT    NOT NULL,
+		is_read         BOOLEAN NOT NULL DEFAULT FALSE,
+		timing          INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS notification_user ON notification(user_id, notification_id);
+	`},
+	// 12: direct messages between friends
+	{sql: `
+	CREATE TABLE IF NOT EXISTS message (
+		message_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id_src  INTEGER NOT NULL,
+		user_id_dest INTEGER NOT NULL,
+		body         TEXT    NOT NULL,
+		creating     INTEGER NOT NULL,
+		read_at      INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS message_pair ON message(user_id_src, user_id_dest, message_id);
+	CREATE INDEX IF NOT EXISTS message_dest ON message(user_id_dest, read_at);
+	`},
+	// 13: teams, a user is in one team at most
+	{sql: `
+	CREATE TABLE IF NOT EXISTS team (
+		team_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT    NOT NULL,
+		description TEXT    NOT NULL,
+		owner_id    INTEGER NOT NULL,
+		creating    INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS team_member (
+		user_id INTEGER PRIMARY KEY,
+		team_id INTEGER NOT NULL,
+		role    TEXT    NOT NULL,
+		joined  INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS team_member_team ON team_member(team_id);
+	CREATE TABLE IF NOT EXISTS team_invite (
+		team_id    INTEGER NOT NULL,
+		user_id    INTEGER NOT NULL,
+		inviter_id INTEGER NOT NULL,
+		creating   INTEGER NOT NULL,
+		PRIMARY KEY (team_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS team_invite_user ON team_invite(user_id);
+	`},
+	// 14: challenges between friends
+	{sql: `
+	CREATE TABLE IF NOT EXISTS challenge (
+		challenge_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER NOT NULL,
+		theme_id     INTEGER NOT NULL,
+		goal         TEXT    NOT NULL,
+		starts       INTEGER NOT NULL,
+		ends         INTEGER NOT NULL,
+		status       TEXT    NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS challenge_due ON challenge(status, ends);
+	CREATE TABLE IF NOT EXISTS challenge_participant (
+		challenge_id INTEGER NOT NULL,
+		user_id      INTEGER NOT NULL,
+		status       TEXT    NOT NULL,
+		score        INTEGER NOT NULL,
+		place        INTEGER NOT NULL,
+		PRIMARY KEY (challenge_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS challenge_participant_user ON challenge_participant(user_id);
+	`},
+	// 15: quests and login streaks; the bonus points they grant are part of
+	// the score and kept apart so that the score can be recounted
+	{sql: `
+	ALTER TABLE "user" ADD COLUMN timezone TEXT NOT NULL DEFAULT '';
+	ALTER TABLE user_stats ADD COLUMN bonus INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS quest (
+		quest_id     INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id      INTEGER NOT NULL,
+		period       TEXT    NOT NULL,
+		period_start TEXT    NOT NULL,
+		slot         INTEGER NOT NULL,
+		kind         TEXT    NOT NULL,
+		target_id    INTEGER NOT NULL,
+		goal         INTEGER NOT NULL,
+		progress     INTEGER NOT NULL,
+		bonus        INTEGER NOT NULL,
+		completed    INTEGER NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS quest_slot ON quest(user_id, period, period_start, slot);
+	CREATE TABLE IF NOT EXISTS login_streak (
+		user_id  INTEGER PRIMARY KEY,
+		streak   INTEGER NOT NULL,
+		best     INTEGER NOT NULL,
+		last_day TEXT    NOT NULL
+	);
+	`},
+	// 16: inventories of items; every change of a quantity is written to
+	// item_log in the same transaction
+	{sql: `
+	ALTER TABLE quest ADD COLUMN reward_item TEXT NOT NULL DEFAULT '';
+	ALTER TABLE quest ADD COLUMN reward_count INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE IF NOT EXISTS inventory (
+		user_id  INTEGER NOT NULL,
+		item_id  TEXT    NOT NULL,
+		quantity INTEGER NOT NULL,
+		PRIMARY KEY (user_id, item_id)
+	);
+	CREATE TABLE IF NOT EXISTS item_log (
+		log_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		item_id TEXT    NOT NULL,
+		delta   INTEGER NOT NULL,
+		reason  TEXT    NOT NULL,
+		ref_id  INTEGER NOT NULL,
+		timing  INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS item_log_user ON item_log(user_id, log_id);
+	CREATE TABLE IF NOT EXISTS item_effect (
+		effect_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id   INTEGER NOT NULL,
+		item_id   TEXT    NOT NULL,
+		cat_id    INTEGER NOT NULL,
+		lat       REAL    NOT NULL,
+		lng       REAL    NOT NULL,
+		starts    INTEGER NOT NULL,
+		ends      INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS item_effect_user ON item_effect(user_id, ends);
+	`},
+	// 17: referral codes and who referred whom
+	{sql: `
+	CREATE TABLE IF NOT EXISTS referral_code (
+		user_id INTEGER PRIMARY KEY,
+		code    TEXT    NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS referral_code_code ON referral_code(code);
+	CREATE TABLE IF NOT EXISTS referral (
+		referee_id  INTEGER PRIMARY KEY,
+		referrer_id INTEGER NOT NULL,
+		status      TEXT    NOT NULL,
+		creating    INTEGER NOT NULL,
+		first_catch INTEGER NOT NULL,
+		rewarded    INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS referral_referrer ON referral(referrer_id, creating);
+	`},
+	// 18: who uploaded each file under config.UploadRoot
+	{sql: `
+	CREATE TABLE IF NOT EXISTS upload (
+		file     TEXT    PRIMARY KEY,
+		user_id  INTEGER NOT NULL,
+		creating INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS upload_user ON upload(user_id);
+	`},
+	// 19: cats given away in a trade, which the giver can not catch again
+	{sql: `
+	CREATE TABLE IF NOT EXISTS traded_away (
+		user_id  INTEGER NOT NULL,
+		cat_id   INTEGER NOT NULL,
+		trade_id INTEGER NOT NULL,
+		timing   INTEGER NOT NULL,
+		PRIMARY KEY (user_id, cat_id)
+	);
+	`},
+	// 20: when the time zone was last changed, to limit how often it is
+	{sql: `
+	ALTER TABLE "user" ADD COLUMN timezone_changed INTEGER NOT NULL DEFAULT 0;
+	`},
+	// 21: referrals of deleted referees are kept with referee_id 0, which
+	// needs a key of their own
+	{sql: `
+	CREATE TABLE referral_v2 (
+		referral_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		referee_id  INTEGER NOT NULL,
+		referrer_id INTEGER NOT NULL,
+		status      TEXT    NOT NULL,
+		creating    INTEGER NOT NULL,
+		first_catch INTEGER NOT NULL,
+		rewarded    INTEGER NOT NULL
+	);
+	INSERT INTO referral_v2(referee_id, referrer_id, status, creating, first_catch, rewarded)
+		SELECT referee_id, referrer_id, status, creating, first_catch, rewarded
+		FROM referral ORDER BY creating;
+	DROP TABLE referral;
+	ALTER TABLE referral_v2 RENAME TO referral;
+	CREATE INDEX referral_referrer ON referral(referrer_id, creating);
+	CREATE UNIQUE INDEX referral_referee ON referral(referee_id) WHERE referee_id <> 0;
+	`},
+}
+
+func migrate(db *conn) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("migrate %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i].stmt(db.dialect)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version(version) values(?)", i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"encoding/json"
+)
+
+// Notification is a row of notification, an entry of the in-app inbox.
+// Detail is a JSON object.
+type Notification struct {
+	NotificationID uint64          `json:"notification_id"`
+	Kind           string          `json:"kind"`
+	Detail         json.RawMessage `json:"detail"`
+	Read           bool            `json:"read"`
+	Timing         int64           `json:"timing"`
+}
+
+type Notifications interface {
+	// Add inserts n into the inbox of uid and sets NotificationID.
+	Add(uid uint64, n *Notification) error
+	// Broadcast adds the notification to every user.
+	Broadcast(kind string, detail json.RawMessage, t int64) error
+	// List returns the notifications of uid, newest first. before is the
+	// notification_id to continue from, 0 for the first page.
+	List(uid uint64, unreadOnly bool, before uint64, limit int) ([]Notification, error)
+	Unread(uid uint64) (int, error)
+	// MarkRead returns ErrNotFound if uid has no such notification.
+	MarkRead(uid uint64, notificationID uint64) error
+	MarkAllRead(uid uint64) error
+	// Delete returns ErrNotFound if uid has no such notification.
+	Delete(uid uint64, notificationID uint64) error
+}
+
+type notifications struct {
+	db *conn
+}
+
+func (r *notifications) Add(uid uint64, n *Notification) error {
+	return r.db.QueryRow(`
+		INSERT INTO notification(user_id, kind, detail, is_read, timing)
+		values(?, ?, ?, ?, ?) RETURNING notification_id`,
+		uid, n.Kind, string(n.Detail), n.Read, n.Timing).Scan(&n.NotificationID)
+}
+
+func (r *notifications) Broadcast(kind string, detail json.RawMessage, t int64) error {
+	_, err := r.db.Exec(`
+		INSERT INTO notification(user_id, kind, detail, is_read, timing)
+		SELECT user_id, ?, ?, FALSE, ? FROM "user"`, kind, string(detail), t)
+	return err
+}
+
+func (r *notifications) List(uid uint64, unreadOnly bool, before uint64, limit int) ([]Notification, error) {
+	where := "user_id = ?"
+	args := []any{uid}
+	if unreadOnly {
+		where += " and is_read = FALSE"
+	}
+	if before != 0 {
+		where += " and notification_id < ?"
+		args = append(args, before)
+	}
+	args = append(args, limit)
+
+	list := []Notification{}
+	rows, err := r.db.Query(`
+		SELECT notification_id, kind, detail, is_read, timing
+		FROM notification
+		WHERE `+where+`
+		ORDER BY notification_id DESC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		n := Notification{}
+		// detail is TEXT, see Audits.Query
+		var detail []byte
+		if err := rows.Scan(&n.NotificationID, &n.Kind, &detail, &n.Read, &n.Timing); err != nil {
+			return nil, err
+		}
+		n.Detail = detail
+		list = append(list, n)
+	}
+	return list, rows.Err()
+}
+
+func (r *notifications) Unread(uid uint64) (int, error) {
+	return count(r.db, "SELECT COUNT(*) FROM notification WHERE user_id = ? and is_read = FALSE", uid)
+}
+
+func (r *notifications) MarkRead(uid uint64, notificationID uint64) error {
+	res, err := r.db.Exec(`
+		UPDATE notification SET is_read = TRUE
+		WHERE user_id = ? and notification_id = ?`, uid, notificationID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *notifications) MarkAllRead(uid uint64) error {
+	_, err := r.db.Exec("UPDATE notification SET is_read = TRUE WHERE user_id = ? and is_read = FALSE", uid)
+	return err
+}
+
+func (r *notifications) Delete(uid uint64, notificationID uint64) error {
+	res, err := r.db.Exec("DELETE FROM notification WHERE user_id = ? and notification_id = ?", uid, notificationID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
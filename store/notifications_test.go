@@ -0,0 +1,100 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNotifications(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+
+	add := func(uid uint64, kind string) uint64 {
+		t.Helper()
+		n := &Notification{Kind: kind, Detail: []byte(`{"level":2}`), Timing: 1}
+		if err := f.Notifications.Add(uid, n); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		return n.NotificationID
+	}
+	list := func(uid uint64, unreadOnly bool, before uint64, limit int) []string {
+		t.Helper()
+		l, err := f.Notifications.List(uid, unreadOnly, before, limit)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		kinds := []string{}
+		for _, n := range l {
+			kinds = append(kinds, n.Kind)
+		}
+		return kinds
+	}
+	unread := func(uid uint64) int {
+		t.Helper()
+		n, err := f.Notifications.Unread(uid)
+		if err != nil {
+			t.Fatalf("Unread: %v", err)
+		}
+		return n
+	}
+
+	a := add(alice, "a")
+	b := add(alice, "b")
+	add(bob, "bob")
+	if err := f.Notifications.Broadcast("everyone", []byte("{}"), 2); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+
+	if got, want := list(alice, false, 0, 10), []string{"everyone", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice = %v, want %v", got, want)
+	}
+	if got, want := list(bob, false, 0, 10), []string{"everyone", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bob = %v, want %v", got, want)
+	}
+	if got, want := list(alice, false, b, 10), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice before b = %v, want %v", got, want)
+	}
+	if got, want := list(alice, false, 0, 1), []string{"everyone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice limited = %v, want %v", got, want)
+	}
+
+	// bob can neither read nor delete the notifications of alice
+	if err := f.Notifications.MarkRead(bob, a); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MarkRead by bob: err = %v, want ErrNotFound", err)
+	}
+	if err := f.Notifications.Delete(bob, a); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete by bob: err = %v, want ErrNotFound", err)
+	}
+
+	if err := f.Notifications.MarkRead(alice, a); err != nil {
+		t.Fatalf("MarkRead: %v", err)
+	}
+	if got, want := list(alice, true, 0, 10), []string{"everyone", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unread of alice = %v, want %v", got, want)
+	}
+	if got := unread(alice); got != 2 {
+		t.Errorf("Unread of alice = %d, want 2", got)
+	}
+
+	if err := f.Notifications.MarkAllRead(alice); err != nil {
+		t.Fatalf("MarkAllRead: %v", err)
+	}
+	if got := unread(alice); got != 0 {
+		t.Errorf("Unread of alice after reading all = %d, want 0", got)
+	}
+	if got := unread(bob); got != 2 {
+		t.Errorf("Unread of bob = %d, want 2", got)
+	}
+
+	if err := f.Notifications.Delete(alice, a); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := f.Notifications.Delete(alice, a); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete twice: err = %v, want ErrNotFound", err)
+	}
+	if got, want := list(alice, false, 0, 10), []string{"everyone", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("alice after the deletion = %v, want %v", got, want)
+	}
+}
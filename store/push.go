@@ -0,0 +1,220 @@
+package store
+
+import (
+	"encoding/json"
+)
+
+// push_outbox status
+const (
+	PushPending = "pending"
+	PushSent    = "sent"
+	PushFailed  = "failed" // given up, or the token is no longer valid
+)
+
+// Device is a row of device, a push token of an app installation.
+type Device struct {
+	Token    string `json:"token"`
+	UID      uint64 `json:"uid"`
+	Platform string `json:"platform"`
+	Creating int64  `json:"creating"`
+	Updating int64  `json:"updating"`
+}
+
+// PushMessage is a row of push_outbox, a notification to one device. Data is
+// a JSON object of strings.
+type PushMessage struct {
+	OutboxID    uint64
+	UID         uint64
+	Token       string
+	Kind        string
+	Title       string
+	Body        string
+	Data        json.RawMessage
+	Attempts    int // including the one in progress
+	NextAttempt int64
+	Creating    int64
+}
+
+type Devices interface {
+	// Register binds the token to d.UID, a token of another user is moved
+	// and the messages pending for it are dropped.
+	Register(d *Device) error
+	// Unregister returns ErrNotFound if uid has no such token.
+	Unregister(uid uint64, token string) error
+	// Remove drops a token rejected by the push service.
+	Remove(token string) error
+	List(uid uint64) ([]Device, error)
+}
+
+type Outbox interface {
+	// Enqueue inserts a copy of m for every device of m.UID and returns how
+	// many were inserted.
+	Enqueue(m *PushMessage) (int, error)
+	// Claim returns up to limit pending messages due at now and postpones
+	// them to lease, so that a message is not sent twice at the same time.
+	// The attempts of the messages are incremented.
+	Claim(now int64, lease int64, limit int) ([]PushMessage, error)
+	// Retry sets the time of the next attempt of a pending message.
+	Retry(outboxID uint64, next int64, lastError string, t int64) error
+	// Finish sets the status to PushSent or PushFailed.
+	Finish(outboxID uint64, status string, lastError string, t int64) error
+	// Purge deletes the sent and failed messages last updated before t.
+	Purge(t int64) error
+}
+
+type devices struct {
+	db *conn
+}
+
+func (r *devices) Register(d *Device) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM push_outbox
+		WHERE token = ? and user_id <> ? and status = ?`, d.Token, d.UID, PushPending); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO device(token, user_id, platform, creating, updating)
+		values(?, ?, ?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET
+			user_id = excluded.user_id,
+			platform = excluded.platform,
+			updating = excluded.updating`,
+		d.Token, d.UID, d.Platform, d.Creating, d.Updating); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *devices) Unregister(uid uint64, token string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM device WHERE token = ? and user_id = ?", token, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM push_outbox WHERE token = ? and status = ?", token, PushPending); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *devices) Remove(token string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM device WHERE token = ?", token); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM push_outbox WHERE token = ? and status = ?", token, PushPending); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *devices) List(uid uint64) ([]Device, error) {
+	list := []Device{}
+	rows, err := r.db.Query(`
+		SELECT token, user_id, platform, creating, updating
+		FROM device WHERE user_id = ?
+		ORDER BY creating`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := Device{}
+		if err := rows.Scan(&d.Token, &d.UID, &d.Platform, &d.Creating, &d.Updating); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+type outbox struct {
+	db *conn
+}
+
+func (r *outbox) Enqueue(m *PushMessage) (int, error) {
+	res, err := r.db.Exec(`
+		INSERT INTO push_outbox(user_id, token, kind, title, body, data, status,
+			attempts, next_attempt, last_error, creating, updating)
+		SELECT user_id, token, ?, ?, ?, ?, ?, 0, ?, '', ?, ?
+		FROM device WHERE user_id = ?`,
+		m.Kind, m.Title, m.Body, string(m.Data), PushPending,
+		m.NextAttempt, m.Creating, m.Creating, m.UID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *outbox) Claim(now int64, lease int64, limit int) ([]PushMessage, error) {
+	// the conditions are repeated outside the subquery so that a row claimed
+	// concurrently by another worker is skipped
+	list := []PushMessage{}
+	rows, err := r.db.Query(`
+		UPDATE push_outbox SET attempts = attempts + 1, next_attempt = ?, updating = ?
+		WHERE status = ? and next_attempt <= ? and outbox_id IN (
+			SELECT outbox_id FROM push_outbox
+			WHERE status = ? and next_attempt <= ?
+			ORDER BY next_attempt
+			LIMIT ?
+		)
+		RETURNING outbox_id, user_id, token, kind, title, body, data, attempts, next_attempt, creating`,
+		lease, now, PushPending, now, PushPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m := PushMessage{}
+		// data is TEXT, see Audits.Query
+		var data []byte
+		if err := rows.Scan(&m.OutboxID, &m.UID, &m.Token, &m.Kind, &m.Title, &m.Body, &data,
+			&m.Attempts, &m.NextAttempt, &m.Creating); err != nil {
+			return nil, err
+		}
+		m.Data = data
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (r *outbox) Retry(outboxID uint64, next int64, lastError string, t int64) error {
+	_, err := r.db.Exec(`
+		UPDATE push_outbox SET next_attempt = ?, last_error = ?, updating = ?
+		WHERE outbox_id = ? and status = ?`, next, lastError, t, outboxID, PushPending)
+	return err
+}
+
+func (r *outbox) Finish(outboxID uint64, status string, lastError string, t int64) error {
+	_, err := r.db.Exec(`
+		UPDATE push_outbox SET status = ?, last_error = ?, updating = ?
+		WHERE outbox_id = ? and status = ?`, status, lastError, t, outboxID, PushPending)
+	return err
+}
+
+func (r *outbox) Purge(t int64) error {
+	_, err := r.db.Exec("DELETE FROM push_outbox WHERE status <> ? and updating < ?", PushPending, t)
+	return err
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// quest periods
+const (
+	QuestDaily  = "daily"
+	QuestWeekly = "weekly"
+)
+
+// quest kinds, what counts toward the goal
+const (
+	QuestCatch   = "catch"    // any cat
+	QuestTheme   = "theme"    // a cat of the theme TargetID
+	QuestCatKind = "cat_kind" // a cat of the kind TargetID
+	QuestVisit   = "visit"    // open the theme TargetID, goal is 1
+)
+
+// Quest is a row of quest, an objective of a day or a week of the user.
+// PeriodStart is the local date the period starts, as YYYY-MM-DD.
+type Quest struct {
+	QuestID     uint64 `json:"quest_id"`
+	Slot        int    `json:"-"` // the index of the quest in its period
+	Period      string `json:"period"`
+	PeriodStart string `json:"period_start"`
+	Kind        string `json:"kind"`
+	TargetID    uint64 `json:"target_id"` // 0 for catch
+	Goal        int    `json:"goal"`
+	Progress    int    `json:"progress"`
+	Bonus       int    `json:"bonus"`
+	RewardItem  string `json:"reward_item"` // "" if none
+	RewardCount int    `json:"reward_count"`
+	Completed   int64  `json:"completed"` // unix time, 0 if not yet
+}
+
+// Streak is a row of login_streak. LastDay is the local date of the last
+// check-in, as YYYY-MM-DD.
+type Streak struct {
+	Current int    `json:"current"`
+	Best    int    `json:"best"`
+	LastDay string `json:"last_day"`
+}
+
+type Quests interface {
+	// Assign inserts the quests of uid whose slot of the period is still
+	// empty.
+	Assign(uid uint64, list []Quest) error
+	// List returns the quests of uid of the day and the week.
+	List(uid uint64, day string, week string) ([]Quest, error)
+	// All returns every quest of uid, newest first.
+	All(uid uint64) ([]Quest, error)
+	// Advance counts a catch of a cat of the kind in the theme toward the
+	// open quests of the day and the week, and completes those reaching
+	// their goal at t, adding their bonus to user_stats and their reward to
+	// the inventory. It returns the quests completed.
+	Advance(uid uint64, day string, week string, themeID uint64, catKindID uint64, t int64) ([]Quest, error)
+	// Visit completes the open visit quests of the theme of the day and the
+	// week at t as Advance, opening a theme again does not count twice.
+	Visit(uid uint64, day string, week string, themeID uint64, t int64) ([]Quest, error)
+
+	// CheckIn records that uid came back on day, the day after yesterday. It
+	// returns the streak and the bonus added to user_stats, which is
+	// bonus[n-1] for the n-th day of the streak or the last one for a longer
+	// streak, and 0 if uid has checked in on day or a later day already, as
+	// after moving to a time zone further west.
+	CheckIn(uid uint64, day string, yesterday string, bonus []int) (Streak, int, error)
+	Streak(uid uint64) (Streak, error)
+}
+
+type quests struct {
+	db *conn
+}
+
+const questColumns = "quest_id, slot, period, period_start, kind, target_id, goal, progress, bonus, reward_item, reward_count, completed"
+
+func (r *quests) Assign(uid uint64, list []Quest) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range list {
+		// the slot keeps concurrent assignments from doubling the quests
+		if _, err := tx.Exec(`
+			INSERT INTO quest(user_id, period, period_start, slot, kind, target_id, goal, progress, bonus,
+			                  reward_item, reward_count, completed)
+			values(?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, 0)
+			ON CONFLICT (user_id, period, period_start, slot) DO NOTHING`,
+			uid, q.Period, q.PeriodStart, q.Slot, q.Kind, q.TargetID, q.Goal, q.Bonus,
+			q.RewardItem, q.RewardCount); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *quests) List(uid uint64, day string, week string) ([]Quest, error) {
+	return r.query(r.db, `
+		SELECT `+questColumns+` FROM quest
+		WHERE user_id = ? and ((period = ? and period_start = ?) or (period = ? and period_start = ?))
+		ORDER BY period, slot`, uid, QuestDaily, day, QuestWeekly, week)
+}
+
+func (r *quests) All(uid uint64) ([]Quest, error) {
+	return r.query(r.db, `
+		SELECT `+questColumns+` FROM quest
+		WHERE user_id = ?
+		ORDER BY quest_id DESC`, uid)
+}
+
+func (r *quests) query(db querier, query string, args ...any) ([]Quest, error) {
+	list := []Quest{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		q := Quest{}
+		if err := rows.Scan(&q.QuestID, &q.Slot, &q.Period, &q.PeriodStart, &q.Kind, &q.TargetID,
+			&q.Goal, &q.Progress, &q.Bonus, &q.RewardItem, &q.RewardCount, &q.Completed); err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	return list, rows.Err()
+}
+
+func (r *quests) Advance(uid uint64, day string, week string, themeID uint64, catKindID uint64, t int64) ([]Quest, error) {
+	return r.advance(uid, t, `
+		UPDATE quest SET progress = progress + 1
+		WHERE
+			user_id = ? and completed = 0 and
+			((period = ? and period_start = ?) or (period = ? and period_start = ?)) and
+			(kind = ? or (kind = ? and target_id = ?) or (kind = ? and target_id = ?))`,
+		uid, QuestDaily, day, QuestWeekly, week,
+		QuestCatch, QuestTheme, themeID, QuestCatKind, catKindID)
+}
+
+func (r *quests) Visit(uid uint64, day string, week string, themeID uint64, t int64) ([]Quest, error) {
+	return r.advance(uid, t, `
+		UPDATE quest SET progress = goal
+		WHERE
+			user_id = ? and completed = 0 and
+			((period = ? and period_start = ?) or (period = ? and period_start = ?)) and
+			kind = ? and target_id = ?`,
+		uid, QuestDaily, day, QuestWeekly, week, QuestVisit, themeID)
+}
+
+// advance runs update, which makes progress on the quests of uid, and
+// completes those reaching their goal at t with their bonus and reward.
+func (r *quests) advance(uid uint64, t int64, update string, args ...any) ([]Quest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(update, args...); err != nil {
+		return nil, err
+	}
+	list, err := r.query(tx, `
+		UPDATE quest SET completed = ?
+		WHERE user_id = ? and completed = 0 and progress >= goal
+		RETURNING `+questColumns, t, uid)
+	if err != nil {
+		return nil, err
+	}
+	bonus := 0
+	for _, q := range list {
+		bonus += q.Bonus
+		if q.RewardItem == "" {
+			continue
+		}
+		if err := grantItem(tx, uid, q.RewardItem, q.RewardCount, ItemFromQuest, q.QuestID, t); err != nil {
+			return nil, err
+		}
+	}
+	if err := addBonus(tx, uid, bonus); err != nil {
+		return nil, err
+	}
+	return list, tx.Commit()
+}
+
+// addBonus adds points to the score of uid, which are kept apart in
+// user_stats.bonus so that recountStats does not lose them.
+func addBonus(tx *tx, uid uint64, points int) error {
+	if points == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE user_stats SET score = score + ?, bonus = bonus + ?
+		WHERE user_id = ?`, points, points, uid)
+	return err
+}
+
+func (r *quests) CheckIn(uid uint64, day string, yesterday string, bonus []int) (Streak, int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return Streak{}, 0, err
+	}
+	defer tx.Rollback()
+
+	s, err := streak(tx, uid)
+	if err != nil {
+		return s, 0, err
+	}
+	// the days are YYYY-MM-DD, which sort as strings
+	if day <= s.LastDay {
+		return s, 0, nil
+	}
+	if s.LastDay == yesterday {
+		s.Current++
+	} else {
+		s.Current = 1
+	}
+	s.Best = max(s.Best, s.Current)
+	s.LastDay = day
+	// the last_day guards against checking in twice at the same time
+	res, err := tx.Exec(`
+		INSERT INTO login_streak(user_id, streak, best, last_day)
+		values(?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			streak = excluded.streak,
+			best = excluded.best,
+			last_day = excluded.last_day
+		WHERE login_streak.last_day < excluded.last_day`, uid, s.Current, s.Best, s.LastDay)
+	if err != nil {
+		return s, 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return s, 0, err
+	} else if n == 0 {
+		return s, 0, nil
+	}
+
+	points := 0
+	if len(bonus) > 0 {
+		points = bonus[min(s.Current, len(bonus))-1]
+	}
+	if err := addBonus(tx, uid, points); err != nil {
+		return s, 0, err
+	}
+	return s, points, tx.Commit()
+}
+
+func (r *quests) Streak(uid uint64) (Streak, error) {
+	return streak(r.db, uid)
+}
+
+func streak(q querier, uid uint64) (Streak, error) {
+	s := Streak{}
+	err := q.QueryRow("SELECT streak, best, last_day FROM login_streak WHERE user_id = ?", uid).
+		Scan(&s.Current, &s.Best, &s.LastDay)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+	return s, err
+}
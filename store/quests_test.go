@@ -0,0 +1,117 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestQuestsAdvance(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	const day, week = "2026-10-19", "2026-10-19"
+	if err := f.Quests.Assign(alice, []Quest{
+		{Slot: 0, Period: QuestDaily, PeriodStart: day, Kind: QuestCatch, Goal: 2, Bonus: 30},
+		{Slot: 1, Period: QuestDaily, PeriodStart: day, Kind: QuestVisit, TargetID: testTheme, Goal: 1, Bonus: 20},
+		{Slot: 2, Period: QuestDaily, PeriodStart: day, Kind: QuestCatKind, TargetID: 2, Goal: 1, Bonus: 40,
+			RewardItem: ItemRadar, RewardCount: 1},
+		{Slot: 0, Period: QuestWeekly, PeriodStart: week, Kind: QuestTheme, TargetID: testTheme, Goal: 3, Bonus: 100},
+		// yesterday's quest is over
+		{Slot: 0, Period: QuestDaily, PeriodStart: "2026-10-18", Kind: QuestCatch, Goal: 1, Bonus: 30},
+	}); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+
+	// the steps run in order, a catch is recorded before its quests advance
+	steps := []struct {
+		name      string
+		catch     uint64 // the cat caught, or 0 for visiting the theme visit
+		visit     uint64
+		wantKinds []string // of the quests completed, sorted
+		wantScore int      // the weights and the bonus
+	}{
+		{name: "visit another theme", visit: testTheme + 1, wantKinds: []string{}},
+		{name: "visit", visit: testTheme, wantKinds: []string{QuestVisit}, wantScore: 20},
+		{name: "visit again", visit: testTheme, wantKinds: []string{}, wantScore: 20},
+		{name: "first catch", catch: testCat1, wantKinds: []string{}, wantScore: 30},
+		{name: "cat of the kind", catch: testCat2, wantKinds: []string{QuestCatKind, QuestCatch}, wantScore: 120},
+		{name: "whole theme", catch: testCat3, wantKinds: []string{QuestTheme}, wantScore: 250},
+	}
+	for _, step := range steps {
+		var done []Quest
+		var err error
+		if step.visit != 0 {
+			done, err = f.Quests.Visit(alice, day, week, step.visit, 10)
+		} else {
+			f.catch(alice, step.catch)
+			cat, getErr := f.Cats.Get(step.catch)
+			if getErr != nil {
+				t.Fatalf("Get: %v", getErr)
+			}
+			done, err = f.Quests.Advance(alice, day, week, cat.ThemeID, cat.CatKindID, 10)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		kinds := []string{}
+		for _, q := range done {
+			kinds = append(kinds, q.Kind)
+			if q.Completed != 10 {
+				t.Errorf("%s: quest %s completed at %d, want 10", step.name, q.Kind, q.Completed)
+			}
+		}
+		sort.Strings(kinds)
+		if !reflect.DeepEqual(kinds, step.wantKinds) {
+			t.Errorf("%s: completed %v, want %v", step.name, kinds, step.wantKinds)
+		}
+		if got := f.stats(alice); got.Score != step.wantScore {
+			t.Errorf("%s: score = %d, want %d", step.name, got.Score, step.wantScore)
+		}
+	}
+
+	list, err := f.Quests.All(alice)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+	for _, q := range list {
+		if q.PeriodStart == "2026-10-18" && (q.Progress != 0 || q.Completed != 0) {
+			t.Errorf("quest of yesterday = %+v, want no progress", q)
+		}
+	}
+}
+
+func TestQuestsCheckIn(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bonus := []int{5, 10, 15}
+
+	// the steps run in order on the same user
+	steps := []struct {
+		day, yesterday string
+		wantStreak     Streak
+		wantBonus      int
+	}{
+		{"2026-10-19", "2026-10-18", Streak{1, 1, "2026-10-19"}, 5},
+		{"2026-10-19", "2026-10-18", Streak{1, 1, "2026-10-19"}, 0},
+		{"2026-10-20", "2026-10-19", Streak{2, 2, "2026-10-20"}, 10},
+		{"2026-10-21", "2026-10-20", Streak{3, 3, "2026-10-21"}, 15},
+		{"2026-10-22", "2026-10-21", Streak{4, 4, "2026-10-22"}, 15},
+		// a day earlier in a time zone further west
+		{"2026-10-21", "2026-10-20", Streak{4, 4, "2026-10-22"}, 0},
+		{"2026-10-24", "2026-10-23", Streak{1, 4, "2026-10-24"}, 5},
+	}
+	total := 0
+	for _, step := range steps {
+		s, got, err := f.Quests.CheckIn(alice, step.day, step.yesterday, bonus)
+		if err != nil {
+			t.Fatalf("CheckIn %s: %v", step.day, err)
+		}
+		if s != step.wantStreak || got != step.wantBonus {
+			t.Errorf("CheckIn %s = (%+v, %d), want (%+v, %d)", step.day, s, got, step.wantStreak, step.wantBonus)
+		}
+		total += step.wantBonus
+	}
+	if got := f.stats(alice); got.Score != total {
+		t.Errorf("Stats = %+v, want a score of %d", got, total)
+	}
+}
@@ -0,0 +1,264 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// referral statuses
+const (
+	ReferralPending  = "pending"  // waiting for the referee to verify the email and catch a cat
+	ReferralRewarded = "rewarded" // both were rewarded
+	ReferralCapped   = "capped"   // only the referee was rewarded, the referrer reached the cap
+)
+
+// Referral is a row of referral with the name and the profile of the
+// referee. If the referee deleted the account the row is kept, so that the
+// referrer can not refer and be rewarded again, with Referee 0 and the name
+// and the profile "".
+type Referral struct {
+	Referrer   uint64 `json:"referrer"`
+	Referee    uint64 `json:"referee"`
+	Name       string `json:"name"`
+	Profile    string `json:"profile"`
+	Status     string `json:"status"`
+	Creating   int64  `json:"creating"`    // unix time of the registration
+	FirstCatch int64  `json:"first_catch"` // unix time of the first catch, 0 if not yet
+	Rewarded   int64  `json:"rewarded"`    // unix time, 0 if pending
+}
+
+// ReferralReward is what one side of a referral gets.
+type ReferralReward struct {
+	Bonus int
+	Item  string
+	Count int
+}
+
+type Referrals interface {
+	// Code returns the referral code of uid, ErrNotFound if uid has none.
+	Code(uid uint64) (string, error)
+	// SetCode gives uid the code, it returns ErrConflict if uid has a code
+	// already or the code is taken.
+	SetCode(uid uint64, code string) error
+	// Referrer returns the owner of the code, ErrNotFound if there is none.
+	Referrer(code string) (uint64, error)
+	// Count returns how many users referrerID referred since t.
+	Count(referrerID uint64, since int64) (int, error)
+	// Link records that referrerID referred refereeID at t and makes them
+	// friends in both directions. It reports false, changing nothing, if
+	// referrerID referred max users since already.
+	Link(referrerID uint64, refereeID uint64, t int64, since int64, max int) (bool, error)
+	// Caught records a catch of refereeID at t as the first one, unless
+	// there is one already.
+	Caught(refereeID uint64, t int64) error
+	// Reward rewards the pending referral of refereeID at t if the referee
+	// has verified the email and caught a cat: the referee gets referee and
+	// the referrer gets referrer unless maxRewards of its referrals have been
+	// rewarded. It returns the referral, nil if nothing changed.
+	Reward(refereeID uint64, t int64, maxRewards int, referrer ReferralReward, referee ReferralReward) (*Referral, error)
+	// List returns the referrals of referrerID, newest first, including
+	// those of deleted referees.
+	List(referrerID uint64) ([]Referral, error)
+	// ReferredBy returns the referral of refereeID, ErrNotFound if nobody
+	// referred refereeID.
+	ReferredBy(refereeID uint64) (*Referral, error)
+}
+
+type referrals struct {
+	db *conn
+}
+
+func (r *referrals) Code(uid uint64) (string, error) {
+	code := ""
+	err := r.db.QueryRow("SELECT code FROM referral_code WHERE user_id = ?", uid).Scan(&code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return code, err
+}
+
+func (r *referrals) SetCode(uid uint64, code string) error {
+	res, err := r.db.Exec(`
+		INSERT INTO referral_code(user_id, code) values(?, ?)
+		ON CONFLICT DO NOTHING`, uid, code)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *referrals) Referrer(code string) (uint64, error) {
+	uid := uint64(0)
+	err := r.db.QueryRow("SELECT user_id FROM referral_code WHERE code = ?", code).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return uid, err
+}
+
+func (r *referrals) Count(referrerID uint64, since int64) (int, error) {
+	return count(r.db, "SELECT COUNT(*) FROM referral WHERE referrer_id = ? and creating >= ?", referrerID, since)
+}
+
+func (r *referrals) Link(referrerID uint64, refereeID uint64, t int64, since int64, max int) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := lockReferrer(tx, referrerID); err != nil {
+		return false, err
+	}
+	if n, err := count(tx, "SELECT COUNT(*) FROM referral WHERE referrer_id = ? and creating >= ?", referrerID, since); err != nil {
+		return false, err
+	} else if n >= max {
+		return false, nil
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO referral(referee_id, referrer_id, status, creating, first_catch, rewarded)
+		values(?, ?, ?, ?, 0, 0)`, refereeID, referrerID, ReferralPending, t); err != nil {
+		return false, err
+	}
+	// insert referrer -> referee, the referee is new and has no friend yet
+	if _, err := tx.Exec("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)", referrerID, refereeID, true, false); err != nil {
+		return false, err
+	}
+	// insert referee -> referrer
+	if _, err := tx.Exec("INSERT INTO friend(user_id_src, user_id_dest, accepted, ban) values(?, ?, ?, ?)", refereeID, referrerID, true, false); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *referrals) Caught(refereeID uint64, t int64) error {
+	_, err := r.db.Exec("UPDATE referral SET first_catch = ? WHERE referee_id = ? and first_catch = 0", t, refereeID)
+	return err
+}
+
+// lockReferrer serializes the transactions counting the referrals of
+// referrerID until tx ends. The update changes nothing but takes the row
+// lock of referral_code in PostgreSQL, SQLite locks the database anyway.
+func lockReferrer(tx *tx, referrerID uint64) error {
+	_, err := tx.Exec("UPDATE referral_code SET code = code WHERE user_id = ?", referrerID)
+	return err
+}
+
+func (r *referrals) Reward(refereeID uint64, t int64, maxRewards int, referrer ReferralReward, referee ReferralReward) (*Referral, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ref, err := referredBy(tx, refereeID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ref.Status != ReferralPending || ref.FirstCatch == 0 {
+		return nil, nil
+	}
+	if n, err := count(tx, `SELECT COUNT(*) FROM "user" WHERE user_id = ? and verified = TRUE`, refereeID); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	if err := lockReferrer(tx, ref.Referrer); err != nil {
+		return nil, err
+	}
+	rewarded, err := count(tx, "SELECT COUNT(*) FROM referral WHERE referrer_id = ? and status = ?", ref.Referrer, ReferralRewarded)
+	if err != nil {
+		return nil, err
+	}
+	ref.Status = ReferralRewarded
+	if rewarded >= maxRewards {
+		ref.Status = ReferralCapped
+	}
+	ref.Rewarded = t
+	// the status guards against rewarding twice at the same time
+	res, err := tx.Exec("UPDATE referral SET status = ?, rewarded = ? WHERE referee_id = ? and status = ?",
+		ref.Status, ref.Rewarded, refereeID, ReferralPending)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	if err := rewardReferral(tx, refereeID, referee, ref.Referrer, t); err != nil {
+		return nil, err
+	}
+	if ref.Status == ReferralRewarded {
+		if err := rewardReferral(tx, ref.Referrer, referrer, refereeID, t); err != nil {
+			return nil, err
+		}
+	}
+	return ref, tx.Commit()
+}
+
+// rewardReferral gives uid the reward of the referral with otherUID.
+func rewardReferral(tx *tx, uid uint64, reward ReferralReward, otherUID uint64, t int64) error {
+	if err := addBonus(tx, uid, reward.Bonus); err != nil {
+		return err
+	}
+	if reward.Item == "" {
+		return nil
+	}
+	return grantItem(tx, uid, reward.Item, reward.Count, ItemFromReferral, otherUID, t)
+}
+
+const referralColumns = `
+	referral.referrer_id, referral.referee_id, COALESCE("user".name, ''), COALESCE("user".profile, ''),
+	referral.status, referral.creating, referral.first_catch, referral.rewarded`
+
+func scanReferral(row interface{ Scan(...any) error }, ref *Referral) error {
+	return row.Scan(&ref.Referrer, &ref.Referee, &ref.Name, &ref.Profile,
+		&ref.Status, &ref.Creating, &ref.FirstCatch, &ref.Rewarded)
+}
+
+func (r *referrals) List(referrerID uint64) ([]Referral, error) {
+	list := []Referral{}
+	rows, err := r.db.Query(`
+		SELECT `+referralColumns+`
+		FROM referral LEFT JOIN "user" ON "user".user_id = referral.referee_id
+		WHERE referral.referrer_id = ?
+		ORDER BY referral.creating DESC, referral.referee_id`, referrerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ref := Referral{}
+		if err := scanReferral(rows, &ref); err != nil {
+			return nil, err
+		}
+		list = append(list, ref)
+	}
+	return list, rows.Err()
+}
+
+func (r *referrals) ReferredBy(refereeID uint64) (*Referral, error) {
+	return referredBy(r.db, refereeID)
+}
+
+func referredBy(q querier, refereeID uint64) (*Referral, error) {
+	ref := &Referral{}
+	err := scanReferral(q.QueryRow(`
+		SELECT `+referralColumns+`
+		FROM referral LEFT JOIN "user" ON "user".user_id = referral.referee_id
+		WHERE referral.referee_id = ?`, refereeID), ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return ref, err
+}
@@ -0,0 +1,99 @@
+package store
+
+import "testing"
+
+func TestReferralsReward(t *testing.T) {
+	f := newFixture(t)
+	bob := f.newUser("bob")
+	if err := f.Referrals.SetCode(bob, "BOBCODE1"); err != nil {
+		t.Fatalf("SetCode: %v", err)
+	}
+	referrer := ReferralReward{Bonus: 100, Item: ItemRadar, Count: 2}
+	referee := ReferralReward{Bonus: 50, Item: ItemBait, Count: 2}
+	const maxRewards = 1
+
+	link := func(name string) uint64 {
+		t.Helper()
+		uid := f.newUser(name)
+		if ok, err := f.Referrals.Link(bob, uid, 1, 0, 5); err != nil || !ok {
+			t.Fatalf("Link = (%v, %v), want (true, nil)", ok, err)
+		}
+		return uid
+	}
+	verify := func(uid uint64) {
+		t.Helper()
+		u, err := f.Users.Get(uid)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if err := f.Users.AddVerifyEmail(uid, u.Email, u.Name, 100); err != nil {
+			t.Fatalf("AddVerifyEmail: %v", err)
+		}
+		if _, err := f.Users.Verify(u.Name, 2); err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+	}
+	alice := link("alice")
+	carol := link("carol")
+
+	// the steps run in order, a referral is rewarded once its referee has
+	// both verified the email and caught a cat
+	steps := []struct {
+		name       string
+		uid        uint64
+		verify     bool
+		catch      bool
+		wantStatus string // "" if nothing changes
+	}{
+		{name: "catch unverified", uid: alice, catch: true},
+		{name: "verify after the catch", uid: alice, verify: true, wantStatus: ReferralRewarded},
+		{name: "rewarded once", uid: alice, catch: true},
+		{name: "verify without a catch", uid: carol, verify: true},
+		{name: "catch over the cap", uid: carol, catch: true, wantStatus: ReferralCapped},
+	}
+	for _, step := range steps {
+		if step.verify {
+			verify(step.uid)
+		}
+		if step.catch {
+			if err := f.Referrals.Caught(step.uid, 3); err != nil {
+				t.Fatalf("%s: Caught: %v", step.name, err)
+			}
+		}
+		ref, err := f.Referrals.Reward(step.uid, 4, maxRewards, referrer, referee)
+		if err != nil {
+			t.Fatalf("%s: Reward: %v", step.name, err)
+		}
+		if got := ""; ref != nil {
+			got = ref.Status
+			if got != step.wantStatus || ref.Referrer != bob || ref.Rewarded != 4 {
+				t.Errorf("%s: Reward = %+v, want %q", step.name, ref, step.wantStatus)
+			}
+		} else if step.wantStatus != "" {
+			t.Errorf("%s: Reward = nil, want %q", step.name, step.wantStatus)
+		}
+	}
+
+	// the referrer is rewarded for alice only, over the cap
+	tests := []struct {
+		name      string
+		uid       uint64
+		wantScore int
+		wantItem  string
+	}{
+		{"bob", bob, 100, ItemRadar},
+		{"alice", alice, 50, ItemBait},
+		{"carol", carol, 50, ItemBait},
+	}
+	for _, tt := range tests {
+		if got := f.stats(tt.uid).Score; got != tt.wantScore {
+			t.Errorf("score of %s = %d, want %d", tt.name, got, tt.wantScore)
+		}
+		if got := f.quantity(tt.uid, tt.wantItem); got != 2 {
+			t.Errorf("%s of %s = %d, want 2", tt.wantItem, tt.name, got)
+		}
+	}
+	if ref, err := f.Referrals.ReferredBy(alice); err != nil || ref.FirstCatch != 3 {
+		t.Errorf("ReferredBy = (%+v, %v), want the first catch at 3", ref, err)
+	}
+}
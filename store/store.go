@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrNotFound = errors.New("store: not found")
+	ErrConflict = errors.New("store: conflict")
+)
+
+// Store holds the repositories. Handlers depend on the interfaces only, so
+// the business rules can be exercised against OpenMemory.
+type Store struct {
+	db *conn
+
+	Users         Users
+	Friends       Friends
+	Cats          Cats
+	Themes        Themes
+	Audits        Audits
+	Trades        Trades
+	Activities    Activities
+	Devices       Devices
+	Outbox        Outbox
+	Notifications Notifications
+	Messages      Messages
+	Teams         Teams
+	Challenges    Challenges
+	Quests        Quests
+	Items         Items
+	Referrals     Referrals
+}
+
+// Open opens the database and migrates it to the latest schema. driver is
+// "sqlite3" or "postgres", source is the file path or the connection string.
+func Open(driver string, source string) (*Store, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, fmt.Errorf("can not connect to database: %w", err)
+	}
+	return newStore(&conn{db, d})
+}
+
+var memoryID atomic.Int64
+
+// OpenMemory opens an empty in-memory database, every call gets its own one.
+func OpenMemory() (*Store, error) {
+	dsn := fmt.Sprintf("file:memory%d?mode=memory&cache=shared", memoryID.Add(1))
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(&conn{db, sqlite{}})
+}
+
+func newStore(db *conn) (*Store, error) {
+	if err := db.dialect.check(db.DB); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{
+		db:            db,
+		Users:         &users{db},
+		Friends:       &friends{db},
+		Cats:          &cats{db},
+		Themes:        &themes{db},
+		Audits:        &audits{db},
+		Trades:        &trades{db},
+		Activities:    &activities{db},
+		Devices:       &devices{db},
+		Outbox:        &outbox{db},
+		Notifications: &notifications{db},
+		Messages:      &messages{db},
+		Teams:         &teams{db},
+		Challenges:    &challenges{db},
+		Quests:        &quests{db},
+		Items:         &items{db},
+		Referrals:     &referrals{db},
+	}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// querier is a conn or a tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// execer is a conn or a tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// count runs a SELECT COUNT(*) query.
+func count(db querier, query string, args ...any) (int, error) {
+	var n int
+	err := db.QueryRow(query, args...).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"testing"
+)
+
+// fixture is an empty store with one theme of three cats, weighing 10,
+// 20 and 30, and the users created by newUser.
+type fixture struct {
+	t *testing.T
+	*Store
+}
+
+const (
+	testTheme = 1
+	testCat1  = 1
+	testCat2  = 2
+	testCat3  = 3
+)
+
+// openTest opens the empty database of a fixture, TestPostgres swaps it.
+var openTest = func(t *testing.T) (*Store, error) {
+	return OpenMemory()
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	s, err := openTest(t)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if err := s.Themes.Save(Theme{ThemeID: testTheme, Name: "theme"}); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	for i, weight := range []int{10, 20, 30} {
+		if err := s.Themes.SaveKind(CatKind{CatKindID: uint64(i + 1), Name: "kind", Weight: weight}); err != nil {
+			t.Fatalf("SaveKind: %v", err)
+		}
+		cat := Cat{CatID: uint64(i + 1), ThemeID: testTheme, CatKind: CatKind{CatKindID: uint64(i + 1)}}
+		if err := s.Themes.AddCat(cat); err != nil {
+			t.Fatalf("AddCat: %v", err)
+		}
+	}
+	return &fixture{t, s}
+}
+
+func (f *fixture) newUser(name string) uint64 {
+	f.t.Helper()
+	u := &User{Name: name, Email: name + "@example.com"}
+	if err := f.Users.Create(u); err != nil {
+		f.t.Fatalf("Create %s: %v", name, err)
+	}
+	return u.UID
+}
+
+func (f *fixture) befriend(a uint64, b uint64) {
+	f.t.Helper()
+	if err := f.Friends.Insert(Relation{Src: a, Dest: b}); err != nil {
+		f.t.Fatalf("Insert friend: %v", err)
+	}
+	if err := f.Friends.Accept(b, a); err != nil {
+		f.t.Fatalf("Accept friend: %v", err)
+	}
+}
+
+func (f *fixture) catch(uid uint64, catIDs ...uint64) {
+	f.t.Helper()
+	for _, catID := range catIDs {
+		if _, _, _, err := f.Cats.Catch(uid, catID, 1, Stack{}, Boost{}); err != nil {
+			f.t.Fatalf("Catch %d: %v", catID, err)
+		}
+	}
+}
+
+func (f *fixture) stats(uid uint64) Stats {
+	f.t.Helper()
+	s, err := f.Users.Stats(uid)
+	if err != nil {
+		f.t.Fatalf("Stats: %v", err)
+	}
+	return s
+}
+
+func (f *fixture) quantity(uid uint64, itemID string) int {
+	f.t.Helper()
+	list, err := f.Items.Inventory(uid)
+	if err != nil {
+		f.t.Fatalf("Inventory: %v", err)
+	}
+	for _, s := range list {
+		if s.ItemID == itemID {
+			return s.Quantity
+		}
+	}
+	return 0
+}
+
+// owns reports whether uid has the cat in user_cat.
+func (f *fixture) owns(uid uint64, catID uint64) bool {
+	f.t.Helper()
+	list, err := f.Cats.History(uid)
+	if err != nil {
+		f.t.Fatalf("History: %v", err)
+	}
+	for _, c := range list {
+		if c.CatID == catID {
+			return true
+		}
+	}
+	return false
+}
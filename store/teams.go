@@ -0,0 +1,396 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// team roles
+const (
+	TeamOwner   = "owner"
+	TeamOfficer = "officer" // invites and removes members
+	TeamMember  = "member"
+)
+
+// Team is a row of team. Members and Score are aggregated from team_member
+// and user_stats; Score is the sum of the weights of the cats caught by the
+// members, the bonus points of quests and items do not count.
+type Team struct {
+	TeamID      uint64 `json:"team_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OwnerID     uint64 `json:"owner_uid"`
+	Creating    int64  `json:"creating"`
+	Members     int    `json:"members"`
+	Score       int    `json:"score"`
+}
+
+// Membership is a row of team_member joined with the user, Score is the
+// weights of the cats the member caught.
+type Membership struct {
+	TeamID  uint64 `json:"team_id"`
+	UID     uint64 `json:"uid"`
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+	Role    string `json:"role"`
+	Joined  int64  `json:"joined"`
+	Score   int    `json:"score"`
+}
+
+// TeamInvitation is a row of team_invite joined with the team.
+type TeamInvitation struct {
+	TeamID   uint64 `json:"team_id"`
+	Name     string `json:"name"` // of the team
+	Inviter  uint64 `json:"inviter_uid"`
+	Creating int64  `json:"creating"`
+}
+
+// TeamRank is a team of a leaderboard, Score is the sum of the weights of the
+// cats caught by the members, in a theme or in every theme.
+type TeamRank struct {
+	TeamID  uint64 `json:"team_id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	Score   int    `json:"score"`
+}
+
+type Teams interface {
+	// Create inserts t with t.OwnerID as the owner and sets TeamID. It
+	// returns ErrConflict if the owner is already in a team.
+	Create(t *Team) error
+	// Get returns ErrNotFound if there is no such team.
+	Get(teamID uint64) (*Team, error)
+	// Members returns the members of the team, highest score first.
+	Members(teamID uint64) ([]Membership, error)
+	// Membership returns the team of uid, or ErrNotFound.
+	Membership(uid uint64) (*Membership, error)
+
+	// Invite invites uid to the team, inviting again is not an error.
+	Invite(teamID uint64, uid uint64, inviter uint64, t int64) error
+	// Invitations returns the teams inviting uid.
+	Invitations(uid uint64) ([]TeamInvitation, error)
+	// Join accepts the invitation of the team and drops the other
+	// invitations of uid. It returns ErrNotFound if uid is not invited, and
+	// ErrConflict if uid is already in a team.
+	Join(teamID uint64, uid uint64, t int64) error
+	// Decline deletes the invitation, it returns ErrNotFound if there is none.
+	Decline(teamID uint64, uid uint64) error
+
+	// Leave removes uid from its team. The officer, or else the member, who
+	// joined first succeeds an owner; a team without members is deleted.
+	// It returns ErrNotFound if uid is not in the team.
+	Leave(teamID uint64, uid uint64) error
+	// SetRole sets the role of a member; making a member the owner makes the
+	// former owner an officer. It returns ErrNotFound if uid is not in the
+	// team.
+	SetRole(teamID uint64, uid uint64, role string) error
+
+	// Ranking returns the teams with the highest score in the theme, or in
+	// every theme if themeID is 0.
+	Ranking(themeID uint64, limit int) ([]TeamRank, error)
+}
+
+type teams struct {
+	db *conn
+}
+
+func (r *teams) Create(t *Team) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if n, err := count(tx, "SELECT COUNT(*) FROM team_member WHERE user_id = ?", t.OwnerID); err != nil {
+		return err
+	} else if n != 0 {
+		return ErrConflict
+	}
+	if err := tx.QueryRow(`
+		INSERT INTO team(name, description, owner_id, creating)
+		values(?, ?, ?, ?) RETURNING team_id`,
+		t.Name, t.Description, t.OwnerID, t.Creating).Scan(&t.TeamID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO team_member(user_id, team_id, role, joined)
+		values(?, ?, ?, ?)`, t.OwnerID, t.TeamID, TeamOwner, t.Creating); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM team_invite WHERE user_id = ?", t.OwnerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *teams) Get(teamID uint64) (*Team, error) {
+	t := &Team{}
+	err := r.db.QueryRow(`
+		SELECT team.team_id, team.name, team.description, team.owner_id, team.creating,
+		       COUNT(team_member.user_id), COALESCE(SUM(user_stats.score - user_stats.bonus), 0)
+		FROM team
+		JOIN team_member ON team_member.team_id = team.team_id
+		LEFT JOIN user_stats ON user_stats.user_id = team_member.user_id
+		WHERE team.team_id = ?
+		GROUP BY team.team_id, team.name, team.description, team.owner_id, team.creating`,
+		teamID).Scan(&t.TeamID, &t.Name, &t.Description, &t.OwnerID, &t.Creating, &t.Members, &t.Score)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+func (r *teams) Members(teamID uint64) ([]Membership, error) {
+	return r.memberships("team_member.team_id = ?", teamID)
+}
+
+func (r *teams) Membership(uid uint64) (*Membership, error) {
+	list, err := r.memberships("team_member.user_id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return &list[0], nil
+}
+
+func (r *teams) memberships(where string, args ...any) ([]Membership, error) {
+	list := []Membership{}
+	rows, err := r.db.Query(`
+		SELECT team_member.team_id, team_member.user_id, "user".name, "user".profile,
+		       team_member.role, team_member.joined, COALESCE(user_stats.score - user_stats.bonus, 0) as score
+		FROM team_member
+		JOIN "user" ON "user".user_id = team_member.user_id
+		LEFT JOIN user_stats ON user_stats.user_id = team_member.user_id
+		WHERE `+where+`
+		ORDER BY score DESC, team_member.joined`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m := Membership{}
+		if err := rows.Scan(&m.TeamID, &m.UID, &m.Name, &m.Profile, &m.Role, &m.Joined, &m.Score); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+func (r *teams) Invite(teamID uint64, uid uint64, inviter uint64, t int64) error {
+	_, err := r.db.Exec(`
+		INSERT INTO team_invite(team_id, user_id, inviter_id, creating)
+		values(?, ?, ?, ?)
+		ON CONFLICT (team_id, user_id) DO NOTHING`, teamID, uid, inviter, t)
+	return err
+}
+
+func (r *teams) Invitations(uid uint64) ([]TeamInvitation, error) {
+	list := []TeamInvitation{}
+	rows, err := r.db.Query(`
+		SELECT team.team_id, team.name, team_invite.inviter_id, team_invite.creating
+		FROM team_invite
+		JOIN team ON team.team_id = team_invite.team_id
+		WHERE team_invite.user_id = ?
+		ORDER BY team_invite.creating DESC`, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		inv := TeamInvitation{}
+		if err := rows.Scan(&inv.TeamID, &inv.Name, &inv.Inviter, &inv.Creating); err != nil {
+			return nil, err
+		}
+		list = append(list, inv)
+	}
+	return list, rows.Err()
+}
+
+func (r *teams) Join(teamID uint64, uid uint64, t int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM team_invite WHERE team_id = ? and user_id = ?", teamID, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if n, err := count(tx, "SELECT COUNT(*) FROM team_member WHERE user_id = ?", uid); err != nil {
+		return err
+	} else if n != 0 {
+		return ErrConflict
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO team_member(user_id, team_id, role, joined)
+		values(?, ?, ?, ?)`, uid, teamID, TeamMember, t); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM team_invite WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *teams) Decline(teamID uint64, uid uint64) error {
+	res, err := r.db.Exec("DELETE FROM team_invite WHERE team_id = ? and user_id = ?", teamID, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *teams) Leave(teamID uint64, uid uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if n, err := count(tx, "SELECT COUNT(*) FROM team_member WHERE team_id = ? and user_id = ?", teamID, uid); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if err := leaveTeam(tx, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// leaveTeam removes uid from its team, if any, as Teams.Leave.
+func leaveTeam(tx *tx, uid uint64) error {
+	var teamID uint64
+	var role string
+	err := tx.QueryRow("SELECT team_id, role FROM team_member WHERE user_id = ?", uid).Scan(&teamID, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM team_member WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if role != TeamOwner {
+		return nil
+	}
+
+	var successor uint64
+	err = tx.QueryRow(`
+		SELECT user_id FROM team_member WHERE team_id = ?
+		ORDER BY CASE WHEN role = ? THEN 0 ELSE 1 END, joined, user_id
+		LIMIT 1`, teamID, TeamOfficer).Scan(&successor)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the last member left
+		if _, err := tx.Exec("DELETE FROM team_invite WHERE team_id = ?", teamID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM team WHERE team_id = ?", teamID)
+		return err
+	} else if err != nil {
+		return err
+	}
+	return setOwner(tx, teamID, successor)
+}
+
+// setOwner makes uid the owner of the team and the former owner an officer.
+func setOwner(tx *tx, teamID uint64, uid uint64) error {
+	if _, err := tx.Exec("UPDATE team_member SET role = ? WHERE team_id = ? and role = ?",
+		TeamOfficer, teamID, TeamOwner); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE team_member SET role = ? WHERE team_id = ? and user_id = ?",
+		TeamOwner, teamID, uid); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE team SET owner_id = ? WHERE team_id = ?", uid, teamID)
+	return err
+}
+
+func (r *teams) SetRole(teamID uint64, uid uint64, role string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if n, err := count(tx, "SELECT COUNT(*) FROM team_member WHERE team_id = ? and user_id = ?", teamID, uid); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	if role == TeamOwner {
+		err = setOwner(tx, teamID, uid)
+	} else {
+		_, err = tx.Exec("UPDATE team_member SET role = ? WHERE team_id = ? and user_id = ?", role, teamID, uid)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *teams) Ranking(themeID uint64, limit int) ([]TeamRank, error) {
+	// the weights of every theme are the score of user_stats without the
+	// bonus, the weights of a theme are summed as Friends.ThemeRank
+	query := `
+		SELECT team.team_id, team.name, COUNT(team_member.user_id),
+		       COALESCE(SUM(user_stats.score - user_stats.bonus), 0) as score
+		FROM team
+		JOIN team_member ON team_member.team_id = team.team_id
+		LEFT JOIN user_stats ON user_stats.user_id = team_member.user_id
+		GROUP BY team.team_id, team.name
+		ORDER BY score DESC, team.team_id
+		LIMIT ?`
+	args := []any{limit}
+	if themeID != 0 {
+		query = `
+		SELECT team.team_id, team.name, COUNT(DISTINCT team_member.user_id),
+		       COALESCE(SUM(tb.weight), 0) as score
+		FROM team
+		JOIN team_member ON team_member.team_id = team.team_id
+		LEFT JOIN
+			(
+				SELECT user_cat.user_id, cat_kind.weight
+				FROM user_cat, cat, cat_kind
+				WHERE
+					user_cat.cat_id = cat.cat_id and
+					cat.cat_kind_id = cat_kind.cat_kind_id and
+					cat.theme_id = ?
+			) as tb
+			ON tb.user_id = team_member.user_id
+		GROUP BY team.team_id, team.name
+		ORDER BY score DESC, team.team_id
+		LIMIT ?`
+		args = []any{themeID, limit}
+	}
+
+	list := []TeamRank{}
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := TeamRank{}
+		if err := rows.Scan(&t.TeamID, &t.Name, &t.Members, &t.Score); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
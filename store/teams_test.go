@@ -0,0 +1,150 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTeamsScore(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	carol := f.newUser("carol")
+	red := &Team{Name: "red", OwnerID: alice, Creating: 1}
+	blue := &Team{Name: "blue", OwnerID: carol, Creating: 1}
+	for _, team := range []*Team{red, blue} {
+		if err := f.Teams.Create(team); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+	if err := f.Teams.Invite(red.TeamID, bob, alice, 2); err != nil {
+		t.Fatalf("Invite: %v", err)
+	}
+	if err := f.Teams.Join(red.TeamID, bob, 3); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	f.catch(alice, testCat1)
+	f.catch(bob, testCat2)
+	f.catch(carol, testCat3)
+
+	// bonus points do not count for a team
+	tx, err := f.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := addBonus(tx, carol, 100); err != nil {
+		t.Fatalf("addBonus: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if got, err := f.Teams.Get(red.TeamID); err != nil {
+		t.Fatalf("Get: %v", err)
+	} else if got.Members != 2 || got.Score != 30 {
+		t.Errorf("red = %d members and %d points, want 2 and 30", got.Members, got.Score)
+	}
+	if got, err := f.Teams.Get(blue.TeamID); err != nil {
+		t.Fatalf("Get: %v", err)
+	} else if got.Score != 30 {
+		t.Errorf("blue = %d points, want 30", got.Score)
+	}
+	if got, err := f.Teams.Membership(carol); err != nil {
+		t.Fatalf("Membership: %v", err)
+	} else if got.Score != 30 {
+		t.Errorf("score of carol = %d, want 30", got.Score)
+	}
+
+	for _, themeID := range []uint64{0, testTheme} {
+		list, err := f.Teams.Ranking(themeID, 10)
+		if err != nil {
+			t.Fatalf("Ranking: %v", err)
+		}
+		want := []TeamRank{{red.TeamID, "red", 2, 30}, {blue.TeamID, "blue", 1, 30}}
+		if !reflect.DeepEqual(list, want) {
+			t.Errorf("Ranking(%d) = %+v, want %+v", themeID, list, want)
+		}
+	}
+}
+
+func TestTeamsLeave(t *testing.T) {
+	f := newFixture(t)
+	uid := map[string]uint64{}
+	for _, name := range []string{"owner", "member", "officer", "late"} {
+		uid[name] = f.newUser(name)
+	}
+	team := &Team{Name: "team", OwnerID: uid["owner"], Creating: 1}
+	if err := f.Teams.Create(team); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for i, name := range []string{"member", "officer", "late"} {
+		if err := f.Teams.Invite(team.TeamID, uid[name], uid["owner"], 1); err != nil {
+			t.Fatalf("Invite: %v", err)
+		}
+		if err := f.Teams.Join(team.TeamID, uid[name], int64(i+2)); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	}
+	if err := f.Teams.SetRole(team.TeamID, uid["officer"], TeamOfficer); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+
+	roles := func() map[string]string {
+		t.Helper()
+		list, err := f.Teams.Members(team.TeamID)
+		if err != nil {
+			t.Fatalf("Members: %v", err)
+		}
+		got := map[string]string{}
+		for _, m := range list {
+			got[m.Name] = m.Role
+		}
+		return got
+	}
+	// the steps run in order on the same team
+	steps := []struct {
+		name    string
+		leave   string // who leaves, or becomes the owner if set
+		owner   bool
+		wantErr error
+		want    map[string]string
+	}{
+		{name: "hand over", leave: "late", owner: true,
+			want: map[string]string{"owner": TeamOfficer, "member": TeamMember, "officer": TeamOfficer, "late": TeamOwner}},
+		{name: "officer joined first succeeds", leave: "late",
+			want: map[string]string{"owner": TeamOwner, "member": TeamMember, "officer": TeamOfficer}},
+		{name: "member leaves", leave: "member",
+			want: map[string]string{"owner": TeamOwner, "officer": TeamOfficer}},
+		{name: "not a member", leave: "member", wantErr: ErrNotFound,
+			want: map[string]string{"owner": TeamOwner, "officer": TeamOfficer}},
+		{name: "officer succeeds", leave: "owner",
+			want: map[string]string{"officer": TeamOwner}},
+		{name: "last member", leave: "officer", want: map[string]string{}},
+	}
+	for _, step := range steps {
+		var err error
+		if step.owner {
+			err = f.Teams.SetRole(team.TeamID, uid[step.leave], TeamOwner)
+		} else {
+			err = f.Teams.Leave(team.TeamID, uid[step.leave])
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+		}
+		if got := roles(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: roles = %v, want %v", step.name, got, step.want)
+		}
+		for name, role := range step.want {
+			if role != TeamOwner {
+				continue
+			}
+			if got, err := f.Teams.Get(team.TeamID); err != nil || got.OwnerID != uid[name] {
+				t.Errorf("%s: Get = (%+v, %v), want the owner %s", step.name, got, err, name)
+			}
+		}
+	}
+	if _, err := f.Teams.Get(team.TeamID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a team without members: err = %v, want ErrNotFound", err)
+	}
+}
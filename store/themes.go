@@ -0,0 +1,163 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+)
+
+type Theme struct {
+	ThemeID     int    `json:"theme_id"`
+	Name        string `json:"name"`
+	Thumbnail   string `json:"thumbnail"`
+	Description string `json:"description"`
+	Starts      int64  `json:"starts"`      // unix time, 0 if unbounded
+	Ends        int64  `json:"ends"`        // unix time, 0 if unbounded
+	Recurrence  string `json:"recurrence"`  // when it is live within the window, see cats/schedule.go
+	ComingSoon  bool   `json:"coming_soon"` // listed while it is not live yet
+	Available   bool   `json:"available"`   // live now, set by the handlers
+}
+
+// Themes is the catalogue of themes and cat kinds, which is changed only by
+// the admin.
+type Themes interface {
+	// List returns every theme, whether live or not.
+	List() ([]Theme, error)
+	// Get returns ErrNotFound if there is no such theme.
+	Get(themeID uint64) (Theme, error)
+	// Save creates or replaces the theme with t.ThemeID.
+	Save(t Theme) error
+	Kinds() ([]CatKind, error)
+	// SaveKind creates or replaces the kind with k.CatKindID. The scores in
+	// user_stats follow a change of the weight.
+	SaveKind(k CatKind) error
+	// AddCat places the cat c.CatID of c.CatKindID at c.Lat, c.Lng in
+	// c.ThemeID. It returns ErrConflict if the id is taken.
+	AddCat(c Cat) error
+}
+
+type themes struct {
+	db *conn
+}
+
+func (r *themes) List() ([]Theme, error) {
+	list := []Theme{}
+
+	rows, err := r.db.Query("SELECT " + themeColumns + " FROM theme ORDER BY theme_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		theme := Theme{}
+		if err := rows.Scan(theme.fields()...); err != nil {
+			return nil, err
+		}
+		list = append(list, theme)
+	}
+	return list, rows.Err()
+}
+
+const themeColumns = "theme_id, name, thumbnail, description, starts, ends, recurrence, coming_soon"
+
+func (t *Theme) fields() []any {
+	return []any{&t.ThemeID, &t.Name, &t.Thumbnail, &t.Description, &t.Starts, &t.Ends, &t.Recurrence, &t.ComingSoon}
+}
+
+func (r *themes) Get(themeID uint64) (Theme, error) {
+	theme := Theme{}
+	err := r.db.QueryRow("SELECT "+themeColumns+" FROM theme WHERE theme_id = ?", themeID).Scan(theme.fields()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return theme, ErrNotFound
+	}
+	return theme, err
+}
+
+func (r *themes) Save(t Theme) error {
+	_, err := r.db.Exec(`
+		INSERT INTO theme(`+themeColumns+`) values(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (theme_id) DO UPDATE SET
+			name = excluded.name,
+			thumbnail = excluded.thumbnail,
+			description = excluded.description,
+			starts = excluded.starts,
+			ends = excluded.ends,
+			recurrence = excluded.recurrence,
+			coming_soon = excluded.coming_soon`,
+		t.ThemeID, t.Name, t.Thumbnail, t.Description, t.Starts, t.Ends, t.Recurrence, t.ComingSoon)
+	return err
+}
+
+func (r *themes) Kinds() ([]CatKind, error) {
+	list := []CatKind{}
+
+	rows, err := r.db.Query(`
+		SELECT cat_kind_id, weight, thumbnail, name, description
+		FROM cat_kind ORDER BY cat_kind_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		kind := CatKind{}
+		if err := rows.Scan(&kind.CatKindID, &kind.Weight, &kind.Thumbnail, &kind.Name, &kind.Description); err != nil {
+			return nil, err
+		}
+		list = append(list, kind)
+	}
+	return list, rows.Err()
+}
+
+func (r *themes) SaveKind(k CatKind) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old int
+	err = tx.QueryRow("SELECT weight FROM cat_kind WHERE cat_kind_id = ?", k.CatKindID).Scan(&old)
+	if errors.Is(err, sql.ErrNoRows) {
+		old = k.Weight
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO cat_kind(cat_kind_id, weight, thumbnail, name, description) values(?, ?, ?, ?, ?)
+		ON CONFLICT (cat_kind_id) DO UPDATE SET
+			weight = excluded.weight,
+			thumbnail = excluded.thumbnail,
+			name = excluded.name,
+			description = excluded.description`,
+		k.CatKindID, k.Weight, k.Thumbnail, k.Name, k.Description); err != nil {
+		return err
+	}
+
+	// every caught cat of the kind scores the new weight from now on
+	if delta := k.Weight - old; delta != 0 {
+		if _, err := tx.Exec(`
+			UPDATE user_stats SET score = score + ? * (
+				SELECT COUNT(*) FROM user_cat
+				JOIN cat ON user_cat.cat_id = cat.cat_id
+				WHERE user_cat.user_id = user_stats.user_id and cat.cat_kind_id = ?
+			)`, delta, k.CatKindID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *themes) AddCat(c Cat) error {
+	res, err := r.db.Exec(`
+		INSERT INTO cat(cat_id, cat_kind_id, lng, lat, theme_id) values(?, ?, ?, ?, ?)
+		ON CONFLICT (cat_id) DO NOTHING`, c.CatID, c.CatKindID, c.Lng, c.Lat, c.ThemeID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
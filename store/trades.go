@@ -0,0 +1,349 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// trade status
+const (
+	TradePending   = "pending"
+	TradeAccepted  = "accepted"
+	TradeDeclined  = "declined"  // by Dest
+	TradeCancelled = "cancelled" // by Src
+)
+
+// Trade is a row of trade with its cats. Src offers Give to Dest for Want.
+type Trade struct {
+	TradeID  uint64 `json:"trade_id"`
+	Src      uint64 `json:"src_uid"`
+	Dest     uint64 `json:"dest_uid"`
+	Status   string `json:"status"`
+	Creating int64  `json:"creating"`
+	Updating int64  `json:"updating"`
+	Give     []Cat  `json:"give"` // cats of Src, IsCaught is not filled
+	Want     []Cat  `json:"want"` // cats of Dest, IsCaught is not filled
+}
+
+// TradeFilter selects the trades from or to UID, newest first; Before is the
+// trade_id to continue from.
+type TradeFilter struct {
+	UID    uint64
+	Status string // "" for every status
+	Before uint64
+	Limit  int
+}
+
+type Trades interface {
+	// Create inserts a pending trade of the cats in Give and Want, only their
+	// CatID is used, and sets TradeID. It returns ErrConflict if a cat is not
+	// owned by its giver or is already owned by its receiver.
+	Create(t *Trade) error
+	// Get returns ErrNotFound if there is no such trade.
+	Get(tradeID uint64) (*Trade, error)
+	List(f TradeFilter) ([]Trade, error)
+	// Accept swaps the cats of a pending trade and recomputes the scores of
+	// both users. A user completing a theme for the first time gets reward.
+	// It returns the completed themes, ErrNotFound if the trade is not
+	// pending, and ErrConflict if the users are no longer friends, a cat is
+	// no longer owned by its giver or is already owned by its receiver.
+	Accept(tradeID uint64, t int64, reward Stack) ([]ThemeCompleted, error)
+	// Close sets the status of a pending trade, it returns ErrNotFound if the
+	// trade is not pending.
+	Close(tradeID uint64, status string, t int64) error
+}
+
+// ThemeCompleted is a theme a user completed for the first time.
+type ThemeCompleted struct {
+	UID     uint64
+	ThemeID uint64
+}
+
+type trades struct {
+	db *conn
+}
+
+func (r *trades) Create(t *Trade) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t.Status = TradePending
+	if err := tx.QueryRow(`
+		INSERT INTO trade(user_id_src, user_id_dest, status, creating, updating)
+		values(?, ?, ?, ?, ?) RETURNING trade_id`,
+		t.Src, t.Dest, t.Status, t.Creating, t.Updating).Scan(&t.TradeID); err != nil {
+		return err
+	}
+	for _, side := range []struct {
+		giver uint64
+		cats  []Cat
+	}{{t.Src, t.Give}, {t.Dest, t.Want}} {
+		for _, cat := range side.cats {
+			if _, err := tx.Exec("INSERT INTO trade_cat(trade_id, cat_id, giver) values(?, ?, ?)",
+				t.TradeID, cat.CatID, side.giver); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkOwners(tx, t.TradeID, t.Src, t.Dest); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *trades) Get(tradeID uint64) (*Trade, error) {
+	list, err := r.query("trade_id = ?", []any{tradeID}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	return &list[0], nil
+}
+
+func (r *trades) List(f TradeFilter) ([]Trade, error) {
+	where := []string{"(user_id_src = ? or user_id_dest = ?)"}
+	args := []any{f.UID, f.UID}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Before != 0 {
+		where = append(where, "trade_id < ?")
+		args = append(args, f.Before)
+	}
+	return r.query(strings.Join(where, " and "), args, f.Limit)
+}
+
+// query returns the trades matching where with their cats, in two queries.
+func (r *trades) query(where string, args []any, limit int) ([]Trade, error) {
+	list := []Trade{}
+	index := map[uint64]int{}
+
+	rows, err := r.db.Query(`
+		SELECT trade_id, user_id_src, user_id_dest, status, creating, updating
+		FROM trade
+		WHERE `+where+`
+		ORDER BY trade_id DESC
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := Trade{Give: []Cat{}, Want: []Cat{}}
+		if err := rows.Scan(&t.TradeID, &t.Src, &t.Dest, &t.Status, &t.Creating, &t.Updating); err != nil {
+			return nil, err
+		}
+		index[t.TradeID] = len(list)
+		list = append(list, t)
+	}
+	if err := rows.Err(); err != nil || len(list) == 0 {
+		return list, err
+	}
+
+	ids := make([]any, len(list))
+	for i, t := range list {
+		ids[i] = t.TradeID
+	}
+	rows, err = r.db.Query(`
+		SELECT trade_cat.trade_id, trade_cat.giver,
+		       cat.cat_id, cat.theme_id, cat.cat_kind_id, cat.lng, cat.lat,
+		       cat_kind.thumbnail, cat_kind.weight,
+		       cat_kind.description, cat_kind.name
+		FROM trade_cat
+		JOIN cat ON cat.cat_id = trade_cat.cat_id
+		JOIN cat_kind ON cat_kind.cat_kind_id = cat.cat_kind_id
+		WHERE trade_cat.trade_id IN (`+placeholders(len(ids))+`)
+		ORDER BY trade_cat.cat_id`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tradeID, giver uint64
+		cat := Cat{}
+		if err := rows.Scan(&tradeID, &giver, &cat.CatID, &cat.ThemeID, &cat.CatKindID, &cat.Lng, &cat.Lat,
+			&cat.Thumbnail, &cat.Weight, &cat.Description, &cat.Name); err != nil {
+			return nil, err
+		}
+		t := &list[index[tradeID]]
+		if giver == t.Src {
+			t.Give = append(t.Give, cat)
+		} else {
+			t.Want = append(t.Want, cat)
+		}
+	}
+	return list, rows.Err()
+}
+
+func (r *trades) Accept(tradeID uint64, t int64, reward Stack) ([]ThemeCompleted, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var src, dest uint64
+	err = tx.QueryRow(`
+		UPDATE trade SET status = ?, updating = ?
+		WHERE trade_id = ? and status = ?
+		RETURNING user_id_src, user_id_dest`,
+		TradeAccepted, t, tradeID, TradePending).Scan(&src, &dest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// as Friends.AreFriends, within the transaction
+	if n, err := count(tx, `
+		SELECT COUNT(*) FROM friend
+		WHERE ((user_id_src = ? and user_id_dest = ?) or (user_id_src = ? and user_id_dest = ?))
+			and accepted = TRUE and ban = FALSE`, src, dest, dest, src); err != nil {
+		return nil, err
+	} else if n != 2 {
+		return nil, ErrConflict
+	}
+
+	if err := checkOwners(tx, tradeID, src, dest); err != nil {
+		return nil, err
+	}
+
+	// the receiver gets the cats as caught at t
+	if _, err := tx.Exec(`
+		UPDATE user_cat SET
+			user_id = CASE WHEN user_id = ? THEN ? ELSE ? END,
+			timing = ?
+		WHERE user_id IN (?, ?) and cat_id IN (
+			SELECT cat_id FROM trade_cat WHERE trade_id = ? and giver = user_cat.user_id
+		)`, src, dest, src, t, src, dest, tradeID); err != nil {
+		return nil, err
+	}
+	// the givers can not catch the cats again
+	if _, err := tx.Exec(`
+		INSERT INTO traded_away(user_id, cat_id, trade_id, timing)
+		SELECT giver, cat_id, trade_id, ? FROM trade_cat WHERE trade_id = ?
+		ON CONFLICT (user_id, cat_id) DO UPDATE SET
+			trade_id = excluded.trade_id,
+			timing = excluded.timing`, t, tradeID); err != nil {
+		return nil, err
+	}
+	if err := recountStats(tx, src, dest); err != nil {
+		return nil, err
+	}
+	completed := []ThemeCompleted{}
+	for _, uid := range []uint64{src, dest} {
+		themeIDs, err := recordCompletions(tx, uid, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, themeID := range themeIDs {
+			if err := grantItem(tx, uid, reward.ItemID, reward.Quantity, ItemFromThemeComplete, themeID, t); err != nil {
+				return nil, err
+			}
+			completed = append(completed, ThemeCompleted{uid, themeID})
+		}
+	}
+	return completed, tx.Commit()
+}
+
+func (r *trades) Close(tradeID uint64, status string, t int64) error {
+	res, err := r.db.Exec(`
+		UPDATE trade SET status = ?, updating = ?
+		WHERE trade_id = ? and status = ?`, status, t, tradeID, TradePending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// checkOwners returns ErrConflict unless every cat of the trade is owned by
+// its giver and not by its receiver.
+func checkOwners(tx *tx, tradeID uint64, src uint64, dest uint64) error {
+	owned, err := count(tx, `
+		SELECT COUNT(*) FROM trade_cat
+		JOIN user_cat ON user_cat.cat_id = trade_cat.cat_id and user_cat.user_id = trade_cat.giver
+		WHERE trade_cat.trade_id = ?`, tradeID)
+	if err != nil {
+		return err
+	}
+	all, err := count(tx, "SELECT COUNT(*) FROM trade_cat WHERE trade_id = ?", tradeID)
+	if err != nil {
+		return err
+	}
+	received, err := count(tx, `
+		SELECT COUNT(*) FROM trade_cat
+		JOIN user_cat ON user_cat.cat_id = trade_cat.cat_id and user_cat.user_id <> trade_cat.giver
+		WHERE trade_cat.trade_id = ? and user_cat.user_id IN (?, ?)`, tradeID, src, dest)
+	if err != nil {
+		return err
+	}
+	if owned != all || received != 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// recountStats recomputes user_stats of the users from user_cat, keeping the
+// bonus points.
+func recountStats(tx *tx, uids ...uint64) error {
+	for _, uid := range uids {
+		if _, err := tx.Exec(`
+			INSERT INTO user_stats(user_id, cats, score)
+			SELECT ?, COUNT(user_cat.cat_id), COALESCE(SUM(cat_kind.weight), 0)
+			FROM user_cat
+			JOIN cat ON cat.cat_id = user_cat.cat_id
+			JOIN cat_kind ON cat_kind.cat_kind_id = cat.cat_kind_id
+			WHERE user_cat.user_id = ?
+			ON CONFLICT (user_id) DO UPDATE SET
+				cats = excluded.cats,
+				score = excluded.score + user_stats.bonus`, uid, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordCompletions records the themes of which uid owns every cat at t,
+// unless they were completed before, and returns them.
+func recordCompletions(tx *tx, uid uint64, t int64) ([]uint64, error) {
+	themeIDs := []uint64{}
+	rows, err := tx.Query(`
+		INSERT INTO theme_completion(user_id, theme_id, completed)
+		SELECT ?, cat.theme_id, ?
+		FROM cat
+		LEFT JOIN user_cat ON user_cat.cat_id = cat.cat_id and user_cat.user_id = ?
+		WHERE 1 = 1
+		GROUP BY cat.theme_id
+		HAVING COUNT(user_cat.cat_id) = COUNT(cat.cat_id)
+		ON CONFLICT (user_id, theme_id) DO NOTHING
+		RETURNING theme_id`, uid, t, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		themeID := uint64(0)
+		if err := rows.Scan(&themeID); err != nil {
+			return nil, err
+		}
+		themeIDs = append(themeIDs, themeID)
+	}
+	return themeIDs, rows.Err()
+}
+
+// placeholders returns "?, ?, ..." with n marks.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
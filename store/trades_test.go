@@ -0,0 +1,168 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTradesAccept(t *testing.T) {
+	lure := Stack{ItemID: ItemLure, Quantity: 1}
+
+	tests := []struct {
+		name string
+		// setup returns the trade to accept, alice proposes it to bob
+		setup         func(f *fixture, alice uint64, bob uint64) *Trade
+		wantErr       error
+		wantOwners    map[uint64]string // cat_id -> alice or bob after the trade
+		wantCompleted func(alice uint64, bob uint64) []ThemeCompleted
+	}{
+		{
+			name: "swap",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1)
+				f.catch(bob, testCat2)
+				return &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}, Want: []Cat{{CatID: testCat2}}}
+			},
+			wantOwners: map[uint64]string{testCat1: "bob", testCat2: "alice"},
+		},
+		{
+			name: "gift from a completed theme",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1, testCat2, testCat3)
+				return &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+			},
+			wantOwners: map[uint64]string{testCat1: "bob", testCat2: "alice", testCat3: "alice"},
+		},
+		{
+			name: "receiver completes the theme",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1)
+				f.catch(bob, testCat2, testCat3)
+				return &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+			},
+			wantOwners: map[uint64]string{testCat1: "bob", testCat2: "bob", testCat3: "bob"},
+			wantCompleted: func(alice uint64, bob uint64) []ThemeCompleted {
+				return []ThemeCompleted{{bob, testTheme}}
+			},
+		},
+		{
+			name: "no longer friends",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1)
+				trade := &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+				if err := f.Trades.Create(trade); err != nil {
+					f.t.Fatalf("Create: %v", err)
+				}
+				if err := f.Friends.Delete(bob, alice); err != nil {
+					f.t.Fatalf("Delete friend: %v", err)
+				}
+				return trade
+			},
+			wantErr:    ErrConflict,
+			wantOwners: map[uint64]string{testCat1: "alice"},
+		},
+		{
+			name: "cat given away meanwhile",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1)
+				trade := &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+				if err := f.Trades.Create(trade); err != nil {
+					f.t.Fatalf("Create: %v", err)
+				}
+				carol := f.newUser("carol")
+				f.befriend(alice, carol)
+				other := &Trade{Src: alice, Dest: carol, Give: []Cat{{CatID: testCat1}}}
+				if err := f.Trades.Create(other); err != nil {
+					f.t.Fatalf("Create: %v", err)
+				}
+				if _, err := f.Trades.Accept(other.TradeID, 1, Stack{}); err != nil {
+					f.t.Fatalf("Accept: %v", err)
+				}
+				return trade
+			},
+			wantErr: ErrConflict,
+		},
+		{
+			name: "accepted already",
+			setup: func(f *fixture, alice uint64, bob uint64) *Trade {
+				f.befriend(alice, bob)
+				f.catch(alice, testCat1)
+				trade := &Trade{Src: alice, Dest: bob, Give: []Cat{{CatID: testCat1}}}
+				if err := f.Trades.Create(trade); err != nil {
+					f.t.Fatalf("Create: %v", err)
+				}
+				if _, err := f.Trades.Accept(trade.TradeID, 1, Stack{}); err != nil {
+					f.t.Fatalf("Accept: %v", err)
+				}
+				return trade
+			},
+			wantErr:    ErrNotFound,
+			wantOwners: map[uint64]string{testCat1: "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			alice := f.newUser("alice")
+			bob := f.newUser("bob")
+			trade := tt.setup(f, alice, bob)
+			if trade.TradeID == 0 {
+				if err := f.Trades.Create(trade); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+
+			completed, err := f.Trades.Accept(trade.TradeID, 10, lure)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Accept: err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got, err := f.Trades.Get(trade.TradeID); err != nil {
+					t.Fatalf("Get: %v", err)
+				} else if tt.wantErr == ErrConflict && got.Status != TradePending {
+					t.Errorf("status = %s, want %s after a failed accept", got.Status, TradePending)
+				}
+			}
+
+			want := []ThemeCompleted{}
+			if tt.wantCompleted != nil {
+				want = tt.wantCompleted(alice, bob)
+			}
+			if err == nil && !reflect.DeepEqual(completed, want) {
+				t.Errorf("completed = %v, want %v", completed, want)
+			}
+			for _, done := range want {
+				if got := f.quantity(done.UID, ItemLure); got != 1 {
+					t.Errorf("lures of %d = %d, want 1", done.UID, got)
+				}
+			}
+
+			users := map[string]uint64{"alice": alice, "bob": bob}
+			for catID, owner := range tt.wantOwners {
+				for name, uid := range users {
+					if got := f.owns(uid, catID); got != (name == owner) {
+						t.Errorf("cat %d owned by %s = %v, want %v", catID, name, got, name == owner)
+					}
+				}
+			}
+
+			// user_stats follows user_cat
+			for _, uid := range []uint64{alice, bob} {
+				history, err := f.Cats.History(uid)
+				if err != nil {
+					t.Fatalf("History: %v", err)
+				}
+				if got := f.stats(uid); got.Cats != len(history) {
+					t.Errorf("Stats.Cats of %d = %d, want %d", uid, got.Cats, len(history))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/ksw2000/catch_cat_server/util"
+)
+
+type User struct {
+	UID       uint64
+	Salt      string
+	Password  string // hashed
+	Name      string
+	Profile   string
+	Email     string
+	Creating  int64
+	LastLogin int64
+	LastLng   float64
+	LastLat   float64
+	Verified  bool
+	ShareGPS  bool
+	// ShareActivity publishes the activities of the user to the feeds of
+	// the friends.
+	ShareActivity bool
+	// Timezone is an IANA name such as "Asia/Taipei" where the days of the
+	// quests and the login streak begin, "" for config.Location.
+	Timezone string
+}
+
+// Stats is what a user has caught, read from user_stats.
+type Stats struct {
+	Cats  int
+	Score int
+}
+
+// 1 level = 100 score
+func (s Stats) Level() int {
+	return s.Score / 100
+}
+
+type VerifyEmail struct {
+	Email  string
+	Expire int64
+}
+
+type Users interface {
+	Get(uid uint64) (*User, error)
+	// GetByEmail and EmailExists ignore the case of the stored emails,
+	// email is expected in lower case.
+	GetByEmail(email string) (*User, error)
+	Exists(uid uint64) (bool, error)
+	EmailExists(email string) (bool, error)
+	// Create inserts u with a newly generated u.UID.
+	Create(u *User) error
+	UpdateName(uid uint64, name string) error
+	// UpdateEmail changes the email and resets verified.
+	UpdateEmail(uid uint64, email string) error
+	UpdatePassword(uid uint64, salt string, password string) error
+	UpdateShareGPS(uid uint64, share bool) error
+	UpdateShareActivity(uid uint64, share bool) error
+	// UpdateTimezone sets the time zone of uid at t, it returns ErrConflict
+	// if uid changed it to another one after since.
+	UpdateTimezone(uid uint64, timezone string, t int64, since int64) error
+	UpdateGPS(uid uint64, lat float64, lng float64) error
+	UpdateProfile(uid uint64, profile string) error
+	UpdateLastLogin(uid uint64, t int64) error
+	Stats(uid uint64) (Stats, error)
+	VerifyEmails(uid uint64) ([]VerifyEmail, error)
+	// AddVerifyEmail replaces the pending verifications of uid by one of
+	// email, found by the hash of its token, which expires at expire.
+	AddVerifyEmail(uid uint64, email string, tokenHash string, expire int64) error
+	// Verify marks the email of the verification with tokenHash verified if
+	// it has not expired at t and is still the email of its user, and
+	// removes the verifications of the user. It returns the user, ErrNotFound
+	// if there is no such verification.
+	Verify(tokenHash string, t int64) (uint64, error)
+	// AddUpload records that uid uploaded the file at t.
+	AddUpload(uid uint64, file string, t int64) error
+	// UploadOwner returns who uploaded the file, ErrNotFound if nobody did.
+	UploadOwner(file string) (uint64, error)
+	// Uploads returns the files uploaded by uid, oldest first.
+	Uploads(uid uint64) ([]string, error)
+	// Delete removes the user and every row related to the user in one
+	// transaction, except audit_log. The referral of the user is kept without
+	// the user for the caps of the referrer.
+	Delete(uid uint64) error
+}
+
+type users struct {
+	db *conn
+}
+
+const userColumns = "user_id, salt, password, name, profile, email, creating, last_login, last_lng, last_lat, verified, share_gps, share_activity, timezone"
+
+func scanUser(row *sql.Row) (*User, error) {
+	u := &User{}
+	err := row.Scan(&u.UID, &u.Salt, &u.Password, &u.Name, &u.Profile, &u.Email, &u.Creating, &u.LastLogin, &u.LastLng, &u.LastLat, &u.Verified, &u.ShareGPS, &u.ShareActivity, &u.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return u, err
+}
+
+func (r *users) Get(uid uint64) (*User, error) {
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+` FROM "user" WHERE user_id = ?`, uid))
+}
+
+func (r *users) GetByEmail(email string) (*User, error) {
+	return scanUser(r.db.QueryRow("SELECT "+userColumns+` FROM "user" WHERE LOWER(email) = ?`, email))
+}
+
+func (r *users) Exists(uid uint64) (bool, error) {
+	n, err := count(r.db, `SELECT COUNT(*) FROM "user" WHERE user_id = ?`, uid)
+	return n > 0, err
+}
+
+func (r *users) EmailExists(email string) (bool, error) {
+	n, err := count(r.db, `SELECT COUNT(*) FROM "user" WHERE LOWER(email) = ?`, email)
+	return n > 0, err
+}
+
+func (r *users) Create(u *User) error {
+	// check if there are the same user_id in db
+	for exist := true; exist; {
+		u.UID = util.GenerateID()
+		var err error
+		if exist, err = r.Exists(u.UID); err != nil {
+			return err
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO "user"(`+userColumns+`)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.UID, u.Salt, u.Password, u.Name, u.Profile, u.Email, u.Creating, u.LastLogin, u.LastLng, u.LastLat, u.Verified, u.ShareGPS, u.ShareActivity, u.Timezone); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO user_stats(user_id, cats, score) values(?, 0, 0)", u.UID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *users) UpdateName(uid uint64, name string) error {
+	_, err := r.db.Exec(`UPDATE "user" SET name = ? WHERE user_id = ?`, name, uid)
+	return err
+}
+
+func (r *users) UpdateEmail(uid uint64, email string) error {
+	_, err := r.db.Exec(`UPDATE "user" SET email = ?, verified = FALSE WHERE user_id = ? and email <> ?`, email, uid, email)
+	return err
+}
+
+func (r *users) UpdatePassword(uid uint64, salt string, password string) error {
+	_, err := r.db.Exec(`UPDATE "user" SET salt = ?, password = ? WHERE user_id = ?`, salt, password, uid)
+	return err
+}
+
+func (r *users) UpdateShareGPS(uid uint64, share bool) error {
+	_, err := r.db.Exec(`UPDATE "user" SET share_gps = ? WHERE user_id = ?`, share, uid)
+	return err
+}
+
+func (r *users) UpdateShareActivity(uid uint64, share bool) error {
+	_, err := r.db.Exec(`UPDATE "user" SET share_activity = ? WHERE user_id = ?`, share, uid)
+	return err
+}
+
+func (r *users) UpdateTimezone(uid uint64, timezone string, t int64, since int64) error {
+	// setting the same time zone again is not a change
+	res, err := r.db.Exec(`
+		UPDATE "user" SET
+			timezone_changed = CASE WHEN timezone = ? THEN timezone_changed ELSE ? END,
+			timezone = ?
+		WHERE user_id = ? and (timezone = ? or timezone_changed <= ?)`,
+		timezone, t, timezone, uid, timezone, since)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *users) UpdateGPS(uid uint64, lat float64, lng float64) error {
+	_, err := r.db.Exec(`UPDATE "user" SET last_lng = ?, last_lat = ? WHERE user_id = ?`, lng, lat, uid)
+	return err
+}
+
+func (r *users) UpdateProfile(uid uint64, profile string) error {
+	_, err := r.db.Exec(`UPDATE "user" SET profile = ? WHERE user_id = ?`, profile, uid)
+	return err
+}
+
+func (r *users) UpdateLastLogin(uid uint64, t int64) error {
+	_, err := r.db.Exec(`UPDATE "user" SET last_login = ? WHERE user_id = ?`, t, uid)
+	return err
+}
+
+func (r *users) Stats(uid uint64) (Stats, error) {
+	s := Stats{}
+	err := r.db.QueryRow("SELECT score, cats FROM user_stats WHERE user_id = ?", uid).Scan(&s.Score, &s.Cats)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Stats{}, nil
+	}
+	return s, err
+}
+
+func (r *users) AddUpload(uid uint64, file string, t int64) error {
+	_, err := r.db.Exec("INSERT INTO upload(file, user_id, creating) values(?, ?, ?)", file, uid, t)
+	return err
+}
+
+func (r *users) UploadOwner(file string) (uint64, error) {
+	uid := uint64(0)
+	err := r.db.QueryRow("SELECT user_id FROM upload WHERE file = ?", file).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return uid, err
+}
+
+func (r *users) Uploads(uid uint64) ([]string, error) {
+	list := []string{}
+	rows, err := r.db.Query("SELECT file FROM upload WHERE user_id = ? ORDER BY creating, file", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		file := ""
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		list = append(list, file)
+	}
+	return list, rows.Err()
+}
+
+func (r *users) VerifyEmails(uid uint64) ([]VerifyEmail, error) {
+	list := []VerifyEmail{}
+	rows, err := r.db.Query("SELECT email, expire FROM verify_email WHERE user_id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v := VerifyEmail{}
+		if err := rows.Scan(&v.Email, &v.Expire); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+func (r *users) AddVerifyEmail(uid uint64, email string, tokenHash string, expire int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM verify_email WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO verify_email(user_id, email, token, expire) values(?, ?, ?, ?)",
+		uid, email, tokenHash, expire); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *users) Verify(tokenHash string, t int64) (uint64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	uid, email := uint64(0), ""
+	err = tx.QueryRow("SELECT user_id, email FROM verify_email WHERE token = ? and expire > ?", tokenHash, t).Scan(&uid, &email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	// the email may have been changed after the verification was sent
+	res, err := tx.Exec(`UPDATE "user" SET verified = TRUE WHERE user_id = ? and email = ?`, uid, email)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM verify_email WHERE user_id = ?", uid); err != nil {
+		return 0, err
+	}
+	return uid, tx.Commit()
+}
+
+func (r *users) Delete(uid uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_cat WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM friend WHERE user_id_src = ? or user_id_dest = ?", uid, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM verify_email WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM upload WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_stats WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM theme_completion WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM trade_cat WHERE trade_id IN (
+			SELECT trade_id FROM trade WHERE user_id_src = ? or user_id_dest = ?
+		)`, uid, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM trade WHERE user_id_src = ? or user_id_dest = ?", uid, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM traded_away WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM activity WHERE owner_id = ? or actor_id = ?", uid, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM device WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM push_outbox WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM notification WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM message WHERE user_id_src = ? or user_id_dest = ?", uid, uid); err != nil {
+		return err
+	}
+	if err := leaveTeam(tx, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM team_invite WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM challenge_participant WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM quest WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM login_streak WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM inventory WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM item_log WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM item_effect WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM referral_code WHERE user_id = ?", uid); err != nil {
+		return err
+	}
+	// the referral of uid is kept for the caps of the referrer, which
+	// deleting referees must not lower, but no longer tells who uid was
+	if _, err := tx.Exec("DELETE FROM referral WHERE referrer_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE referral SET referee_id = 0 WHERE referee_id = ?", uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE item_log SET ref_id = 0 WHERE reason = ? and ref_id = ?", ItemFromReferral, uid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM challenge WHERE NOT EXISTS (
+			SELECT 1 FROM challenge_participant
+			WHERE challenge_participant.challenge_id = challenge.challenge_id
+		)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM "user" WHERE user_id = ?`, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUsersDelete(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	bob := f.newUser("bob")
+	f.catch(alice, testCat1)
+	f.catch(bob, testCat1, testCat2)
+	if err := f.Users.AddUpload(alice, "images/alice.png", 1); err != nil {
+		t.Fatalf("AddUpload: %v", err)
+	}
+	if err := f.Items.Grant(alice, ItemBait, 2, ItemFromAdmin, 0, 1); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	// bob referred alice, which makes them friends
+	if err := f.Referrals.SetCode(bob, "BOBCODE1"); err != nil {
+		t.Fatalf("SetCode: %v", err)
+	}
+	if ok, err := f.Referrals.Link(bob, alice, 1, 0, 5); err != nil || !ok {
+		t.Fatalf("Link = (%v, %v), want (true, nil)", ok, err)
+	}
+	if err := f.Users.AddVerifyEmail(alice, "alice@example.com", "hash", 100); err != nil {
+		t.Fatalf("AddVerifyEmail: %v", err)
+	}
+
+	if err := f.Users.Delete(alice); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// each check returns what is left and what is expected
+	checks := []struct {
+		name  string
+		check func() (any, any, error)
+	}{
+		{"user", func() (any, any, error) {
+			_, err := f.Users.Get(alice)
+			return errors.Is(err, ErrNotFound), true, nil
+		}},
+		{"catches", func() (any, any, error) {
+			list, err := f.Cats.History(alice)
+			return len(list), 0, err
+		}},
+		{"stats", func() (any, any, error) {
+			s, err := f.Users.Stats(alice)
+			return s, Stats{}, err
+		}},
+		{"friends of bob", func() (any, any, error) {
+			list, err := f.Friends.Relations(bob)
+			return len(list), 0, err
+		}},
+		{"uploads", func() (any, any, error) {
+			list, err := f.Users.Uploads(alice)
+			return len(list), 0, err
+		}},
+		{"upload owner", func() (any, any, error) {
+			_, err := f.Users.UploadOwner("images/alice.png")
+			return errors.Is(err, ErrNotFound), true, nil
+		}},
+		{"items", func() (any, any, error) {
+			list, err := f.Items.Inventory(alice)
+			return len(list), 0, err
+		}},
+		{"item log", func() (any, any, error) {
+			list, err := f.Items.Log(alice)
+			return len(list), 0, err
+		}},
+		{"verifications", func() (any, any, error) {
+			list, err := f.Users.VerifyEmails(alice)
+			return len(list), 0, err
+		}},
+		{"referral kept for the caps of bob", func() (any, any, error) {
+			n, err := f.Referrals.Count(bob, 0)
+			return n, 1, err
+		}},
+		{"referral without alice", func() (any, any, error) {
+			list, err := f.Referrals.List(bob)
+			if err != nil || len(list) != 1 {
+				return len(list), 1, err
+			}
+			return list[0].Referee, uint64(0), nil
+		}},
+		{"referred by", func() (any, any, error) {
+			_, err := f.Referrals.ReferredBy(alice)
+			return errors.Is(err, ErrNotFound), true, nil
+		}},
+		{"stats of bob", func() (any, any, error) {
+			s, err := f.Users.Stats(bob)
+			return s, Stats{Cats: 2, Score: 30}, err
+		}},
+	}
+	for _, c := range checks {
+		got, want, err := c.check()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", c.name, got, want)
+		}
+	}
+}
+
+func TestUsersVerify(t *testing.T) {
+	f := newFixture(t)
+	alice := f.newUser("alice")
+	email := func() string {
+		t.Helper()
+		u, err := f.Users.Get(alice)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		return u.Email
+	}
+	verified := func() bool {
+		t.Helper()
+		u, err := f.Users.Get(alice)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		return u.Verified
+	}
+
+	// the steps run in order on the same user, the verifications expire at 100
+	steps := []struct {
+		name    string
+		add     string // the token hash of a verification sent first, if set
+		email   string // the email changed to before verifying, if set
+		token   string
+		now     int64
+		wantErr error
+		want    bool // verified after the step
+	}{
+		{name: "unknown token", add: "first", token: "other", now: 50, wantErr: ErrNotFound},
+		{name: "expired", token: "first", now: 100, wantErr: ErrNotFound},
+		{name: "replaced", add: "second", token: "first", now: 50, wantErr: ErrNotFound},
+		{name: "email changed", email: "alice2@example.com", token: "second", now: 50, wantErr: ErrNotFound},
+		{name: "verified", add: "third", token: "third", now: 50, want: true},
+		{name: "used", token: "third", now: 50, wantErr: ErrNotFound, want: true},
+	}
+	for _, step := range steps {
+		if step.add != "" {
+			if err := f.Users.AddVerifyEmail(alice, email(), step.add, 100); err != nil {
+				t.Fatalf("%s: AddVerifyEmail: %v", step.name, err)
+			}
+		}
+		if step.email != "" {
+			if err := f.Users.UpdateEmail(alice, step.email); err != nil {
+				t.Fatalf("%s: UpdateEmail: %v", step.name, err)
+			}
+		}
+		uid, err := f.Users.Verify(step.token, step.now)
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+		}
+		if err == nil && uid != alice {
+			t.Errorf("%s: Verify = %d, want %d", step.name, uid, alice)
+		}
+		if got := verified(); got != step.want {
+			t.Errorf("%s: verified = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if list, err := f.Users.VerifyEmails(alice); err != nil || len(list) != 0 {
+		t.Errorf("VerifyEmails = (%v, %v), want none left", list, err)
+	}
+}
+
+func TestUsersGetByEmail(t *testing.T) {
+	f := newFixture(t)
+	// registered before the emails were stored in lower case
+	u := &User{Name: "alice", Email: "Alice@Example.com"}
+	if err := f.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if got, err := f.Users.GetByEmail("alice@example.com"); err != nil || got.UID != u.UID {
+		t.Errorf("GetByEmail = (%v, %v), want uid %d", got, err, u.UID)
+	}
+	if ok, err := f.Users.EmailExists("alice@example.com"); err != nil || !ok {
+		t.Errorf("EmailExists = (%v, %v), want (true, nil)", ok, err)
+	}
+	if _, err := f.Users.GetByEmail("bob@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByEmail of another email: err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,6 @@
+package teams
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var teamTotal = metrics.NewCounter("catch_cat_teams_total",
+	"Team changes by result: created, joined, left or removed.", "result")
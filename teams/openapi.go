@@ -0,0 +1,67 @@
+package teams
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+	"github.com/ksw2000/catch_cat_server/util"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPost, Path: "/v1/teams", Tag: "team", Auth: true,
+		Summary: "Create a team owned by me",
+		Request: createRequest{},
+		Status:  http.StatusCreated, Response: teamResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/teams", Tag: "team", Auth: true,
+		Summary: "Rank the teams by the score in every theme",
+		Query:   []openapi.Param{{Name: "limit", Type: "integer"}},
+		Status:  http.StatusOK, Response: rankingResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/teams/:team_id", Tag: "team", Auth: true,
+		Summary: "Get a team and its members",
+		Status:  http.StatusOK, Response: teamResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/themes/:theme_id/team_rank", Tag: "team", Auth: true,
+		Summary: "Rank the teams by the score in a theme",
+		Query:   []openapi.Param{{Name: "limit", Type: "integer"}},
+		Status:  http.StatusOK, Response: rankingResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/teams/invitations", Tag: "team", Auth: true,
+		Summary: "List the teams inviting me",
+		Status:  http.StatusOK, Response: invitationsResponse{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/teams/:team_id/invitations", Tag: "team", Auth: true,
+		Summary: "Invite a user to the team, as the owner or an officer",
+		Request: inviteRequest{},
+		Status:  http.StatusCreated, Response: util.Response{},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/teams/:team_id/invitations/:uid", Tag: "team", Auth: true,
+		Summary: "Decline my invitation or withdraw the invitation of uid",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/teams/:team_id/members", Tag: "team", Auth: true,
+		Summary: "Join the team by its invitation",
+		Status:  http.StatusCreated, Response: teamResponse{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/teams/:team_id/members/:uid", Tag: "team", Auth: true,
+		Summary: "Set the role of a member, as the owner",
+		Request: roleRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/teams/:team_id/members/:uid", Tag: "team", Auth: true,
+		Summary: "Leave the team or remove a member",
+		Status:  http.StatusNoContent,
+	},
+}
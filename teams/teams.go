@@ -0,0 +1,411 @@
+// Package teams lets users form teams. A user is in one team at most and
+// joins by the invitation of the owner or an officer. The score of a team is
+// the sum of the weights of the cats caught by its members, ranked in every
+// theme or per theme alongside the friend ranking of package friends.
+package teams
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Team           = store.Team
+	Membership     = store.Membership
+	TeamInvitation = store.TeamInvitation
+	TeamRank       = store.TeamRank
+)
+
+var (
+	teams store.Teams
+	users store.Users
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	teams = s.Teams
+	users = s.Users
+}
+
+const (
+	maxNameLength        = 30  // characters
+	maxDescriptionLength = 200 // characters
+	defaultRankingLimit  = 20
+	maxRankingLimit      = 100
+)
+
+// rank orders the roles, a member can manage the members of lower rank.
+var rank = map[string]int{
+	store.TeamMember:  1,
+	store.TeamOfficer: 2,
+	store.TeamOwner:   3,
+}
+
+type createRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type teamResponse struct {
+	Error   string       `json:"error"`
+	Team    Team         `json:"team"`
+	Members []Membership `json:"members"`
+}
+
+// POST /v1/teams
+//
+// Create a team owned by me.
+func CreateTeam(c *gin.Context) {
+	req := createRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		errcode.Abort(c, errcode.ErrTeamNameLength)
+		return
+	}
+	description := strings.TrimSpace(req.Description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	t := &Team{
+		Name:        name,
+		Description: description,
+		OwnerID:     session.UID(c),
+		Creating:    time.Now().Unix(),
+	}
+	if err := teams.Create(t); errors.Is(err, store.ErrConflict) {
+		errcode.Abort(c, errcode.ErrAlreadyInTeam)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	teamTotal.Inc("created")
+	respondTeam(c, http.StatusCreated, t.TeamID)
+}
+
+// GET /v1/teams/:team_id
+//
+// Get a team and its members, highest score first.
+func GetTeam(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	respondTeam(c, http.StatusOK, teamID)
+}
+
+func respondTeam(c *gin.Context, status int, teamID uint64) {
+	t, err := teams.Get(teamID)
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrTeamNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	members, err := teams.Members(teamID)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(status, teamResponse{"", *t, members})
+}
+
+type rankingResponse struct {
+	Error string     `json:"error"`
+	List  []TeamRank `json:"list"`
+}
+
+// GET /v1/teams?limit=
+//
+// Rank the teams by the score in every theme.
+func GetTeams(c *gin.Context) {
+	respondRanking(c, 0)
+}
+
+// GET /v1/themes/:theme_id/team_rank?limit=
+//
+// Rank the teams by the score in a theme.
+func GetThemeRank(c *gin.Context) {
+	themeID, ok := util.ParamID(c, "theme_id")
+	if !ok {
+		return
+	}
+	respondRanking(c, themeID)
+}
+
+func respondRanking(c *gin.Context, themeID uint64) {
+	limit := defaultRankingLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxRankingLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	list, err := teams.Ranking(themeID, limit)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, rankingResponse{"", list})
+}
+
+type inviteRequest struct {
+	UID uint64 `json:"uid"`
+}
+
+// POST /v1/teams/:team_id/invitations
+//
+// Invite a user to the team, only the owner and the officers can.
+func CreateInvitation(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	req := inviteRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || req.UID == 0 {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	if err := invite(c, session.UID(c), teamID, req.UID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, util.Response{})
+}
+
+func invite(c *gin.Context, uid uint64, teamID uint64, invitedUID uint64) error {
+	me, err := role(uid, teamID)
+	if err != nil {
+		return err
+	}
+	if rank[me.Role] < rank[store.TeamOfficer] {
+		return errcode.ErrTeamForbidden
+	}
+
+	if exist, err := users.Exists(invitedUID); err != nil {
+		return err
+	} else if !exist {
+		return errcode.ErrUserNotFound
+	}
+	if _, err := teams.Membership(invitedUID); err == nil {
+		return errcode.ErrAlreadyInTeam
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	if err := teams.Invite(teamID, invitedUID, uid, time.Now().Unix()); err != nil {
+		return err
+	}
+	// the invitation is made already, a failing notice does not fail it
+	t, err := teams.Get(teamID)
+	if err != nil {
+		logging.From(c).Error("team notice failed", "team_id", teamID, "uid", invitedUID, "err", err)
+		return nil
+	}
+	inbox.Add(c, invitedUID, inbox.TeamInvite, inbox.Detail{"team_id": teamID, "name": t.Name, "uid": uid})
+	notify.Enqueue(c, invitedUID, notify.TeamInvite, notify.Message{
+		Title: "新的隊伍邀請",
+		Body:  me.Name + " 邀請你加入 " + t.Name,
+		Data: map[string]string{
+			"team_id": strconv.FormatUint(teamID, 10),
+			"uid":     strconv.FormatUint(uid, 10),
+		},
+	})
+	return nil
+}
+
+type invitationsResponse struct {
+	Error string           `json:"error"`
+	List  []TeamInvitation `json:"list"`
+}
+
+// GET /v1/teams/invitations
+//
+// List the teams inviting me.
+func GetInvitations(c *gin.Context) {
+	list, err := teams.Invitations(session.UID(c))
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, invitationsResponse{"", list})
+}
+
+// DELETE /v1/teams/:team_id/invitations/:uid
+//
+// Decline my invitation, or withdraw the invitation of uid as the owner or
+// an officer.
+func DeleteInvitation(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	invitedUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	if uid := session.UID(c); uid != invitedUID {
+		me, err := role(uid, teamID)
+		if err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+		if rank[me.Role] < rank[store.TeamOfficer] {
+			errcode.Abort(c, errcode.ErrTeamForbidden)
+			return
+		}
+	}
+	if err := teams.Decline(teamID, invitedUID); errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrTeamInvitationNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /v1/teams/:team_id/members
+//
+// Join the team by its invitation.
+func CreateMember(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	err := teams.Join(teamID, session.UID(c), time.Now().Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrTeamInvitationNotFound)
+		return
+	} else if errors.Is(err, store.ErrConflict) {
+		errcode.Abort(c, errcode.ErrAlreadyInTeam)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	teamTotal.Inc("joined")
+	respondTeam(c, http.StatusCreated, teamID)
+}
+
+// DELETE /v1/teams/:team_id/members/:uid
+//
+// Leave the team, or remove uid from it. The owner can remove anyone, an
+// officer only the members.
+func DeleteMember(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	memberUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	uid := session.UID(c)
+	result := "left"
+	if uid != memberUID {
+		me, err := role(uid, teamID)
+		if err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+		member, err := role(memberUID, teamID)
+		if err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+		if rank[me.Role] < rank[store.TeamOfficer] || rank[me.Role] <= rank[member.Role] {
+			errcode.Abort(c, errcode.ErrTeamForbidden)
+			return
+		}
+		result = "removed"
+	}
+	if err := teams.Leave(teamID, memberUID); errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrTeamNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	teamTotal.Inc(result)
+	c.Status(http.StatusNoContent)
+}
+
+type roleRequest struct {
+	Role string `json:"role"` // owner, officer or member
+}
+
+// PUT /v1/teams/:team_id/members/:uid
+//
+// Set the role of a member, only the owner can. Making a member the owner
+// hands over the team and makes me an officer.
+func PutMember(c *gin.Context) {
+	teamID, ok := util.ParamID(c, "team_id")
+	if !ok {
+		return
+	}
+	memberUID, ok := util.ParamID(c, "uid")
+	if !ok {
+		return
+	}
+	req := roleRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil || rank[req.Role] == 0 {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	uid := session.UID(c)
+	if uid == memberUID {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	me, err := role(uid, teamID)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if me.Role != store.TeamOwner {
+		errcode.Abort(c, errcode.ErrTeamForbidden)
+		return
+	}
+	if err := teams.SetRole(teamID, memberUID, req.Role); errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrUserNotFound)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// role returns the membership of uid, ErrTeamForbidden if uid is not in the
+// team.
+func role(uid uint64, teamID uint64) (*Membership, error) {
+	m, err := teams.Membership(uid)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && m.TeamID != teamID) {
+		return nil, errcode.ErrTeamForbidden
+	}
+	return m, err
+}
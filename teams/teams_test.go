@@ -0,0 +1,169 @@
+package teams
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens an empty store for the handlers and creates a team of owner,
+// officer and member, and the users outsider and other, who owns a team of
+// its own.
+func setup(t *testing.T) (map[string]uint64, uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	inbox.Init(s)
+	notify.Init(s)
+
+	uid := map[string]uint64{}
+	for _, name := range []string{"owner", "officer", "member", "outsider", "other"} {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uid[name] = u.UID
+	}
+	team := &Team{Name: "team", OwnerID: uid["owner"], Creating: 1}
+	if err := s.Teams.Create(team); err != nil {
+		t.Fatalf("Create team: %v", err)
+	}
+	if err := s.Teams.Create(&Team{Name: "other", OwnerID: uid["other"], Creating: 1}); err != nil {
+		t.Fatalf("Create team: %v", err)
+	}
+	for _, name := range []string{"officer", "member"} {
+		if err := s.Teams.Invite(team.TeamID, uid[name], uid["owner"], 1); err != nil {
+			t.Fatalf("Invite: %v", err)
+		}
+		if err := s.Teams.Join(team.TeamID, uid[name], 2); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	}
+	if err := s.Teams.SetRole(team.TeamID, uid["officer"], store.TeamOfficer); err != nil {
+		t.Fatalf("SetRole: %v", err)
+	}
+	return uid, team.TeamID
+}
+
+// serve sends the request as uid to the team routes and returns the status
+// and the error code.
+func serve(t *testing.T, uid uint64, method string, path string, body string) (int, string) {
+	t.Helper()
+	r := gin.New()
+	v1 := r.Group("/v1", session.Auth())
+	v1.POST("/teams/:team_id/invitations", CreateInvitation)
+	v1.DELETE("/teams/:team_id/invitations/:uid", DeleteInvitation)
+	v1.POST("/teams/:team_id/members", CreateMember)
+	v1.PUT("/teams/:team_id/members/:uid", PutMember)
+	v1.DELETE("/teams/:team_id/members/:uid", DeleteMember)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+session.NewSession(uid))
+	r.ServeHTTP(w, req)
+
+	res := errcode.Body{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res.Code
+}
+
+func id(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func TestPermissions(t *testing.T) {
+	tests := []struct {
+		name     string
+		as       string
+		method   string
+		path     string // %t is the team, %u the uid of the user named by target
+		target   string
+		body     string // %u is the uid of target
+		wantCode string // "" for success
+	}{
+		{"owner invites", "owner", http.MethodPost, "/v1/teams/%t/invitations", "outsider", `{"uid":%u}`, ""},
+		{"officer invites", "officer", http.MethodPost, "/v1/teams/%t/invitations", "outsider", `{"uid":%u}`, ""},
+		{"member invites", "member", http.MethodPost, "/v1/teams/%t/invitations", "outsider", `{"uid":%u}`, errcode.ErrTeamForbidden.Code},
+		{"outsider invites", "outsider", http.MethodPost, "/v1/teams/%t/invitations", "outsider", `{"uid":%u}`, errcode.ErrTeamForbidden.Code},
+		{"invite someone in a team", "owner", http.MethodPost, "/v1/teams/%t/invitations", "other", `{"uid":%u}`, errcode.ErrAlreadyInTeam.Code},
+		{"invite nobody", "owner", http.MethodPost, "/v1/teams/%t/invitations", "", `{"uid":999}`, errcode.ErrUserNotFound.Code},
+		{"join uninvited", "outsider", http.MethodPost, "/v1/teams/%t/members", "", "", errcode.ErrTeamInvitationNotFound.Code},
+
+		{"owner removes officer", "owner", http.MethodDelete, "/v1/teams/%t/members/%u", "officer", "", ""},
+		{"officer removes member", "officer", http.MethodDelete, "/v1/teams/%t/members/%u", "member", "", ""},
+		{"officer removes owner", "officer", http.MethodDelete, "/v1/teams/%t/members/%u", "owner", "", errcode.ErrTeamForbidden.Code},
+		{"member removes member", "member", http.MethodDelete, "/v1/teams/%t/members/%u", "officer", "", errcode.ErrTeamForbidden.Code},
+		{"member leaves", "member", http.MethodDelete, "/v1/teams/%t/members/%u", "member", "", ""},
+		{"remove outsider", "owner", http.MethodDelete, "/v1/teams/%t/members/%u", "outsider", "", errcode.ErrTeamForbidden.Code},
+
+		{"owner promotes", "owner", http.MethodPut, "/v1/teams/%t/members/%u", "member", `{"role":"officer"}`, ""},
+		{"officer promotes", "officer", http.MethodPut, "/v1/teams/%t/members/%u", "member", `{"role":"officer"}`, errcode.ErrTeamForbidden.Code},
+		{"owner demotes self", "owner", http.MethodPut, "/v1/teams/%t/members/%u", "owner", `{"role":"member"}`, errcode.ErrBadRequest.Code},
+		{"unknown role", "owner", http.MethodPut, "/v1/teams/%t/members/%u", "member", `{"role":"king"}`, errcode.ErrBadRequest.Code},
+		{"promote outsider", "owner", http.MethodPut, "/v1/teams/%t/members/%u", "outsider", `{"role":"officer"}`, errcode.ErrUserNotFound.Code},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, teamID := setup(t)
+			target := id(uid[tt.target])
+			path := strings.NewReplacer("%t", id(teamID), "%u", target).Replace(tt.path)
+			body := strings.ReplaceAll(tt.body, "%u", target)
+
+			status, code := serve(t, uid[tt.as], tt.method, path, body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d %q, want %q", tt.method, path, status, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestInvitation(t *testing.T) {
+	uid, teamID := setup(t)
+	team := id(teamID)
+	outsider := id(uid["outsider"])
+
+	steps := []struct {
+		name     string
+		as       string
+		method   string
+		path     string
+		wantCode string
+	}{
+		{"invite", "owner", http.MethodPost, "/v1/teams/" + team + "/invitations", ""},
+		{"member withdraws", "member", http.MethodDelete, "/v1/teams/" + team + "/invitations/" + outsider, errcode.ErrTeamForbidden.Code},
+		{"join", "outsider", http.MethodPost, "/v1/teams/" + team + "/members", ""},
+		{"join again", "outsider", http.MethodPost, "/v1/teams/" + team + "/members", errcode.ErrTeamInvitationNotFound.Code},
+		{"decline after joining", "outsider", http.MethodDelete, "/v1/teams/" + team + "/invitations/" + outsider, errcode.ErrTeamInvitationNotFound.Code},
+	}
+	for _, step := range steps {
+		body := ""
+		if step.method == http.MethodPost && strings.HasSuffix(step.path, "/invitations") {
+			body = `{"uid":` + outsider + `}`
+		}
+		if status, code := serve(t, uid[step.as], step.method, step.path, body); code != step.wantCode {
+			t.Errorf("%s: %d %q, want %q", step.name, status, code, step.wantCode)
+		}
+	}
+
+	m, err := teams.Membership(uid["outsider"])
+	if err != nil || m.TeamID != teamID || m.Role != store.TeamMember {
+		t.Errorf("Membership of outsider = (%+v, %v), want a member of the team", m, err)
+	}
+}
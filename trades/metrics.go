@@ -0,0 +1,6 @@
+package trades
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var tradeTotal = metrics.NewCounter("catch_cat_trades_total",
+	"Trades by result: proposed, accepted, declined or cancelled.", "result")
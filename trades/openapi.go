@@ -0,0 +1,37 @@
+package trades
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPost, Path: "/v1/trades", Tag: "trade", Auth: true,
+		Summary: "Propose a trade of cats to a friend",
+		Request: proposeRequest{},
+		Status:  http.StatusCreated, Response: proposeResponse{},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/trades", Tag: "trade", Auth: true,
+		Summary: "List the trades proposed by me or to me, newest first",
+		Query: []openapi.Param{
+			{Name: "status", Type: "string"},
+			{Name: "before", Type: "integer"},
+			{Name: "limit", Type: "integer"},
+		},
+		Status: http.StatusOK, Response: tradesResponse{},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/trades/:trade_id", Tag: "trade", Auth: true,
+		Summary: "Accept a trade proposed to me",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/trades/:trade_id", Tag: "trade", Auth: true,
+		Summary: "Decline a trade proposed to me or cancel a trade I proposed",
+		Status:  http.StatusNoContent,
+	},
+}
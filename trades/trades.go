@@ -0,0 +1,284 @@
+// Package trades lets friends trade or give away the cats they caught. A
+// friend proposes the cats each side gives and the other accepts or declines;
+// accepting swaps the owners of the cats at once.
+package trades
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/cats"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Trade = store.Trade
+
+var (
+	trades  store.Trades
+	friends store.Friends
+	users   store.Users
+	themes  store.Themes
+)
+
+// Init sets the repositories used by the handlers.
+func Init(s *store.Store) {
+	trades = s.Trades
+	friends = s.Friends
+	users = s.Users
+	themes = s.Themes
+}
+
+const (
+	maxTradeCats       = 10 // on each side
+	defaultTradesLimit = 20
+	maxTradesLimit     = 100
+)
+
+type proposeRequest struct {
+	UID  uint64   `json:"uid"`  // the friend
+	Give []uint64 `json:"give"` // my cats, empty for asking a gift
+	Want []uint64 `json:"want"` // cats of the friend, empty for a gift
+}
+
+type proposeResponse struct {
+	Error   string `json:"error"`
+	TradeID uint64 `json:"trade_id"`
+}
+
+// POST /v1/trades
+func CreateTrade(c *gin.Context) {
+	req := proposeRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	tradeID, err := propose(c, session.UID(c), req)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, proposeResponse{"", tradeID})
+}
+
+func propose(c *gin.Context, uid uint64, req proposeRequest) (uint64, error) {
+	if req.UID == uid || len(req.Give)+len(req.Want) == 0 ||
+		len(req.Give) > maxTradeCats || len(req.Want) > maxTradeCats {
+		return 0, errcode.ErrBadRequest
+	}
+	seen := map[uint64]bool{}
+	for _, catID := range append(append([]uint64{}, req.Give...), req.Want...) {
+		if seen[catID] {
+			return 0, errcode.ErrBadRequest
+		}
+		seen[catID] = true
+	}
+
+	if ok, err := friends.AreFriends(uid, req.UID); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, errcode.ErrNotFriend
+	}
+
+	now := time.Now().Unix()
+	t := &Trade{
+		Src:      uid,
+		Dest:     req.UID,
+		Creating: now,
+		Updating: now,
+	}
+	for _, catID := range req.Give {
+		t.Give = append(t.Give, store.Cat{CatID: catID})
+	}
+	for _, catID := range req.Want {
+		t.Want = append(t.Want, store.Cat{CatID: catID})
+	}
+	if err := trades.Create(t); errors.Is(err, store.ErrConflict) {
+		return 0, errcode.ErrTradeUnavailable
+	} else if err != nil {
+		return 0, err
+	}
+	tradeTotal.Inc("proposed")
+
+	notifyTrade(c, uid, req.UID, notify.TradeProposed, t.TradeID, "新的交易", " 向你提出了交易")
+	return t.TradeID, nil
+}
+
+type tradesResponse struct {
+	Error      string  `json:"error"`
+	List       []Trade `json:"list"`
+	NextBefore uint64  `json:"next_before"` // 0 if this is the last page
+}
+
+// GET /v1/trades?status=&before=&limit=
+//
+// List the trades proposed by me or to me, newest first.
+func GetTrades(c *gin.Context) {
+	f := store.TradeFilter{
+		UID:    session.UID(c),
+		Status: c.Query("status"),
+		Limit:  defaultTradesLimit,
+	}
+	switch f.Status {
+	case "", store.TradePending, store.TradeAccepted, store.TradeDeclined, store.TradeCancelled:
+	default:
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+	var err error
+	if v := c.Query("before"); v != "" {
+		if f.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > maxTradesLimit {
+			errcode.Abort(c, errcode.ErrBadRequest)
+			return
+		}
+	}
+
+	list, err := trades.List(f)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	res := tradesResponse{List: list}
+	if len(list) == f.Limit {
+		res.NextBefore = list[len(list)-1].TradeID
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+// PUT /v1/trades/:trade_id
+//
+// Accept a trade proposed to me.
+func AcceptTrade(c *gin.Context) {
+	tradeID, ok := util.ParamID(c, "trade_id")
+	if !ok {
+		return
+	}
+	if err := accept(c, session.UID(c), tradeID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func accept(c *gin.Context, uid uint64, tradeID uint64) error {
+	t, err := pendingTrade(tradeID)
+	if err != nil {
+		return err
+	}
+	if t.Dest != uid {
+		return errcode.ErrTradeNotFound
+	}
+	if ok, err := friends.AreFriends(uid, t.Src); err != nil {
+		return err
+	} else if !ok {
+		return errcode.ErrNotFriend
+	}
+
+	completed, err := trades.Accept(tradeID, time.Now().Unix(), cats.CompletionReward)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrTradeNotFound
+	} else if errors.Is(err, store.ErrConflict) {
+		return errcode.ErrTradeUnavailable
+	} else if err != nil {
+		return err
+	}
+	tradeTotal.Inc(store.TradeAccepted)
+	for _, done := range completed {
+		theme, err := themes.Get(done.ThemeID)
+		if err != nil {
+			logging.From(c).Error("theme completion notice failed", "uid", done.UID, "theme_id", done.ThemeID, "err", err)
+			continue
+		}
+		cats.Completed(c, done.UID, theme)
+	}
+
+	notifyTrade(c, uid, t.Src, notify.TradeAccepted, tradeID, "交易成功", " 接受了你的交易")
+	return nil
+}
+
+// DELETE /v1/trades/:trade_id
+//
+// Decline a trade proposed to me, or cancel a trade I proposed.
+func DeleteTrade(c *gin.Context) {
+	tradeID, ok := util.ParamID(c, "trade_id")
+	if !ok {
+		return
+	}
+	if err := closeTrade(session.UID(c), tradeID); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func closeTrade(uid uint64, tradeID uint64) error {
+	t, err := pendingTrade(tradeID)
+	if err != nil {
+		return err
+	}
+	status := ""
+	switch uid {
+	case t.Dest:
+		status = store.TradeDeclined
+	case t.Src:
+		status = store.TradeCancelled
+	default:
+		return errcode.ErrTradeNotFound
+	}
+
+	err = trades.Close(tradeID, status, time.Now().Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrTradeNotFound
+	} else if err != nil {
+		return err
+	}
+	tradeTotal.Inc(status)
+	return nil
+}
+
+// notifyTrade pushes a notification of kind about the trade from uid to
+// dest; body follows the name of uid. The trade is done already, so a failure
+// is logged and does not fail the request.
+func notifyTrade(c *gin.Context, uid uint64, dest uint64, kind string, tradeID uint64, title string, body string) {
+	me, err := users.Get(uid)
+	if err != nil {
+		logging.From(c).Error("trade notice failed", "trade_id", tradeID, "uid", uid, "err", err)
+		return
+	}
+	notify.Enqueue(c, dest, kind, notify.Message{
+		Title: title,
+		Body:  me.Name + body,
+		Data: map[string]string{
+			"trade_id": strconv.FormatUint(tradeID, 10),
+			"uid":      strconv.FormatUint(uid, 10),
+		},
+	})
+}
+
+func pendingTrade(tradeID uint64) (*Trade, error) {
+	t, err := trades.Get(tradeID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errcode.ErrTradeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if t.Status != store.TradePending {
+		return nil, errcode.ErrTradeNotFound
+	}
+	return t, nil
+}
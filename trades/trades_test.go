@@ -0,0 +1,202 @@
+package trades
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/feed"
+	"github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/notify"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setup opens a store with one theme of the cats 1, 2 and 3 for the
+// handlers, creates alice and bob, makes them friends and lets alice catch
+// the cat 1 and bob the cat 2.
+func setup(t *testing.T) (*store.Store, uint64, uint64) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Init(s)
+	audit.Init(s)
+	feed.Init(s)
+	inbox.Init(s)
+	notify.Init(s)
+
+	if err := s.Themes.Save(store.Theme{ThemeID: 1, Name: "theme"}); err != nil {
+		t.Fatalf("Save theme: %v", err)
+	}
+	if err := s.Themes.SaveKind(store.CatKind{CatKindID: 1, Name: "kind", Weight: 10}); err != nil {
+		t.Fatalf("SaveKind: %v", err)
+	}
+	for catID := uint64(1); catID <= 3; catID++ {
+		if err := s.Themes.AddCat(store.Cat{CatID: catID, ThemeID: 1, CatKind: store.CatKind{CatKindID: 1}}); err != nil {
+			t.Fatalf("AddCat: %v", err)
+		}
+	}
+
+	uids := []uint64{}
+	for _, name := range []string{"alice", "bob"} {
+		u := &store.User{Name: name, Email: name + "@example.com"}
+		if err := s.Users.Create(u); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		uids = append(uids, u.UID)
+	}
+	alice, bob := uids[0], uids[1]
+	if err := s.Friends.Insert(store.Relation{Src: alice, Dest: bob}); err != nil {
+		t.Fatalf("Insert friend: %v", err)
+	}
+	if err := s.Friends.Accept(bob, alice); err != nil {
+		t.Fatalf("Accept friend: %v", err)
+	}
+	for uid, catID := range map[uint64]uint64{alice: 1, bob: 2} {
+		if _, _, _, err := s.Cats.Catch(uid, catID, 1, store.Stack{}, store.Boost{}); err != nil {
+			t.Fatalf("Catch: %v", err)
+		}
+	}
+	return s, alice, bob
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+// brokenUsers fails to read any user.
+type brokenUsers struct {
+	store.Users
+}
+
+func (brokenUsers) Get(uid uint64) (*store.User, error) {
+	return nil, errors.New("broken")
+}
+
+func TestPropose(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     func(alice uint64, bob uint64) proposeRequest
+		wantErr error
+	}{
+		{name: "swap",
+			req: func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob, []uint64{1}, []uint64{2}} }},
+		{name: "gift",
+			req: func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob, []uint64{1}, nil} }},
+		{name: "self",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{alice, []uint64{1}, nil} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "no cats",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{UID: bob} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "too many cats",
+			req: func(alice uint64, bob uint64) proposeRequest {
+				return proposeRequest{bob, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, nil}
+			},
+			wantErr: errcode.ErrBadRequest},
+		{name: "same cat twice",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob, []uint64{1}, []uint64{1}} },
+			wantErr: errcode.ErrBadRequest},
+		{name: "not friends",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob + 100, []uint64{1}, nil} },
+			wantErr: errcode.ErrNotFriend},
+		{name: "cat not mine",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob, []uint64{3}, nil} },
+			wantErr: errcode.ErrTradeUnavailable},
+		{name: "cat the friend has",
+			req:     func(alice uint64, bob uint64) proposeRequest { return proposeRequest{bob, nil, []uint64{1}} },
+			wantErr: errcode.ErrTradeUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, alice, bob := setup(t)
+			tradeID, err := propose(context(), alice, tt.req(alice, bob))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("propose: err = %v, want %v", err, tt.wantErr)
+			}
+			if (tradeID != 0) != (tt.wantErr == nil) {
+				t.Errorf("trade_id = %d with err %v", tradeID, err)
+			}
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	s, alice, bob := setup(t)
+	tradeID, err := propose(context(), alice, proposeRequest{bob, []uint64{1}, []uint64{2}})
+	if err != nil {
+		t.Fatalf("propose: %v", err)
+	}
+
+	if err := accept(context(), alice, tradeID); !errors.Is(err, errcode.ErrTradeNotFound) {
+		t.Errorf("accept by the proposer: err = %v, want %v", err, errcode.ErrTradeNotFound)
+	}
+	// the trade is done even if its notice fails
+	users = brokenUsers{s.Users}
+	if err := accept(context(), bob, tradeID); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	users = s.Users
+	if err := accept(context(), bob, tradeID); !errors.Is(err, errcode.ErrTradeNotFound) {
+		t.Errorf("accept again: err = %v, want %v", err, errcode.ErrTradeNotFound)
+	}
+
+	for uid, want := range map[uint64]uint64{alice: 2, bob: 1} {
+		list, err := s.Cats.History(uid)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if len(list) != 1 || list[0].CatID != want {
+			t.Errorf("cats of %d = %+v, want only the cat %d", uid, list, want)
+		}
+	}
+}
+
+func TestCloseTrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		closer     func(alice uint64, bob uint64) uint64
+		wantErr    error
+		wantStatus string
+	}{
+		{name: "declined", closer: func(alice uint64, bob uint64) uint64 { return bob },
+			wantStatus: store.TradeDeclined},
+		{name: "cancelled", closer: func(alice uint64, bob uint64) uint64 { return alice },
+			wantStatus: store.TradeCancelled},
+		{name: "stranger", closer: func(alice uint64, bob uint64) uint64 { return bob + 100 },
+			wantErr: errcode.ErrTradeNotFound, wantStatus: store.TradePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, alice, bob := setup(t)
+			tradeID, err := propose(context(), alice, proposeRequest{bob, []uint64{1}, nil})
+			if err != nil {
+				t.Fatalf("propose: %v", err)
+			}
+
+			if err := closeTrade(tt.closer(alice, bob), tradeID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("closeTrade: err = %v, want %v", err, tt.wantErr)
+			}
+			if got, err := s.Trades.Get(tradeID); err != nil {
+				t.Fatalf("Get: %v", err)
+			} else if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if tt.wantErr == nil {
+				if err := closeTrade(alice, tradeID); !errors.Is(err, errcode.ErrTradeNotFound) {
+					t.Errorf("close again: err = %v, want %v", err, errcode.ErrTradeNotFound)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package user
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type exportUser struct {
+	Uid           uint64  `json:"uid"`
+	Name          string  `json:"name"`
+	Profile       string  `json:"profile"`
+	Email         string  `json:"email"`
+	Creating      int64   `json:"creating"`
+	LastLogin     int64   `json:"last_login"`
+	LastLng       float64 `json:"last_lng"`
+	LastLat       float64 `json:"last_lat"`
+	Verified      bool    `json:"verified"`
+	ShareGPS      bool    `json:"share_gps"`
+	ShareActivity bool    `json:"share_activity"`
+	Timezone      string  `json:"timezone"`
+}
+
+type exportCat struct {
+	CatID     uint64  `json:"cat_id"`
+	CatKindID uint64  `json:"cat_kind_id"`
+	ThemeID   uint64  `json:"theme_id"`
+	Lng       float64 `json:"lng"`
+	Lat       float64 `json:"lat"`
+	Timing    int64   `json:"timing"`
+}
+
+type exportFriend struct {
+	UserIDSrc  uint64 `json:"user_id_src"`
+	UserIDDest uint64 `json:"user_id_dest"`
+	Accepted   bool   `json:"accepted"`
+	Ban        bool   `json:"ban"`
+}
+
+type exportCompletion struct {
+	ThemeID   uint64 `json:"theme_id"`
+	Completed int64  `json:"completed"`
+}
+
+type exportTrade struct {
+	TradeID  uint64   `json:"trade_id"`
+	Src      uint64   `json:"src_uid"`
+	Dest     uint64   `json:"dest_uid"`
+	Status   string   `json:"status"`
+	Creating int64    `json:"creating"`
+	Updating int64    `json:"updating"`
+	Give     []uint64 `json:"give"` // cat_id
+	Want     []uint64 `json:"want"`
+}
+
+type exportDevice struct {
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+	Creating int64  `json:"creating"`
+	Updating int64  `json:"updating"`
+}
+
+type exportTeam struct {
+	TeamID uint64 `json:"team_id"`
+	Role   string `json:"role"`
+	Joined int64  `json:"joined"`
+}
+
+type exportVerifyEmail struct {
+	Email  string `json:"email"`
+	Expire int64  `json:"expire"`
+}
+
+type exportData struct {
+	User        exportUser             `json:"user"`
+	Cats        []exportCat            `json:"cats"`
+	Friends     []exportFriend         `json:"friends"`
+	Completions []exportCompletion     `json:"theme_completions"`
+	Trades      []exportTrade          `json:"trades"`
+	Devices     []exportDevice         `json:"devices"`
+	Inbox       []store.Notification   `json:"notifications"`
+	Messages    []store.Message        `json:"messages"`
+	Team        *exportTeam            `json:"team"` // null if not in a team
+	TeamInvites []store.TeamInvitation `json:"team_invitations"`
+	Challenges  []store.Challenge      `json:"challenges"`
+	Quests      []store.Quest          `json:"quests"`
+	Streak      store.Streak           `json:"login_streak"`
+	Items       []store.Stack          `json:"items"`
+	ItemLog     []store.ItemChange     `json:"item_log"`
+	Effects     []store.Effect         `json:"item_effects"`
+	Code        string                 `json:"referral_code"` // "" if never shown
+	ReferredBy  uint64                 `json:"referred_by"`   // 0 if nobody
+	Referrals   []store.Referral       `json:"referrals"`
+	VerifyEmail []exportVerifyEmail    `json:"verify_email"`
+	Uploads     []string               `json:"uploads"` // paths in the archive
+
+	files []string // uploaded files on disk
+}
+
+// PostExport sends a zip archive of everything stored about the user:
+// data.json and the uploaded files under uploads/.
+func PostExport(c *gin.Context) {
+	uid := session.UID(c)
+
+	data, err := exportUserData(uid)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="catch_cat_%d.zip"`, uid))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	w := zip.NewWriter(c.Writer)
+	defer w.Close()
+
+	f, err := w.Create("data.json")
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(data); err != nil {
+		return
+	}
+
+	for i, upload := range data.files {
+		file, err := os.Open(upload)
+		if err != nil {
+			continue
+		}
+		f, err := w.Create(data.Uploads[i])
+		if err == nil {
+			io.Copy(f, file)
+		}
+		file.Close()
+	}
+}
+
+func exportUserData(uid uint64) (*exportData, error) {
+	data := &exportData{
+		Cats:        []exportCat{},
+		Friends:     []exportFriend{},
+		Completions: []exportCompletion{},
+		Trades:      []exportTrade{},
+		Devices:     []exportDevice{},
+		Inbox:       []store.Notification{},
+		Messages:    []store.Message{},
+		TeamInvites: []store.TeamInvitation{},
+		Challenges:  []store.Challenge{},
+		Quests:      []store.Quest{},
+		VerifyEmail: []exportVerifyEmail{},
+		Uploads:     []string{},
+	}
+
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errcode.ErrNotLogin
+	} else if err != nil {
+		return nil, err
+	}
+	data.User = exportUser{
+		Uid:           u.UID,
+		Name:          u.Name,
+		Profile:       u.Profile,
+		Email:         u.Email,
+		Creating:      u.Creating,
+		LastLogin:     u.LastLogin,
+		LastLng:       u.LastLng,
+		LastLat:       u.LastLat,
+		Verified:      u.Verified,
+		ShareGPS:      u.ShareGPS,
+		ShareActivity: u.ShareActivity,
+		Timezone:      u.Timezone,
+	}
+
+	history, err := cats.History(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range history {
+		data.Cats = append(data.Cats, exportCat(c))
+	}
+
+	completions, err := cats.Completions(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range completions {
+		data.Completions = append(data.Completions, exportCompletion(c))
+	}
+
+	for f := (store.TradeFilter{UID: uid, Limit: 100}); ; {
+		list, err := trades.List(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			e := exportTrade{t.TradeID, t.Src, t.Dest, t.Status, t.Creating, t.Updating, []uint64{}, []uint64{}}
+			for _, c := range t.Give {
+				e.Give = append(e.Give, c.CatID)
+			}
+			for _, c := range t.Want {
+				e.Want = append(e.Want, c.CatID)
+			}
+			data.Trades = append(data.Trades, e)
+		}
+		if len(list) < f.Limit {
+			break
+		}
+		f.Before = list[len(list)-1].TradeID
+	}
+
+	relations, err := friends.Relations(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range relations {
+		data.Friends = append(data.Friends, exportFriend{r.Src, r.Dest, r.Accepted, r.Ban})
+	}
+
+	list, err := devices.List(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range list {
+		data.Devices = append(data.Devices, exportDevice{d.Token, d.Platform, d.Creating, d.Updating})
+	}
+
+	for before := uint64(0); ; {
+		list, err := inbox.List(uid, false, before, 100)
+		if err != nil {
+			return nil, err
+		}
+		data.Inbox = append(data.Inbox, list...)
+		if len(list) < 100 {
+			break
+		}
+		before = list[len(list)-1].NotificationID
+	}
+
+	for before := uint64(0); ; {
+		list, err := dms.List(uid, before, 100)
+		if err != nil {
+			return nil, err
+		}
+		data.Messages = append(data.Messages, list...)
+		if len(list) < 100 {
+			break
+		}
+		before = list[len(list)-1].MessageID
+	}
+
+	if m, err := teams.Membership(uid); err == nil {
+		data.Team = &exportTeam{m.TeamID, m.Role, m.Joined}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	invitations, err := teams.Invitations(uid)
+	if err != nil {
+		return nil, err
+	}
+	data.TeamInvites = append(data.TeamInvites, invitations...)
+
+	for f := (store.ChallengeFilter{UID: uid, Limit: 100}); ; {
+		list, err := challenges.List(f)
+		if err != nil {
+			return nil, err
+		}
+		data.Challenges = append(data.Challenges, list...)
+		if len(list) < f.Limit {
+			break
+		}
+		f.Before = list[len(list)-1].ChallengeID
+	}
+
+	if data.Quests, err = objectives.All(uid); err != nil {
+		return nil, err
+	}
+	if data.Streak, err = objectives.Streak(uid); err != nil {
+		return nil, err
+	}
+	if data.Items, err = items.Inventory(uid); err != nil {
+		return nil, err
+	}
+	if data.ItemLog, err = items.Log(uid); err != nil {
+		return nil, err
+	}
+	// 0 includes the effects that have ended
+	if data.Effects, err = items.Effects(uid, 0); err != nil {
+		return nil, err
+	}
+	if data.Code, err = refs.Code(uid); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if ref, err := refs.ReferredBy(uid); err == nil {
+		data.ReferredBy = ref.Referrer
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if data.Referrals, err = refs.List(uid); err != nil {
+		return nil, err
+	}
+
+	verify, err := users.VerifyEmails(uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range verify {
+		data.VerifyEmail = append(data.VerifyEmail, exportVerifyEmail(v))
+	}
+
+	if data.files, err = users.Uploads(uid); err != nil {
+		return nil, err
+	}
+	for _, file := range data.files {
+		data.Uploads = append(data.Uploads, path.Join("uploads", path.Base(file)))
+	}
+
+	return data, nil
+}
+
+type deleteRequest struct {
+	Password string `json:"password"`
+}
+
+// PostDelete deletes the account and everything related to it. The password
+// is required again.
+func PostDelete(c *gin.Context) {
+	req := deleteRequest{}
+	res := struct {
+		Error string `json:"error"`
+	}{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	if err := deleteAccount(c, session.UID(c), req.Password); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+func deleteAccount(c *gin.Context, uid uint64, password string) error {
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrNotLogin
+	} else if err != nil {
+		return err
+	}
+
+	if u.Password != util.PasswordHash(password, u.Salt) {
+		return errcode.ErrWrongPassword
+	}
+	// read before Delete forgets who uploaded them
+	files, err := users.Uploads(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := users.Delete(uid); err != nil {
+		return err
+	}
+	audit.Record(c, audit.AccountDelete, uid, audit.Detail{"email": u.Email})
+
+	session.DestroyUser(uid)
+	for _, file := range files {
+		os.Remove(file)
+	}
+	return nil
+}
@@ -1,14 +1,16 @@
 package user
 
 import (
-	"fmt"
 	"net/mail"
 	"regexp"
+	"strings"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
 )
 
 func checkName(name string) error {
 	if len(name) > 12 && len(name) <= 0 {
-		return fmt.Errorf("名稱需介於 1~12 字元")
+		return errcode.ErrNameLength
 	}
 	return nil
 }
@@ -16,22 +18,30 @@ func checkName(name string) error {
 func checkPasswordFormat(pwd string) error {
 	match, err := regexp.MatchString("^[a-zA-Z0-9_@]{8,40}$", pwd)
 	if err != nil || !match {
-		return fmt.Errorf("密碼僅接受「英文字母、數字、-、_、@」且介於 8 到 40 字元")
+		return errcode.ErrPasswordFormat
 	}
 
 	match, err = regexp.MatchString("^.*?\\d+.*?$", pwd)
 	if err != nil || !match {
-		return fmt.Errorf("密碼必需含有數字")
+		return errcode.ErrPasswordNeedDigit
 	}
 
 	match, err = regexp.MatchString("^.*?[a-zA-Z]+.*?$", pwd)
 	if err != nil || !match {
-		return fmt.Errorf("密碼必需含有英文字母")
+		return errcode.ErrPasswordNeedLetter
 	}
 	return nil
 }
 
+// normalizeEmail returns the form of an email that is stored, looked up and
+// used as the key of the limiters.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func checkEmailFormat(email string) error {
-	_, err := mail.ParseAddress(email)
-	return err
+	if _, err := mail.ParseAddress(email); err != nil {
+		return errcode.ErrEmailFormat
+	}
+	return nil
 }
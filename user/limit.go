@@ -0,0 +1,16 @@
+package user
+
+import (
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/ratelimit"
+)
+
+// per account limits, the per IP limits are set on the routes
+var (
+	loginAccountLimit    = ratelimit.New(10, time.Minute)
+	registerAccountLimit = ratelimit.New(3, time.Hour)
+
+	// lock the account for 15 minutes after 5 wrong passwords in 15 minutes
+	loginLockout = ratelimit.NewLockout(5, 15*time.Minute, 15*time.Minute)
+)
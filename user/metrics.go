@@ -0,0 +1,12 @@
+package user
+
+import "github.com/ksw2000/catch_cat_server/metrics"
+
+var (
+	// result is "success", "failure" (wrong email or password) or "rejected"
+	// (locked or rate limited)
+	loginTotal = metrics.NewCounter("catch_cat_logins_total",
+		"Login attempts by result.", "result")
+	registrationTotal = metrics.NewCounter("catch_cat_registrations_total",
+		"Accounts registered.")
+)
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/openapi"
+	"github.com/ksw2000/catch_cat_server/util"
+)
+
+// Operations documents the /v1 routes of the package.
+var Operations = []openapi.Operation{
+	{
+		Method: http.MethodPost, Path: "/v1/users", Tag: "user",
+		Summary: "Register",
+		Request: registerRequest{},
+		Status:  http.StatusCreated, Response: util.Response{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/sessions", Tag: "user",
+		Summary: "Log in",
+		Request: loginRequest{},
+		Status:  http.StatusCreated, Response: loginResponse{},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/sessions/current", Tag: "user",
+		Summary: "Log out",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me", Tag: "user", Auth: true,
+		Summary: "Get my profile and stats",
+		Status:  http.StatusOK, Response: meResponse{},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/uploads/profile", Tag: "user", Auth: true,
+		Summary: "Upload a profile picture, set it by PATCH /v1/users/me",
+		File:    "profile",
+		Status:  http.StatusCreated, Response: uploadResponse{},
+	},
+	{
+		Method: http.MethodPatch, Path: "/v1/users/me", Tag: "user", Auth: true,
+		Summary: "Update the fields present in the body",
+		Request: patchMeRequest{},
+		Status:  http.StatusOK, Response: meResponse{},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/users/me", Tag: "user", Auth: true,
+		Summary: "Delete my account",
+		Request: deleteRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/users/me/export", Tag: "user", Auth: true,
+		Summary: "Export my data as a zip archive",
+		Status:  http.StatusOK, ContentType: "application/zip",
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/users/me/password", Tag: "user", Auth: true,
+		Summary: "Change my password",
+		Request: passwordRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/users/me/location", Tag: "user", Auth: true,
+		Summary: "Update my position",
+		Request: gpsRequest{},
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/users/me/verification", Tag: "user", Auth: true,
+		Summary: "Send the email verifying my email again",
+		Status:  http.StatusNoContent,
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/users/me/last_login", Tag: "user", Auth: true,
+		Summary: "Touch my last login time",
+		Status:  http.StatusNoContent,
+	},
+}
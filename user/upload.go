@@ -0,0 +1,85 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+type uploadResponse struct {
+	Error string `json:"error"`
+	Path  string `json:"path"`
+}
+
+// POST /upload/profile, POST /v1/uploads/profile
+//
+// Upload a profile picture, which is recorded as mine so that only I can set
+// it as my profile.
+func UploadProfile(c *gin.Context) {
+	file, err := c.FormFile("profile")
+	if err != nil {
+		errcode.Abort(c, errcode.ErrUploadMissing)
+		return
+	}
+	res := uploadResponse{}
+
+	// generate file name
+	res.Path = path.Join(config.UploadRoot, fmt.Sprintf("%s%s", util.RandomString(15), path.Ext(file.Filename)))
+	for fileExist(res.Path) {
+		res.Path = path.Join(config.UploadRoot, fmt.Sprintf("%s%s", util.RandomString(15), path.Ext(file.Filename)))
+	}
+
+	if err := c.SaveUploadedFile(file, res.Path); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if err := users.AddUpload(session.UID(c), res.Path, time.Now().Unix()); err != nil {
+		os.Remove(res.Path)
+		errcode.Abort(c, err)
+		return
+	}
+
+	res.Path = "/" + res.Path
+	c.IndentedJSON(http.StatusCreated, res)
+}
+
+func fileExist(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// uploadedFile maps a path returned by /upload/profile to the file on disk.
+// Links to other places are not ours and are ignored.
+func uploadedFile(link string) (string, bool) {
+	file := path.Clean(strings.TrimPrefix(link, "/"))
+	root := path.Clean(config.UploadRoot)
+	if !strings.HasPrefix(file, root+"/") {
+		return "", false
+	}
+	return file, true
+}
+
+// ownUpload checks that link is a file uploaded by uid.
+func ownUpload(uid uint64, link string) error {
+	file, ok := uploadedFile(link)
+	if !ok {
+		return errcode.ErrProfile
+	}
+	owner, err := users.UploadOwner(file)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && owner != uid) {
+		return errcode.ErrProfile
+	}
+	return err
+}
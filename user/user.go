@@ -1,518 +1,551 @@
 package user
 
 import (
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/mail"
+	"github.com/ksw2000/catch_cat_server/quests"
+	"github.com/ksw2000/catch_cat_server/referrals"
 	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
 	"github.com/ksw2000/catch_cat_server/util"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/mattn/go-sqlite3"
 )
 
-func PostMe(c *gin.Context) {
-	req := struct {
-		Session string `json:"session"`
-	}{}
-	res := struct {
-		IsLogin  bool   `json:"is_login"`
-		Error    string `json:"error"`
-		Name     string `json:"name"`
-		Uid      uint64 `json:"uid"`
-		Profile  string `json:"profile"`
-		Email    string `json:"email"`
-		Verified bool   `json:"verified"`
-		ShareGPS bool   `json:"share_gps"`
-		Score    int    `json:"score"`
-		Level    int    `json:"level"`
-		Cats     int    `json:"cats"`
-	}{}
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
+var (
+	users      store.Users
+	friends    store.Friends
+	cats       store.Cats
+	trades     store.Trades
+	devices    store.Devices
+	inbox      store.Notifications
+	dms        store.Messages
+	teams      store.Teams
+	challenges store.Challenges
+	objectives store.Quests // named apart from the quests package
+	items      store.Items
+	refs       store.Referrals // named apart from the referrals package
+	mailer     mail.Mailer
+)
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		return
-	}
-	res.Uid = uid
+// Init sets the repositories used by the handlers, and m which sends the
+// verification emails.
+func Init(s *store.Store, m mail.Mailer) {
+	users = s.Users
+	friends = s.Friends
+	cats = s.Cats
+	trades = s.Trades
+	devices = s.Devices
+	inbox = s.Notifications
+	dms = s.Messages
+	teams = s.Teams
+	challenges = s.Challenges
+	objectives = s.Quests
+	items = s.Items
+	refs = s.Referrals
+	mailer = m
+}
 
-	db := util.OpenDB()
+type Me struct {
+	Name          string `json:"name"`
+	Uid           uint64 `json:"uid"`
+	Profile       string `json:"profile"`
+	Email         string `json:"email"`
+	Verified      bool   `json:"verified"`
+	ShareGPS      bool   `json:"share_gps"`
+	ShareActivity bool   `json:"share_activity"`
+	Score         int    `json:"score"`
+	Level         int    `json:"level"`
+	Cats          int    `json:"cats"`
+	TeamID        uint64 `json:"team_id"`  // 0 if not in a team
+	Timezone      string `json:"timezone"` // "" for the server's
+}
 
-	row := db.QueryRow("SELECT name, profile, email, verified, share_gps FROM user WHERE user_id = ?", res.Uid)
-	if err := row.Scan(&res.Name, &res.Profile, &res.Email, &res.Verified, &res.ShareGPS); err != nil {
-		res.Error = "database error row.Scan() error"
-		c.IndentedJSON(http.StatusOK, res)
+func PostMe(c *gin.Context) {
+	me, err := getMe(session.UID(c))
+	if err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	res.Cats, res.Score, res.Level = util.GetScoreAndLevel(db, res.Uid)
+	c.IndentedJSON(http.StatusOK, struct {
+		IsLogin bool   `json:"is_login"`
+		Error   string `json:"error"`
+		*Me
+	}{true, "", me})
+}
 
-	res.IsLogin = true
-	c.IndentedJSON(http.StatusOK, res)
+func getMe(uid uint64) (*Me, error) {
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errcode.ErrNotLogin
+	} else if err != nil {
+		return nil, err
+	}
+
+	stats, err := users.Stats(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	teamID := uint64(0)
+	if m, err := teams.Membership(uid); err == nil {
+		teamID = m.TeamID
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	return &Me{
+		Name:          u.Name,
+		Uid:           u.UID,
+		Profile:       u.Profile,
+		Email:         u.Email,
+		Verified:      u.Verified,
+		ShareGPS:      u.ShareGPS,
+		ShareActivity: u.ShareActivity,
+		Score:         stats.Score,
+		Level:         stats.Level(),
+		Cats:          stats.Cats,
+		TeamID:        teamID,
+		Timezone:      u.Timezone,
+	}, nil
 }
 
 func PostUpdateLastLogin(c *gin.Context) {
-	req := struct {
-		Session string `json:"session"`
-	}{}
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := updateLastLogin(session.UID(c)); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	quests.CheckIn(c, session.UID(c))
 
-	db := util.OpenDB()
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
-	stmt, err := db.Prepare("UPDATE user SET last_login = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+func updateLastLogin(uid uint64) error {
+	return users.UpdateLastLogin(uid, time.Now().Unix())
+}
+
+type registerRequest struct {
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+	Email           string `json:"email"`
+	Name            string `json:"name"`
+	ReferralCode    string `json:"referral_code"` // optional
+}
+
+func PostRegister(c *gin.Context) {
+	req := registerRequest{}
+	res := util.Response{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(time.Now().Unix(), uid)
-	if err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+	if err := register(c, &req); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
 	c.IndentedJSON(http.StatusCreated, res)
 }
 
-func PostRegister(c *gin.Context) {
-	req := struct {
-		Password        string `json:"password"`
-		ConfirmPassword string `json:"confirm_password"`
-		Email           string `json:"email"`
-		Name            string `json:"name"`
-	}{}
-	res := struct {
-		Error string `json:"error"`
-	}{}
-
-	if err := c.BindJSON(&req); err != nil {
-		log.Print(err)
-		return
-	}
-
+func register(c *gin.Context, req *registerRequest) error {
+	req.Email = normalizeEmail(req.Email)
 	if err := checkName(req.Name); err != nil {
-		res.Error = err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return err
 	}
 
 	if req.ConfirmPassword != req.Password {
-		res.Error = "密碼與確認密碼不符"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return errcode.ErrPasswordMismatch
 	}
 
 	if err := checkPasswordFormat(req.Password); err != nil {
-		res.Error = err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return err
 	}
 
 	if err := checkEmailFormat(req.Email); err != nil {
-		res.Error = err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return err
 	}
 
-	// TODO: send email
-
-	db := util.OpenDB()
-
-	// check if there are the same email in db
-	var count int
-	row := db.QueryRow("SELECT COUNT(*) FROM user WHERE `email` = ?", req.Email)
-	if err := row.Scan(&count); err != nil {
-		res.Error = fmt.Sprintf("db.QueryRow() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	} else if count > 0 {
-		res.Error = "Email 已經註冊"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if ok, after := registerAccountLimit.Allow(req.Email); !ok {
+		return errcode.ErrTooManyRequests.Retry(after)
 	}
 
-	// check if there are the same user_id in db
-	var uid uint64
-
-	for count := 1; count != 0; {
-		// generate
-		uid = util.GenerateID()
-		// check
-		row := db.QueryRow("SELECT COUNT(*) FROM user WHERE `user_id` = ?", uid)
-		row.Scan(&count)
+	// check if there are the same email in db
+	if exist, err := users.EmailExists(req.Email); err != nil {
+		return err
+	} else if exist {
+		return errcode.ErrEmailRegistered
 	}
 
-	stmt, err := db.Prepare(`
-		INSERT INTO user(user_id, salt, password, name, profile, email, creating, last_login, last_lng, last_lat, verified, share_gps) 
-		values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	referrer := uint64(0)
+	if req.ReferralCode != "" {
+		var err error
+		if referrer, err = referrals.Referrer(req.ReferralCode); err != nil {
+			return err
+		}
 	}
-	defer stmt.Close()
 
 	salt := util.RandomString(256)
-	hashedPassword := util.PasswordHash(req.Password, salt)
-	if _, err = stmt.Exec(uid, salt, hashedPassword, req.Name, "", req.Email, time.Now().Unix(), time.Now().Unix(), 0, 0, false, false); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
+	now := time.Now().Unix()
+	u := &store.User{
+		Salt:          salt,
+		Password:      util.PasswordHash(req.Password, salt),
+		Name:          req.Name,
+		Email:         req.Email,
+		Creating:      now,
+		LastLogin:     now,
+		ShareActivity: true,
+	}
+	if err := users.Create(u); err != nil {
+		return err
+	}
+	registrationTotal.Inc()
+	if referrer != 0 {
+		referrals.Link(c, referrer, u.UID, u.Name)
+	}
+	sendVerification(c, u.UID, u.Email)
+	return nil
+}
 
-	c.IndentedJSON(http.StatusCreated, res)
+type loginRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
 }
 
-func PostLogin(c *gin.Context) {
-	req := struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
-	}{}
+type loginResponse struct {
+	Error   string `json:"error"`
+	Session string `json:"session"`
+	*Me
+}
 
-	res := struct {
-		Error    string `json:"error"`
-		Session  string `json:"session"`
-		Name     string `json:"name"`
-		Uid      uint64 `json:"uid"`
-		Profile  string `json:"profile"`
-		Email    string `json:"email"`
-		Verified bool   `json:"verified"`
-		ShareGPS bool   `json:"share_gps"`
-		Score    int    `json:"score"`
-		Level    int    `json:"level"`
-		Cats     int    `json:"cats"`
-	}{}
+func PostLogin(c *gin.Context) {
+	req := loginRequest{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	db := util.OpenDB()
-
-	var hashedPassword, salt string
-	row := db.QueryRow("SELECT password, salt FROM user WHERE `email` = ?", req.Email)
-	if err := row.Scan(&hashedPassword, &salt); err != nil {
-		res.Error = "尚未註冊"
-		c.IndentedJSON(http.StatusOK, res)
+	res, err := login(c, &req)
+	if err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	if hashedPassword != util.PasswordHash(req.Password, salt) {
-		res.Error = "密碼錯誤"
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
+	c.IndentedJSON(http.StatusOK, res)
+}
 
-	row = db.QueryRow("SELECT name, user_id, profile, email, verified, share_gps FROM user WHERE `email` = ?", req.Email)
-	if err := row.Scan(&res.Name, &res.Uid, &res.Profile, &res.Email, &res.Verified, &res.ShareGPS); err != nil {
-		res.Error = fmt.Sprintf("database error row.Scan() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+func login(c *gin.Context, req *loginRequest) (*loginResponse, error) {
+	account := normalizeEmail(req.Email)
+	if locked, after := loginLockout.Locked(account); locked {
+		loginTotal.Inc("rejected")
+		audit.Record(c, audit.LoginRejected, 0, audit.Detail{"email": account, "reason": "locked"})
+		return nil, errcode.ErrAccountLocked.Retry(after)
+	}
+	if ok, after := loginAccountLimit.Allow(account); !ok {
+		loginTotal.Inc("rejected")
+		audit.Record(c, audit.LoginRejected, 0, audit.Detail{"email": account, "reason": "rate_limited"})
+		return nil, errcode.ErrTooManyRequests.Retry(after)
+	}
+
+	// unknown email and wrong password are reported with the same error so
+	// that accounts can not be enumerated
+	u, err := users.GetByEmail(account)
+	if errors.Is(err, store.ErrNotFound) {
+		util.PasswordHash(req.Password, "")
+		loginLockout.Fail(account)
+		loginTotal.Inc("failure")
+		audit.Record(c, audit.LoginFailure, 0, audit.Detail{"email": account, "reason": "unknown_email"})
+		return nil, errcode.ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if u.Password != util.PasswordHash(req.Password, u.Salt) {
+		loginLockout.Fail(account)
+		loginTotal.Inc("failure")
+		audit.Record(c, audit.LoginFailure, u.UID, audit.Detail{"email": account, "reason": "wrong_password"})
+		return nil, errcode.ErrInvalidCredentials
+	}
+	loginLockout.Reset(account)
+	quests.CheckIn(c, u.UID)
+
+	me, err := getMe(u.UID)
+	if err != nil {
+		return nil, err
 	}
 
-	res.Cats, res.Score, res.Level = util.GetScoreAndLevel(db, res.Uid)
-
-	res.Session = session.NewSession()
-	val, _ := session.Get(res.Session)
-	val["uid"] = res.Uid
+	res := &loginResponse{
+		Session: session.NewSession(me.Uid),
+		Me:      me,
+	}
 
-	c.IndentedJSON(http.StatusOK, res)
+	loginTotal.Inc("success")
+	audit.Record(c, audit.LoginSuccess, u.UID, nil)
+	return res, nil
 }
 
 func PostUpdateName(c *gin.Context) {
 	req := struct {
-		Session string `json:"session"`
-		Name    string `json:"name"`
+		Name string `json:"name"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := updateName(session.UID(c), req.Name); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
-	if err := checkName(req.Name); err != nil {
-		res.Error = err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
+func updateName(uid uint64, name string) error {
+	if err := checkName(name); err != nil {
+		return err
 	}
 
-	db := util.OpenDB()
-
-	stmt, err := db.Prepare("UPDATE user SET name = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.Name, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	c.IndentedJSON(http.StatusCreated, res)
+	return users.UpdateName(uid, name)
 }
 
 func PostUpdateEmail(c *gin.Context) {
 	req := struct {
-		Session string `json:"session"`
-		Email   string `json:"email"`
+		Email string `json:"email"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := updateEmail(c, session.UID(c), req.Email); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
-	if err := checkEmailFormat(req.Email); err != nil {
-		res.Error = err.Error()
-		c.IndentedJSON(http.StatusOK, res)
-		return
+func updateEmail(c *gin.Context, uid uint64, email string) error {
+	email = normalizeEmail(email)
+	if err := checkEmailFormat(email); err != nil {
+		return err
 	}
 
-	db := util.OpenDB()
-
-	stmt, err := db.Prepare("UPDATE user SET email = ?, verified = 0 WHERE user_id = ? and email <> ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrNotLogin
+	} else if err != nil {
+		return err
 	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.Email, uid, req.Email); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if u.Email == email {
+		return nil
 	}
-	c.IndentedJSON(http.StatusCreated, res)
+
+	if err := users.UpdateEmail(uid, email); err != nil {
+		return err
+	}
+	audit.Record(c, audit.EmailChange, uid, audit.Detail{"from": u.Email, "to": email})
+	sendVerification(c, uid, email)
+	return nil
+}
+
+type passwordRequest struct {
+	OriginalPassword string `json:"original_password"`
+	ConfirmPassword  string `json:"confirm_password"`
+	NewPassword      string `json:"new_password"`
 }
 
 func PostUpdatePassword(c *gin.Context) {
-	req := struct {
-		Session          string `json:"session"`
-		OriginalPassword string `json:"original_password"`
-		ConfirmPassword  string `json:"confirm_password"`
-		NewPassword      string `json:"new_password"`
-	}{}
+	req := passwordRequest{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
+	if err := updatePassword(c, session.UID(c), &req); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
+func updatePassword(c *gin.Context, uid uint64, req *passwordRequest) error {
 	// check if NewPassword == ConfirmPassword
 	if req.NewPassword != req.ConfirmPassword {
-		res.Error = "確認密碼與密碼不符合"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return errcode.ErrPasswordMismatch
 	}
 
-	db := util.OpenDB()
-
 	// check password
-	var hashedPassword, salt string
-	row := db.QueryRow("SELECT password, salt FROM user WHERE user_id = ?", uid)
-	if err := row.Scan(&hashedPassword, &salt); err != nil {
-		res.Error = fmt.Sprintf("請重新登入 %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrNotLogin
+	} else if err != nil {
+		return err
 	}
 
-	if hashedPassword != util.PasswordHash(req.OriginalPassword, salt) {
-		res.Error = "密碼錯誤"
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	if u.Password != util.PasswordHash(req.OriginalPassword, u.Salt) {
+		return errcode.ErrOriginalPassword
 	}
 
 	// update
-	salt = util.RandomString(256)
-	hashedPassword = util.PasswordHash(req.NewPassword, salt)
-	stmt, err := db.Prepare("UPDATE user SET salt = ?, password = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-
-	if _, err := stmt.Exec(salt, hashedPassword, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+	salt := util.RandomString(256)
+	if err := users.UpdatePassword(uid, salt, util.PasswordHash(req.NewPassword, salt)); err != nil {
+		return err
 	}
-	c.IndentedJSON(http.StatusCreated, res)
+	audit.Record(c, audit.PasswordChange, uid, nil)
+	return nil
 }
 
 func PostUpdateShareGPS(c *gin.Context) {
 	req := struct {
-		Session    string `json:"session"`
-		ShareOrNot bool   `json:"share_or_not"`
+		ShareOrNot bool `json:"share_or_not"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
-	if err := c.BindJSON(&req); err != nil {
-		return
-	}
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		return
-	}
-	db := util.OpenDB()
-
-	stmt, err := db.Prepare("UPDATE user SET share_gps = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
-	defer stmt.Close()
 
-	if _, err := stmt.Exec(req.ShareOrNot, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+	if err := updateShareGPS(session.UID(c), req.ShareOrNot); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusCreated, res)
 }
 
+func updateShareGPS(uid uint64, share bool) error {
+	return users.UpdateShareGPS(uid, share)
+}
+
+func updateShareActivity(uid uint64, share bool) error {
+	return users.UpdateShareActivity(uid, share)
+}
+
+// timezoneInterval is how long a time zone is kept before it can be changed
+// again, so that moving the days back and forth does not earn quests and
+// check-ins twice.
+const timezoneInterval = 7 * 24 * time.Hour
+
+// updateTimezone sets the time zone in which the days of the quests and the
+// login streak of uid begin.
+func updateTimezone(uid uint64, timezone string) error {
+	if timezone != "" {
+		// LoadLocation also accepts "Local", which depends on the server
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+			return errcode.ErrTimezone
+		}
+	}
+	now := time.Now()
+	err := users.UpdateTimezone(uid, timezone, now.Unix(), now.Add(-timezoneInterval).Unix())
+	if errors.Is(err, store.ErrConflict) {
+		return errcode.ErrTimezoneLimit
+	}
+	return err
+}
+
+type gpsRequest struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
 func PostUpdateGPS(c *gin.Context) {
-	req := struct {
-		Session string  `json:"session"`
-		Lat     float64 `json:"lat"`
-		Lng     float64 `json:"lng"`
-	}{}
+	req := gpsRequest{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		return
-	}
-
-	db := util.OpenDB()
 
-	stmt, err := db.Prepare("UPDATE user SET last_lng = ?, last_lat = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.Lng, req.Lat, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
+	if err := updateGPS(session.UID(c), req.Lat, req.Lng); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 	c.IndentedJSON(http.StatusCreated, res)
 }
 
+func updateGPS(uid uint64, lat, lng float64) error {
+	return users.UpdateGPS(uid, lat, lng)
+}
+
 func PostLogout(c *gin.Context) {
-	req := struct {
-		Session string `json:"session"`
-	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
-	if err := c.BindJSON(&req); err != nil {
+
+	if err := logout(session.Token(c)); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
 
-	val, isLogin := session.Get(req.Session)
+	c.IndentedJSON(http.StatusOK, res)
+}
+
+func logout(token string) error {
+	uid, isLogin := session.Get(token)
 	if !isLogin {
 		// already logout
-		c.IndentedJSON(http.StatusOK, res)
-		return
+		return nil
 	}
 
-	uid := val["uid"].(uint64)
-
-	db := util.OpenDB()
-
-	stmt, _ := db.Prepare("UPDATE user SET last_login=? WHERE user_id=?")
-	if _, err := stmt.Exec(time.Now().Unix(), uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	defer stmt.Close()
+	session.Destroy(token)
 
-	c.IndentedJSON(http.StatusOK, res)
+	return updateLastLogin(uid)
 }
 
 func PostUpdateProfile(c *gin.Context) {
 	req := struct {
-		Session string `json:"session"`
-		Path    string `json:"path"`
+		Path string `json:"path"`
 	}{}
 	res := struct {
 		Error string `json:"error"`
 	}{}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
 		return
 	}
 
-	uid, isLogin := session.CheckLogin(c, req.Session)
-	if !isLogin {
-		c.IndentedJSON(http.StatusUnauthorized, res)
+	if err := updateProfile(session.UID(c), req.Path); err != nil {
+		errcode.Abort(c, err)
 		return
 	}
+	c.IndentedJSON(http.StatusCreated, res)
+}
 
-	db := util.OpenDB()
-
-	stmt, err := db.Prepare("UPDATE user SET profile = ? WHERE user_id = ?")
-	if err != nil {
-		res.Error = fmt.Sprintf("db.Prepare() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
+// updateProfile sets the profile of uid to a file uid uploaded, or clears it
+// if path is "".
+func updateProfile(uid uint64, path string) error {
+	if path != "" {
+		if err := ownUpload(uid, path); err != nil {
+			return err
+		}
 	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(req.Path, uid); err != nil {
-		res.Error = fmt.Sprintf("stmt.Exec() error %v", err)
-		c.IndentedJSON(http.StatusOK, res)
-		return
-	}
-	c.IndentedJSON(http.StatusCreated, res)
+	return users.UpdateProfile(uid, path)
 }
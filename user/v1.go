@@ -0,0 +1,187 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/quests"
+	"github.com/ksw2000/catch_cat_server/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlers of the /v1 API, they share the logic with the legacy POST routes
+
+// POST /v1/users
+func CreateUser(c *gin.Context) {
+	PostRegister(c)
+}
+
+// POST /v1/sessions
+func CreateSession(c *gin.Context) {
+	req := loginRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	res, err := login(c, &req)
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, res)
+}
+
+// DELETE /v1/sessions/current
+func DeleteSession(c *gin.Context) {
+	if err := logout(session.Token(c)); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /v1/users/me
+func GetMe(c *gin.Context) {
+	me, err := getMe(session.UID(c))
+	if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, meResponse{"", me})
+}
+
+type meResponse struct {
+	Error string `json:"error"`
+	*Me
+}
+
+type patchMeRequest struct {
+	Name          *string `json:"name"`
+	Email         *string `json:"email"`
+	Profile       *string `json:"profile"`
+	ShareGPS      *bool   `json:"share_gps"`
+	ShareActivity *bool   `json:"share_activity"`
+	Timezone      *string `json:"timezone"` // an IANA name, "" for the server's
+}
+
+// PATCH /v1/users/me
+//
+// Only the fields present in the body are updated.
+func PatchMe(c *gin.Context) {
+	req := patchMeRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	uid := session.UID(c)
+	if req.Name != nil {
+		if err := updateName(uid, *req.Name); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+	if req.Email != nil {
+		if err := updateEmail(c, uid, *req.Email); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+	if req.Profile != nil {
+		if err := updateProfile(uid, *req.Profile); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+	if req.ShareGPS != nil {
+		if err := updateShareGPS(uid, *req.ShareGPS); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+	if req.ShareActivity != nil {
+		if err := updateShareActivity(uid, *req.ShareActivity); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+	if req.Timezone != nil {
+		if err := updateTimezone(uid, *req.Timezone); err != nil {
+			errcode.Abort(c, err)
+			return
+		}
+	}
+
+	GetMe(c)
+}
+
+// PUT /v1/users/me/password
+func PutPassword(c *gin.Context) {
+	req := passwordRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	if err := updatePassword(c, session.UID(c), &req); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /v1/users/me/location
+func PutLocation(c *gin.Context) {
+	req := gpsRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	if err := updateGPS(session.UID(c), req.Lat, req.Lng); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /v1/users/me/last_login
+//
+// Record that I am back, which also counts the day toward my login streak.
+func PutLastLogin(c *gin.Context) {
+	if err := updateLastLogin(session.UID(c)); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	quests.CheckIn(c, session.UID(c))
+	c.Status(http.StatusNoContent)
+}
+
+// GET /v1/users/me/export
+func GetExport(c *gin.Context) {
+	PostExport(c)
+}
+
+// DELETE /v1/users/me
+func DeleteMe(c *gin.Context) {
+	req := deleteRequest{}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return
+	}
+
+	if err := deleteAccount(c, session.UID(c), req.Password); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/config"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	"github.com/ksw2000/catch_cat_server/logging"
+	"github.com/ksw2000/catch_cat_server/referrals"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+	"github.com/ksw2000/catch_cat_server/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifyExpire is how long the link of a verification email is valid
+const verifyExpire = 24 * time.Hour
+
+// hashToken is how a verification token is stored, so that the rows of
+// verify_email can not verify an email by themselves.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendVerification mails a link verifying email to uid. The registration or
+// the change of the email is done already, so a failure is logged and does
+// not fail the request; the user can ask for the email again.
+func sendVerification(c *gin.Context, uid uint64, email string) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logging.From(c).Error("verification email failed", "uid", uid, "err", err)
+		return
+	}
+	token := hex.EncodeToString(b)
+	if err := users.AddVerifyEmail(uid, email, hashToken(token), time.Now().Add(verifyExpire).Unix()); err != nil {
+		logging.From(c).Error("verification email failed", "uid", uid, "err", err)
+		return
+	}
+	link := config.PublicURL + "/verify/email?token=" + url.QueryEscape(token)
+	body := "請在 24 小時內打開以下連結驗證你的 email：\n\n" + link + "\n\n如果你沒有註冊或修改 email，請忽略這封信。\n"
+	if err := mailer.Send(email, "驗證你的 email", body); err != nil {
+		logging.From(c).Error("verification email failed", "uid", uid, "err", err)
+	}
+}
+
+// GET /verify/email?token=
+//
+// The link of a verification email.
+func GetVerifyEmail(c *gin.Context) {
+	if err := verify(c, c.Query("token")); err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, util.Response{})
+}
+
+func verify(c *gin.Context, token string) error {
+	if token == "" {
+		return errcode.ErrVerifyToken
+	}
+	uid, err := users.Verify(hashToken(token), time.Now().Unix())
+	if errors.Is(err, store.ErrNotFound) {
+		return errcode.ErrVerifyToken
+	} else if err != nil {
+		return err
+	}
+	audit.Record(c, audit.EmailVerify, uid, nil)
+	referrals.Verified(c, uid)
+	return nil
+}
+
+// POST /v1/users/me/verification
+//
+// Send the verification email again.
+func CreateVerification(c *gin.Context) {
+	uid := session.UID(c)
+	u, err := users.Get(uid)
+	if errors.Is(err, store.ErrNotFound) {
+		errcode.Abort(c, errcode.ErrNotLogin)
+		return
+	} else if err != nil {
+		errcode.Abort(c, err)
+		return
+	}
+	if u.Verified {
+		errcode.Abort(c, errcode.ErrAlreadyVerified)
+		return
+	}
+	sendVerification(c, uid, u.Email)
+	c.Status(http.StatusNoContent)
+}
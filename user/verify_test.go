@@ -0,0 +1,168 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ksw2000/catch_cat_server/audit"
+	"github.com/ksw2000/catch_cat_server/errcode"
+	notices "github.com/ksw2000/catch_cat_server/inbox"
+	"github.com/ksw2000/catch_cat_server/referrals"
+	"github.com/ksw2000/catch_cat_server/session"
+	"github.com/ksw2000/catch_cat_server/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// outbox keeps the emails instead of sending them.
+type outbox struct {
+	to    []string
+	links []string
+	err   error
+}
+
+func (o *outbox) Send(to string, subject string, body string) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.to = append(o.to, to)
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "http") {
+			o.links = append(o.links, line)
+		}
+	}
+	return nil
+}
+
+// token returns the token of the last link sent.
+func (o *outbox) token(t *testing.T) string {
+	t.Helper()
+	if len(o.links) == 0 {
+		t.Fatal("no link sent")
+	}
+	u, err := url.Parse(o.links[len(o.links)-1])
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	return u.Query().Get("token")
+}
+
+func setup(t *testing.T) (*store.Store, *outbox) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	s, err := store.OpenMemory()
+	if err != nil {
+		t.Fatalf("OpenMemory: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	o := &outbox{}
+	Init(s, o)
+	audit.Init(s)
+	referrals.Init(s)
+	notices.Init(s)
+	return s, o
+}
+
+func context() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	return c
+}
+
+func verified(t *testing.T, uid uint64) bool {
+	t.Helper()
+	u, err := users.Get(uid)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return u.Verified
+}
+
+func TestVerify(t *testing.T) {
+	_, o := setup(t)
+	req := &registerRequest{Name: "alice", Email: " Alice@Example.com", Password: "password1", ConfirmPassword: "password1"}
+	if err := register(context(), req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	u, err := users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+	if len(o.to) != 1 || o.to[0] != "alice@example.com" {
+		t.Fatalf("sent to %v, want alice@example.com", o.to)
+	}
+	first := o.token(t)
+
+	// a change of the email sends a new link, the old one no longer works
+	if err := updateEmail(context(), u.UID, "alice2@example.com"); err != nil {
+		t.Fatalf("updateEmail: %v", err)
+	}
+	second := o.token(t)
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"no token", "", errcode.ErrVerifyToken},
+		{"unknown token", "meow", errcode.ErrVerifyToken},
+		{"link of the old email", first, errcode.ErrVerifyToken},
+		{"link of the new email", second, nil},
+		{"used twice", second, errcode.ErrVerifyToken},
+	}
+	for _, tt := range tests {
+		if err := verify(context(), tt.token); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if !verified(t, u.UID) {
+		t.Errorf("alice is not verified")
+	}
+}
+
+func TestVerifyMailFailure(t *testing.T) {
+	_, o := setup(t)
+	o.err = errors.New("broken")
+
+	// the user is registered even if the email can not be sent
+	req := &registerRequest{Name: "alice", Email: "alice@example.com", Password: "password1", ConfirmPassword: "password1"}
+	if err := register(context(), req); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := users.GetByEmail("alice@example.com"); err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+}
+
+func TestCreateVerification(t *testing.T) {
+	s, o := setup(t)
+	u := &store.User{Name: "alice", Email: "alice@example.com"}
+	if err := s.Users.Create(u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	r := gin.New()
+	r.POST("/v1/users/me/verification", session.Auth(), CreateVerification)
+	serve := func() (int, string) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/users/me/verification", nil)
+		req.Header.Set("Authorization", "Bearer "+session.NewSession(u.UID))
+		r.ServeHTTP(w, req)
+		res := errcode.Body{}
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w.Code, res.Code
+	}
+
+	if status, code := serve(); status != http.StatusNoContent {
+		t.Fatalf("send = %d %q, want %d", status, code, http.StatusNoContent)
+	}
+	if err := verify(context(), o.token(t)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if status, code := serve(); code != errcode.ErrAlreadyVerified.Code {
+		t.Errorf("send when verified = %d %q, want %q", status, code, errcode.ErrAlreadyVerified.Code)
+	}
+}
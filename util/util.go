@@ -2,14 +2,20 @@ package util
 
 import (
 	"crypto/sha256"
-	"database/sql"
 	"fmt"
 	"math/rand"
+	"strconv"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/gin-gonic/gin"
+	"github.com/ksw2000/catch_cat_server/errcode"
 )
 
+// Response is the body of a successful request which returns nothing else.
+type Response struct {
+	Error string `json:"error"`
+}
+
 func PasswordHash(pwd string, salt string) string {
 	pwd += salt
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(pwd)))
@@ -25,22 +31,19 @@ func RandomString(length int) string {
 	return string(b)
 }
 
-func GetScoreAndLevel(db *sql.DB, uid uint64) (cats int, score int, level int) {
-	// 1 level = 100 score
-	row := db.QueryRow(`
-		SELECT SUM(cat_kind.weight), COUNT(user_cat.cat_id)
-		FROM cat_kind, cat, user_cat 
-		WHERE 
-		user_cat.cat_id = cat.cat_id and 
-		cat.cat_kind_id = cat_kind.cat_kind_id and
-		user_cat.user_id = ?`, uid)
-	row.Scan(&score, &cats)
-	level = score / 100
-	return cats, score, level
-}
-
 func GenerateID() uint64 {
 	s1 := rand.NewSource(time.Now().UnixNano())
 	r1 := rand.New(s1)
 	return r1.Uint64()%900000000000 + 100000000000
 }
+
+// ParamID parses the path parameter key as an id. It aborts the request with
+// errcode.ErrBadRequest and returns false if the parameter is not a number.
+func ParamID(c *gin.Context, key string) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param(key), 10, 64)
+	if err != nil {
+		errcode.Abort(c, errcode.ErrBadRequest)
+		return 0, false
+	}
+	return id, true
+}